@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// topHosts is how many hosts stats reports
+const topHosts = 10
+
+// ShortenResult is printed by shorten
+type ShortenResult struct {
+	URL      string `json:"url"`
+	ShortURL string `json:"short_url"`
+	Created  bool   `json:"created"`
+}
+
+// HostStats counts links to a host
+type HostStats struct {
+	Host  string `json:"host"`
+	Links int    `json:"links"`
+}
+
+// Stats is printed by stats
+type Stats struct {
+	Total   int         `json:"total"`
+	Active  int         `json:"active"`
+	Deleted int         `json:"deleted"`
+	Hosts   []HostStats `json:"hosts"`
+}
+
+func (c *cli) shorten(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	results := []ShortenResult{}
+	rows := [][]string{}
+
+	for _, rawURL := range args {
+		short, created, err := c.client.Shorten(ctx, rawURL)
+		if err != nil {
+			return fmt.Errorf("shortening %s: %w", rawURL, err)
+		}
+
+		results = append(results, ShortenResult{URL: rawURL, ShortURL: short, Created: created})
+		rows = append(rows, []string{short, rawURL, strconv.FormatBool(created)})
+	}
+
+	return c.print(results, []string{"SHORT URL", "URL", "CREATED"}, rows)
+}
+
+func (c *cli) batch(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+
+	in := c.stdin
+
+	if len(args) == 1 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	urls, err := readBatch(in)
+	if err != nil {
+		return err
+	}
+
+	if len(urls) == 0 {
+		return errors.New("no URLs to shorten")
+	}
+
+	result, err := c.client.Batch(ctx, urls)
+	if err != nil {
+		return err
+	}
+
+	original := map[string]string{}
+	for _, u := range urls {
+		original[u.CorrelationID] = u.OriginalURL
+	}
+
+	rows := [][]string{}
+
+	for i, r := range result {
+		result[i].OriginalURL = original[r.CorrelationID]
+		rows = append(rows, []string{r.ShortURL, original[r.CorrelationID]})
+	}
+
+	return c.print(result, []string{"SHORT URL", "URL"}, rows)
+}
+
+// readBatch parses lines of "URL" or "CODE URL", skipping blank lines and # comments.
+// URLs without a code get a random one since the server uses correlation ids as short codes.
+func readBatch(r io.Reader) ([]storage.BatchURL, error) {
+	urls := []storage.BatchURL{}
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+
+		switch len(fields) {
+		case 1:
+			code, err := randomCode()
+			if err != nil {
+				return nil, err
+			}
+
+			urls = append(urls, storage.BatchURL{CorrelationID: code, OriginalURL: fields[0]})
+		case 2:
+			urls = append(urls, storage.BatchURL{CorrelationID: fields[0], OriginalURL: fields[1]})
+		default:
+			return nil, fmt.Errorf("line %d: expected \"URL\" or \"CODE URL\"", line)
+		}
+	}
+
+	return urls, scanner.Err()
+}
+
+func randomCode() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	urls, err := c.client.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, u := range urls {
+		rows = append(rows, []string{u.ShortURL, u.URL, strconv.FormatBool(u.IsDeleted), u.WorkspaceID})
+	}
+
+	return c.print(urls, []string{"SHORT URL", "URL", "DELETED", "WORKSPACE"}, rows)
+}
+
+func (c *cli) delete(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	// full short URLs as printed by other commands are passed as is, the server takes their domain from them
+	hashes := args
+
+	if err := c.client.Delete(ctx, hashes); err != nil {
+		return err
+	}
+
+	result := map[string]any{"accepted": hashes}
+
+	return c.print(result, []string{"ACCEPTED FOR DELETION"}, [][]string{{strings.Join(hashes, " ")}})
+}
+
+func (c *cli) stats(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	urls, err := c.client.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	stats := Stats{Total: len(urls), Hosts: []HostStats{}}
+	hosts := map[string]int{}
+
+	for _, u := range urls {
+		if u.IsDeleted {
+			stats.Deleted++
+			continue
+		}
+
+		stats.Active++
+
+		if parsed, err := url.Parse(u.URL); err == nil {
+			hosts[parsed.Hostname()]++
+		}
+	}
+
+	for host, n := range hosts {
+		stats.Hosts = append(stats.Hosts, HostStats{Host: host, Links: n})
+	}
+
+	sort.Slice(stats.Hosts, func(i, j int) bool {
+		if stats.Hosts[i].Links != stats.Hosts[j].Links {
+			return stats.Hosts[i].Links > stats.Hosts[j].Links
+		}
+
+		return stats.Hosts[i].Host < stats.Hosts[j].Host
+	})
+
+	if len(stats.Hosts) > topHosts {
+		stats.Hosts = stats.Hosts[:topHosts]
+	}
+
+	rows := [][]string{
+		{"total", strconv.Itoa(stats.Total)},
+		{"active", strconv.Itoa(stats.Active)},
+		{"deleted", strconv.Itoa(stats.Deleted)},
+	}
+
+	for _, h := range stats.Hosts {
+		rows = append(rows, []string{"host " + h.Host, strconv.Itoa(h.Links)})
+	}
+
+	return c.print(stats, []string{"METRIC", "VALUE"}, rows)
+}
+
+func (c *cli) export(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	format := fs.String("format", "csv", "csv or json")
+	output := fs.String("o", "-", "output file, - is stdout")
+
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	urls, err := c.client.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := c.stdout
+
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		out = f
+	}
+
+	if *format == "json" {
+		return json.NewEncoder(out).Encode(urls)
+	}
+
+	w := csv.NewWriter(out)
+	_ = w.Write([]string{"short_url", "original_url", "is_deleted", "workspace_id"})
+
+	for _, u := range urls {
+		_ = w.Write([]string{u.ShortURL, u.URL, strconv.FormatBool(u.IsDeleted), u.WorkspaceID})
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
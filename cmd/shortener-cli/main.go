@@ -0,0 +1,175 @@
+// Command shortener-cli shortens, lists, deletes and exports links through the shortener HTTP API
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/T-V-N/gourlshortener/internal/client"
+)
+
+const usage = `usage: shortener-cli [flags] <command> [args]
+
+commands:
+  shorten URL...            shorten URLs
+  batch [FILE]              shorten URLs from FILE or stdin, one "URL" or "CODE URL" per line
+  list                      list your links
+  delete CODE|SHORT_URL...  delete links
+  stats                     summarize your links
+  export [-format csv|json] [-o FILE]
+                            export your links
+
+flags:`
+
+// errUsage makes run print the usage and exit with 2
+var errUsage = errors.New("invalid usage")
+
+// cli holds global flags and the streams of a run
+type cli struct {
+	client    *client.Client
+	tokenFile string
+	json      bool
+	stdin     io.Reader
+	stdout    io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("shortener-cli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	server := fs.String("server", envOr("SHORTENER_URL", "http://localhost:8080"), "server URL, SHORTENER_URL")
+	tokenFile := fs.String("token-file", envOr("SHORTENER_TOKEN_FILE", defaultTokenFile()), "file keeping the auth token between runs, SHORTENER_TOKEN_FILE")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	c := &cli{
+		client:    client.New(*server, readToken(*tokenFile)),
+		tokenFile: *tokenFile,
+		json:      *asJSON,
+		stdin:     stdin,
+		stdout:    stdout,
+	}
+
+	commands := map[string]func(ctx context.Context, args []string) error{
+		"shorten": c.shorten,
+		"batch":   c.batch,
+		"list":    c.list,
+		"delete":  c.delete,
+		"stats":   c.stats,
+		"export":  c.export,
+	}
+
+	command, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+
+		return 2
+	}
+
+	token := c.client.Token
+	err := command(ctx, fs.Args()[1:])
+
+	if c.client.Token != token {
+		if err := writeToken(c.tokenFile, c.client.Token); err != nil {
+			fmt.Fprintln(stderr, "can't save the auth token:", err)
+		}
+	}
+
+	switch {
+	case errors.Is(err, errUsage):
+		fs.Usage()
+		return 2
+	case err != nil:
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+
+	return fallback
+}
+
+// defaultTokenFile is in the user config dir, or the working dir if it is unknown
+func defaultTokenFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".shortener-token"
+	}
+
+	return filepath.Join(dir, "shortener", "token")
+}
+
+// readToken returns the saved token or an empty one so that the server issues a new user
+func readToken(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// writeToken saves the token readable by the user only
+func writeToken(path, token string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(token+"\n"), 0o600)
+}
+
+// print writes v as JSON if --json is set and as a table of header and rows otherwise
+func (c *cli) print(v any, header []string, rows [][]string) error {
+	if c.json {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	cfg := &config.Config{SecretKey: "secret"}
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	h := handler.InitHandler(a)
+
+	router := chi.NewRouter()
+	router.Use(auth.InitAuth(cfg))
+	router.Post("/api/shorten", h.HandleShortenURL)
+	router.Post("/api/shorten/batch", h.HandleShortenBatchURL)
+	router.Get("/api/user/urls", h.HandleListURL)
+	router.Delete("/api/user/urls", h.HandleDeleteListURL)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	cfg.BaseURL = server.URL
+
+	return server
+}
+
+func Test_CLI(t *testing.T) {
+	server := newTestServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "shortener", "token")
+	exportFile := filepath.Join(t.TempDir(), "links.csv")
+
+	cliRun := func(stdin string, args ...string) (int, string, string) {
+		stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+		args = append([]string{"-server", server.URL, "-token-file", tokenFile}, args...)
+		code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+
+		return code, stdout.String(), stderr.String()
+	}
+
+	tests := []struct {
+		name     string
+		stdin    string
+		args     []string
+		wantCode int
+		check    func(t *testing.T, stdout string)
+	}{
+		{
+			name:     "list before anything is shortened",
+			args:     []string{"-json", "list"},
+			wantCode: 0,
+			check: func(t *testing.T, stdout string) {
+				assert.JSONEq(t, "[]", stdout)
+			},
+		},
+		{
+			name:     "shorten prints a table",
+			args:     []string{"shorten", "https://youtube.com"},
+			wantCode: 0,
+			check: func(t *testing.T, stdout string) {
+				assert.Contains(t, stdout, "SHORT URL")
+				assert.Contains(t, stdout, server.URL+"/e62e2446")
+			},
+		},
+		{
+			name:     "batch from stdin",
+			stdin:    "# links\nabc123 https://go.dev\n\nhttps://pkg.go.dev\n",
+			args:     []string{"-json", "batch"},
+			wantCode: 0,
+			check: func(t *testing.T, stdout string) {
+				result := []storage.BatchURL{}
+				assert.NoError(t, json.Unmarshal([]byte(stdout), &result))
+				assert.Len(t, result, 2)
+				assert.Equal(t, server.URL+"/abc123", result[0].ShortURL)
+				assert.Equal(t, "https://go.dev", result[0].OriginalURL)
+			},
+		},
+		{
+			name:     "the token is reused between runs",
+			args:     []string{"-json", "list"},
+			wantCode: 0,
+			check: func(t *testing.T, stdout string) {
+				urls := []storage.URL{}
+				assert.NoError(t, json.Unmarshal([]byte(stdout), &urls))
+				assert.Len(t, urls, 3)
+			},
+		},
+		{
+			name:     "stats",
+			args:     []string{"-json", "stats"},
+			wantCode: 0,
+			check: func(t *testing.T, stdout string) {
+				stats := Stats{}
+				assert.NoError(t, json.Unmarshal([]byte(stdout), &stats))
+				assert.Equal(t, 3, stats.Total)
+				assert.Equal(t, 3, stats.Active)
+				assert.Len(t, stats.Hosts, 3)
+			},
+		},
+		{
+			name:     "export to a file",
+			args:     []string{"export", "-o", exportFile},
+			wantCode: 0,
+			check: func(t *testing.T, stdout string) {
+				data, err := os.ReadFile(exportFile)
+				assert.NoError(t, err)
+				assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 4)
+				assert.True(t, strings.HasPrefix(string(data), "short_url,original_url,is_deleted,workspace_id\n"))
+			},
+		},
+		{
+			name:     "delete accepts short URLs",
+			args:     []string{"delete", server.URL + "/abc123", "e62e2446"},
+			wantCode: 0,
+			check: func(t *testing.T, stdout string) {
+				assert.Contains(t, stdout, "abc123 e62e2446")
+			},
+		},
+		{name: "shorten an invalid URL", args: []string{"shorten", "not a url"}, wantCode: 1},
+		{name: "unknown command", args: []string{"frobnicate"}, wantCode: 2},
+		{name: "shorten without args", args: []string{"shorten"}, wantCode: 2},
+		{name: "unknown export format", args: []string{"export", "-format", "xml"}, wantCode: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := cliRun(tt.stdin, tt.args...)
+
+			assert.Equal(t, tt.wantCode, code, stderr)
+
+			if tt.check != nil {
+				tt.check(t, stdout)
+			}
+		})
+	}
+
+	t.Run("token file is private", func(t *testing.T) {
+		info, err := os.Stat(tokenFile)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+}
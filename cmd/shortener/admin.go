@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/maintenance"
+	"github.com/T-V-N/gourlshortener/internal/migrate"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+const adminUsage = `usage: shortener admin [server flags] <command> [command flags]
+
+Manages the configured storage directly, without starting the server.
+Server flags (-c, -f, -d, ...) select the storage like for the server.
+
+commands:
+  export   [-format ndjson|csv] [-o file]            write all urls including deleted ones
+  import   [-format ndjson|csv] [-include-deleted] [file]
+                                                      add urls from a file or stdin, taken short urls are kept
+  purge                                               remove soft-deleted urls, compacts the storage file
+  reassign -from uid -to uid                          move all urls of a user to another one
+  verify                                              check every line of the storage files
+  copy     -to-file path | -to-dsn dsn                copy urls and users to another storage
+  counts   [-json]                                    print url counts per user
+  migrate  -format bitly|yourls-sql|yourls-csv -uid uid [-domain domain] [-json] [file]
+                                                      add links exported by another shortener from a file or stdin`
+
+// runAdmin implements the admin subcommand and returns the exit code
+func runAdmin(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg, rest, err := config.LoadArgs(args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if len(rest) == 0 {
+		fmt.Fprintln(stderr, adminUsage)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	cmd, cmdArgs := rest[0], rest[1:]
+
+	if cmd == "verify" {
+		return adminVerify(cfg, stdout, stderr)
+	}
+
+	run, ok := adminCommands[cmd]
+	if cmd == "migrate" {
+		run, ok = adminMigrate(cfg), true
+	}
+
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s\n", cmd, adminUsage)
+		return 2
+	}
+
+	st, err := maintenance.Open(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, "opening storage:", err)
+		return 1
+	}
+
+	defer st.KillConn()
+
+	err = run(ctx, st, cmdArgs, stdin, stdout)
+
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	return 0
+}
+
+// usageError reports bad command flags
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+type adminCommand func(ctx context.Context, st storage.Storage, args []string, stdin io.Reader, stdout io.Writer) error
+
+var adminCommands = map[string]adminCommand{
+	"export":   adminExport,
+	"import":   adminImport,
+	"purge":    adminPurge,
+	"reassign": adminReassign,
+	"copy":     adminCopy,
+	"counts":   adminCounts,
+}
+
+// parseFlags parses args with fs, errors are returned as usageError carrying the flag usage
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	out := &strings.Builder{}
+	fs.SetOutput(out)
+
+	if err := fs.Parse(args); err != nil {
+		return usageError{strings.TrimSpace(out.String())}
+	}
+
+	return nil
+}
+
+func adminExport(ctx context.Context, st storage.Storage, args []string, _ io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", maintenance.FormatNDJSON, "ndjson or csv")
+	out := fs.String("o", "", "output file, stdout if empty")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	w := stdout
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		w = f
+	}
+
+	n, err := maintenance.Export(ctx, st, w, *format)
+	if err != nil {
+		return err
+	}
+
+	if *out != "" {
+		fmt.Fprintf(stdout, "exported %d urls to %s\n", n, *out)
+	}
+
+	return nil
+}
+
+func adminImport(ctx context.Context, st storage.Storage, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", maintenance.FormatNDJSON, "ndjson or csv")
+	includeDeleted := fs.Bool("include-deleted", false, "import deleted urls and mark them deleted")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	r := stdin
+
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		r = f
+	}
+
+	res, err := maintenance.Import(ctx, st, r, *format, *includeDeleted)
+	fmt.Fprintf(stdout, "imported %d, existing %d, deleted %d\n", res.Imported, res.Existing, res.Deleted)
+
+	return err
+}
+
+func adminPurge(ctx context.Context, st storage.Storage, args []string, _ io.Reader, stdout io.Writer) error {
+	if err := parseFlags(flag.NewFlagSet("purge", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+
+	n, err := st.PurgeDeleted(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "purged %d urls\n", n)
+
+	return nil
+}
+
+func adminReassign(ctx context.Context, st storage.Storage, args []string, _ io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("reassign", flag.ContinueOnError)
+	from := fs.String("from", "", "uid to move urls from")
+	to := fs.String("to", "", "uid to move urls to")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *from == "" || *to == "" || *from == *to {
+		return usageError{"reassign: -from and -to must be different uids"}
+	}
+
+	n, err := st.ReassignURLs(ctx, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "reassigned %d urls\n", n)
+
+	return nil
+}
+
+func adminCopy(ctx context.Context, st storage.Storage, args []string, _ io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("copy", flag.ContinueOnError)
+	toFile := fs.String("to-file", "", "destination file storage path")
+	toDSN := fs.String("to-dsn", "", "destination database connection string")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if (*toFile == "") == (*toDSN == "") {
+		return usageError{"copy: exactly one of -to-file and -to-dsn is required"}
+	}
+
+	dst, err := maintenance.Open(&config.Config{FileStoragePath: *toFile, DatabaseDSN: *toDSN})
+	if err != nil {
+		return fmt.Errorf("opening destination: %w", err)
+	}
+
+	defer dst.KillConn()
+
+	res, err := maintenance.Copy(ctx, st, dst)
+	fmt.Fprintf(stdout, "urls: copied %d, existing %d (deleted %d)\nusers: copied %d, existing %d\n",
+		res.URLs.Imported, res.URLs.Existing, res.URLs.Deleted, res.Users, res.ExistingUser)
+
+	return err
+}
+
+func adminCounts(ctx context.Context, st storage.Storage, args []string, _ io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("counts", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	counts, err := maintenance.Counts(ctx, st)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return json.NewEncoder(stdout).Encode(counts)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UID\tACTIVE\tDELETED")
+
+	for _, c := range counts {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", c.UID, c.Active, c.Deleted)
+	}
+
+	return tw.Flush()
+}
+
+// adminMigrate returns the migrate command, domains are looked up in cfg
+func adminMigrate(cfg *config.Config) adminCommand {
+	return func(ctx context.Context, st storage.Storage, args []string, stdin io.Reader, stdout io.Writer) error {
+		fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+		format := fs.String("format", "", "bitly, yourls-sql or yourls-csv")
+		uid := fs.String("uid", "", "uid the links are bound to")
+		domain := fs.String("domain", "", "domain or base url the links are bound to, the default domain if empty")
+		asJSON := fs.Bool("json", false, "print the result as JSON")
+
+		if err := parseFlags(fs, args); err != nil {
+			return err
+		}
+
+		if *format == "" || *uid == "" {
+			return usageError{"migrate: -format and -uid are required"}
+		}
+
+		opts := migrate.Options{Format: *format, UID: *uid}
+
+		if *domain != "" {
+			var ok bool
+			if opts.Domain, ok = cfg.LookupDomain(*domain); !ok {
+				return usageError{fmt.Sprintf("migrate: domain %q isn't configured", *domain)}
+			}
+		}
+
+		r := stdin
+
+		if fs.NArg() > 0 {
+			f, err := os.Open(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+
+			defer f.Close()
+
+			r = f
+		}
+
+		res, err := migrate.Import(ctx, st, r, opts)
+
+		if *asJSON {
+			if encErr := json.NewEncoder(stdout).Encode(res); encErr != nil {
+				return encErr
+			}
+
+			return err
+		}
+
+		for _, rep := range res.Reports {
+			fmt.Fprintf(stdout, "item %d %s: %s %s", rep.Item, rep.Outcome, rep.Code, rep.URL)
+
+			if rep.NewCode != "" {
+				fmt.Fprintf(stdout, " -> %s", rep.NewCode)
+			}
+
+			fmt.Fprintf(stdout, " (%s)\n", rep.Reason)
+		}
+
+		if res.Omitted > 0 {
+			fmt.Fprintf(stdout, "%d more not listed\n", res.Omitted)
+		}
+
+		fmt.Fprintf(stdout, "kept %d, renamed %d, existing %d, conflicts %d, invalid %d\n",
+			res.Kept, res.Renamed, res.Existing, res.Conflicts, res.Invalid)
+
+		return err
+	}
+}
+
+// adminVerify checks the storage files without loading them, so it works on files the storage can't read
+func adminVerify(cfg *config.Config, stdout, stderr io.Writer) int {
+	if cfg.FileStoragePath == "" {
+		fmt.Fprintln(stderr, "verify: no file storage path is configured")
+		return 2
+	}
+
+	reports, err := storage.VerifyFiles(cfg.FileStoragePath)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	code := 0
+
+	for _, r := range reports {
+		fmt.Fprintf(stdout, "%s: %d lines, %d records, %d superseded, %d problems\n", r.Path, r.Lines, r.Records, r.Superseded, len(r.Problems))
+
+		for _, p := range r.Problems {
+			fmt.Fprintf(stdout, "  line %d: %s\n", p.Line, p.Error)
+		}
+
+		if !r.OK() {
+			code = 1
+		}
+	}
+
+	return code
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+)
+
+const configUsage = `usage: shortener config print [flags]
+
+Prints the effective config (flags > env > config file > defaults) as JSON with secrets redacted
+and reports validation problems. Accepts the same flags as the server.`
+
+// runConfig implements the config subcommand and returns the exit code
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+
+	cfg, err := config.Load(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(cfg.Redacted()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	warnings, err := cfg.Validate()
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	return 0
+}
@@ -1,48 +1,332 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/audit"
+	"github.com/T-V-N/gourlshortener/internal/bloom"
+	"github.com/T-V-N/gourlshortener/internal/cache"
 	"github.com/T-V-N/gourlshortener/internal/config"
 	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/health"
+	"github.com/T-V-N/gourlshortener/internal/imports"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/metrics"
+	"github.com/T-V-N/gourlshortener/internal/middleware/admin"
 	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
-	"github.com/T-V-N/gourlshortener/internal/middleware/gzip"
+	"github.com/T-V-N/gourlshortener/internal/middleware/chain"
+	"github.com/T-V-N/gourlshortener/internal/pages"
+	"github.com/T-V-N/gourlshortener/internal/profiler"
+	"github.com/T-V-N/gourlshortener/internal/tlsutil"
+	"github.com/T-V-N/gourlshortener/internal/tracing"
 
 	"github.com/T-V-N/gourlshortener/internal/storage"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
+// deletionMaxLag is how long staged deletions may wait before readiness reports them as degraded
+const deletionMaxLag = time.Minute
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdmin(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	cfg, err := config.Init()
 	if err != nil {
-		log.Panic("error: %w", err)
+		fatal("can't load config", err)
+	}
+
+	warnings, err := cfg.Validate()
+	if err != nil {
+		fatal("invalid config", err)
+	}
+
+	l, err := logger.New(cfg, os.Stderr)
+	if err != nil {
+		fatal("can't init logger", err)
+	}
+
+	slog.SetDefault(l)
+
+	for _, w := range warnings {
+		slog.Warn("config: " + w)
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background(), cfg)
+	if err != nil {
+		fatal("can't init tracing", err)
+	}
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("flushing traces", "error", err)
+		}
+	}()
+
+	live := config.NewLive(cfg, os.Args[1:])
+	metrics.ConfigLastReloadSuccess.Set(1)
+	metrics.ConfigLastReloadTimestamp.SetToCurrentTime()
+
+	go watchReload(live)
+
+	background, stopBackground := context.WithCancel(logger.WithContext(context.Background(), l))
+	defer stopBackground()
+
+	base := storage.InitStorage(map[string]storage.URL{}, cfg)
+	st := metrics.InstrumentStorage(base)
+	invs := []storage.Invalidator{}
+
+	if cfg.CacheSize > 0 {
+		cached := cache.CacheStorage(st, cache.Options{
+			Size:        cfg.CacheSize,
+			TTL:         time.Duration(cfg.CacheTTLSeconds) * time.Second,
+			NegativeTTL: time.Duration(cfg.CacheNegativeTTLSeconds) * time.Second,
+		})
+		invs = append(invs, cached)
+		st = cached
+	}
+
+	if cfg.BloomCapacity > 0 {
+		filtered := bloom.FilterStorage(st, bloom.Options{
+			Capacity:          cfg.BloomCapacity,
+			FalsePositiveRate: cfg.BloomFalsePositiveRate,
+			RebuildInterval:   time.Duration(cfg.BloomRebuildSeconds) * time.Second,
+		})
+		invs = append(invs, filtered)
+		st = filtered
+
+		go filtered.Run(background)
+	}
+
+	// other instances sharing the database report their changes
+	if db, ok := base.(*storage.DBStorage); ok && len(invs) > 0 {
+		go db.ListenChanges(background, invs...)
+	}
+
+	st = tracing.TraceStorage(st)
+	a := app.NewApp(st, live)
+
+	sink, err := audit.InitSink(cfg)
+	if err != nil {
+		fatal("can't init audit sink", err)
 	}
 
-	st := storage.InitStorage(map[string]storage.URL{}, cfg)
-	a := app.NewApp(st, cfg)
+	a.Audit = audit.NewLog(sink, cfg.AuditKey)
 	a.Init()
 	h := handler.InitHandler(a)
-	authMw := auth.InitAuth(cfg)
+
+	if cfg.PagesDir != "" {
+		if h.Pages, err = pages.New(cfg.PagesDir); err != nil {
+			fatal("can't load page templates", err)
+		}
+	}
+
+	if cfg.ImportsDir != "" {
+		if h.Imports, err = imports.New(cfg.ImportsDir, a); err != nil {
+			fatal("can't load import jobs", err)
+		}
+
+		h.Imports.Retention = time.Duration(cfg.ImportsRetentionSeconds) * time.Second
+		go h.Imports.Run(background)
+	}
+
+	authMw := auth.New(live, a)
+
+	checker := health.New()
+	checker.Add("storage.read", health.StorageRead(st))
+	checker.Add("storage.write", health.StorageWrite(st))
+	checker.Add("migrations", health.Migrations(st))
+	checker.Add("deletion", health.PipelineLag(a.DeletionLag, deletionMaxLag))
 
 	router := chi.NewRouter()
 
-	router.Use(gzip.GzipHandle)
-	router.Use(authMw)
-	router.Use(middleware.Compress(5))
-	router.Mount("/debug", middleware.Profiler())
+	router.Use(chain.New(chain.Options{Logger: l, Config: cfg, Auth: authMw})...)
 	router.Get("/{urlHash}", h.HandleGetURL)
 	router.Post("/", h.HandlePostURL)
 	router.Post("/api/shorten", h.HandleShortenURL)
 	router.Get("/api/user/urls", h.HandleListURL)
+	router.Get("/api/user/urls/export", h.HandleExportURL)
+	router.Post("/api/user/urls/migrate", h.HandleMigrateURL)
 	router.Delete("/api/user/urls", h.HandleDeleteListURL)
 	router.Post("/api/shorten/batch", h.HandleShortenBatchURL)
 	router.Get("/ping", h.HandlePing)
-
-	log.Panic(http.ListenAndServe(a.Config.ServerAddress, router))
+	router.Get("/healthz", checker.HandleHealthz)
+	router.Get("/readyz", checker.HandleReadyz)
+	router.Post("/api/user/register", h.HandleRegister)
+	router.Post("/api/user/login", h.HandleLogin)
+	router.Post("/api/user/logout", h.HandleLogout)
+	router.Post("/api/user/password", h.HandleChangePassword)
+	router.Post("/api/user/claim", h.HandleClaim)
+	router.Post("/api/imports", h.HandleCreateImport)
+	router.Get("/api/imports/{importID}", h.HandleGetImport)
+	router.Get("/api/imports/{importID}/result", h.HandleGetImportResult)
+	router.Route("/api/workspaces", func(r chi.Router) {
+		r.Post("/", h.HandleCreateWorkspace)
+		r.Get("/", h.HandleListWorkspaces)
+		r.Get("/{workspaceID}/members", h.HandleListMembers)
+		r.Put("/{workspaceID}/members", h.HandleSetMember)
+		r.Delete("/{workspaceID}/members/{memberUID}", h.HandleRemoveMember)
+		r.Get("/{workspaceID}/urls", h.HandleListWorkspaceURL)
+		r.Post("/{workspaceID}/urls", h.HandleWorkspaceShortenURL)
+		r.Delete("/{workspaceID}/urls", h.HandleDeleteWorkspaceURL)
+	})
+	router.Route("/api/admin", func(r chi.Router) {
+		r.Use(admin.InitAdmin(live))
+		r.Get("/audit", h.HandleAuditQuery)
+		r.Get("/audit/verify", h.HandleAuditVerify)
+	})
 
 	defer st.KillConn()
+	defer a.Audit.Close()
+
+	prof := profiler.New(cfg.ProfilesDir)
+
+	switch cfg.ProfilerMode {
+	case config.ProfilerOff, "":
+	case config.ProfilerAdmin:
+	case config.ProfilerProtected:
+		trusted, err := admin.InitTrusted(live)
+		if err != nil {
+			fatal("can't start profiler", err)
+		}
+
+		router.With(trusted).Mount("/api/admin/debug", prof.Handler())
+	default:
+		fatal("can't start profiler", fmt.Errorf("unknown profiler mode %q", cfg.ProfilerMode))
+	}
+
+	if cfg.AdminAddress != "" {
+		adminRouter := chi.NewRouter()
+		adminRouter.Handle("/metrics", metrics.Handler())
+
+		if cfg.ProfilerMode == config.ProfilerAdmin {
+			adminRouter.Mount("/debug", prof.Handler())
+		}
+
+		go func() {
+			slog.Info("starting admin server", "address", cfg.AdminAddress)
+
+			if err := http.ListenAndServe(cfg.AdminAddress, adminRouter); err != nil {
+				fatal("admin server stopped", err)
+			}
+		}()
+	} else {
+		router.Handle("/metrics", metrics.Handler())
+	}
+
+	server := &http.Server{Addr: cfg.ServerAddress, Handler: router}
+
+	if cfg.EnableHTTPS {
+		server.TLSConfig, err = tlsutil.New(tlsutil.Options{
+			CertFile:     cfg.TLSCertFile,
+			KeyFile:      cfg.TLSKeyFile,
+			SelfSigned:   cfg.TLSSelfSigned,
+			Hosts:        certHosts(append([]string{cfg.BaseURL}, cfg.Domains...)...),
+			MinVersion:   cfg.TLSMinVersion,
+			CipherSuites: cfg.TLSCipherSuites,
+		})
+		if err != nil {
+			fatal("can't init tls", err)
+		}
+
+		if cfg.HTTPRedirectAddress != "" {
+			go func() {
+				slog.Info("starting http redirect server", "address", cfg.HTTPRedirectAddress)
+
+				if err := http.ListenAndServe(cfg.HTTPRedirectAddress, domainRedirect(live)); err != nil {
+					fatal("http redirect server stopped", err)
+				}
+			}()
+		}
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	served := make(chan error, 1)
+
+	go func() {
+		slog.Info("starting server", "address", cfg.ServerAddress, "https", cfg.EnableHTTPS)
+
+		if cfg.EnableHTTPS {
+			served <- server.ListenAndServeTLS("", "")
+		} else {
+			served <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-served:
+		fatal("server stopped", err)
+	case <-stop.Done():
+		// a second signal kills the process right away
+		cancel()
+	}
+
+	drain(server, checker, cfg)
+}
+
+// drain marks the service not ready, waits for load balancers to notice and shuts the server down
+// letting in-flight requests finish
+func drain(server *http.Server, checker *health.Checker, cfg *config.Config) {
+	slog.Info("draining", "delay_seconds", cfg.DrainDelaySeconds)
+	checker.SetDraining()
+	time.Sleep(time.Duration(cfg.DrainDelaySeconds) * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("shutting down", "error", err)
+		return
+	}
+
+	slog.Info("server stopped")
+}
+
+// certHosts returns the hosts a self-signed certificate is issued for: the hosts of the base urls and loopback
+func certHosts(baseURLs ...string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+
+	for _, baseURL := range baseURLs {
+		if u, err := url.Parse(baseURL); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+
+	return hosts
+}
+
+// domainRedirect redirects plain HTTP requests to the base URL of the requested domain
+func domainRedirect(cfg config.Provider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := cfg.Get()
+		domain, _ := c.LookupDomain(r.Host)
+
+		tlsutil.RedirectHandler(c.DomainBaseURL(domain)).ServeHTTP(w, r)
+	})
+}
+
+// fatal logs err and exits, deferred calls are skipped as with log.Fatal
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/metrics"
+)
+
+// watchReload reloads live on every SIGHUP
+func watchReload(live *config.Live) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	for range ch {
+		reload(live)
+	}
+}
+
+// reload re-reads the config, applies the log level and reports the result in logs and metrics
+func reload(live *config.Live) {
+	changed, warnings, err := live.Reload()
+
+	metrics.ConfigLastReloadTimestamp.SetToCurrentTime()
+
+	for _, w := range warnings {
+		slog.Warn("config: " + w)
+	}
+
+	if err != nil {
+		result := "error"
+		if errors.Is(err, config.ErrNotReloadable) {
+			result = "rejected"
+		}
+
+		metrics.ConfigReloads.WithLabelValues(result).Inc()
+		metrics.ConfigLastReloadSuccess.Set(0)
+		slog.Error("config reload failed, keeping the current config", "error", err)
+
+		return
+	}
+
+	if level, err := logger.ParseLevel(live.Get().LogLevel); err == nil {
+		logger.Level.Set(level)
+	}
+
+	metrics.ConfigReloads.WithLabelValues("ok").Inc()
+	metrics.ConfigLastReloadSuccess.Set(1)
+	slog.Info("config reloaded", "changed", strings.Join(changed, ","))
+}
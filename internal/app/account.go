@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/T-V-N/gourlshortener/internal/audit"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength is the shortest password accepted on registration
+const minPasswordLength = 8
+
+var (
+	// ErrInvalidCredentials is returned when a login or a password is empty, too short or doesn't match
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	// ErrAlreadyRegistered is returned when the login is taken or the current uid already belongs to an account
+	ErrAlreadyRegistered = errors.New("already registered")
+	// ErrNotRegistered is returned when an action requires an account but the uid is anonymous
+	ErrNotRegistered = errors.New("uid doesn't belong to an account")
+	// ErrWeakPassword is returned when a new password is too short
+	ErrWeakPassword = errors.New("password is too short")
+)
+
+// Register creates an account with login and password. The account adopts the current anonymous uid,
+// so all URLs created before the registration stay with the user.
+func (app *App) Register(ctx context.Context, uid, login, password string) error {
+	if login == "" || len(password) < minPasswordLength {
+		return ErrInvalidCredentials
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	err = app.DB.SaveUser(ctx, storage.User{UID: uid, Login: login, PasswordHash: string(hash)})
+	if errors.Is(err, storage.ErrUserExists) {
+		return ErrAlreadyRegistered
+	}
+
+	if err != nil {
+		return err
+	}
+
+	app.record(ctx, audit.ActionUserRegister, uid, login, nil, map[string]string{"uid": uid, "login": login})
+
+	return nil
+}
+
+// Login checks the credentials and returns the account. If claim is set and the current uid is anonymous,
+// its URLs are claimed by the account; the number of claimed URLs is returned as well.
+func (app *App) Login(ctx context.Context, uid, login, password string, claim bool) (storage.User, int, error) {
+	u, err := app.checkPassword(ctx, login, password)
+	if err != nil {
+		return storage.User{}, 0, err
+	}
+
+	if !claim || uid == "" || uid == u.UID || app.isAccount(ctx, uid) {
+		return u, 0, nil
+	}
+
+	claimed, err := app.reassign(ctx, uid, uid, u.UID)
+	if err != nil {
+		return storage.User{}, 0, err
+	}
+
+	return u, claimed, nil
+}
+
+// Logout revokes all sessions of the account with uid, nothing is done for an anonymous uid
+func (app *App) Logout(ctx context.Context, uid string) error {
+	u, err := app.DB.GetUserByUID(ctx, uid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	u.SessionVersion++
+
+	return app.DB.UpdateUser(ctx, u)
+}
+
+// ChangePassword replaces the password of the account with uid and revokes all its sessions.
+// The updated account is returned, so the caller can be issued a new session.
+func (app *App) ChangePassword(ctx context.Context, uid, oldPassword, newPassword string) (storage.User, error) {
+	u, err := app.DB.GetUserByUID(ctx, uid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return storage.User{}, ErrNotRegistered
+	}
+
+	if err != nil {
+		return storage.User{}, err
+	}
+
+	if len(newPassword) < minPasswordLength {
+		return storage.User{}, ErrWeakPassword
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(oldPassword)) != nil {
+		return storage.User{}, ErrInvalidCredentials
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return storage.User{}, err
+	}
+
+	u.PasswordHash = string(hash)
+	u.SessionVersion++
+
+	if err = app.DB.UpdateUser(ctx, u); err != nil {
+		return storage.User{}, err
+	}
+
+	app.record(ctx, audit.ActionUserPassword, uid, u.Login, nil, map[string]int{"session_version": u.SessionVersion})
+
+	return u, nil
+}
+
+// SessionVersion returns the current session version of the account with uid, isAccount is false for an anonymous uid
+func (app *App) SessionVersion(ctx context.Context, uid string) (int, bool, error) {
+	u, err := app.DB.GetUserByUID(ctx, uid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return u.SessionVersion, true, nil
+}
+
+// checkPassword returns the account with login if the password matches
+func (app *App) checkPassword(ctx context.Context, login, password string) (storage.User, error) {
+	u, err := app.DB.GetUserByLogin(ctx, login)
+	if errors.Is(err, storage.ErrNotFound) {
+		return storage.User{}, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return storage.User{}, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return storage.User{}, ErrInvalidCredentials
+	}
+
+	return u, nil
+}
+
+// Claim moves all URLs of the anonymous fromUID to the account with uid and returns the number of moved URLs
+func (app *App) Claim(ctx context.Context, uid, fromUID string) (int, error) {
+	if !app.isAccount(ctx, uid) {
+		return 0, ErrNotRegistered
+	}
+
+	if fromUID == uid || app.isAccount(ctx, fromUID) {
+		return 0, ErrAlreadyRegistered
+	}
+
+	return app.reassign(ctx, uid, fromUID, uid)
+}
+
+// reassign moves URLs between uids on behalf of actorUID and records the claim
+func (app *App) reassign(ctx context.Context, actorUID, fromUID, toUID string) (int, error) {
+	moved, err := app.DB.ReassignURLs(ctx, fromUID, toUID)
+	if err != nil {
+		return 0, err
+	}
+
+	app.record(ctx, audit.ActionURLClaim, actorUID, fromUID, map[string]string{"uid": fromUID}, map[string]interface{}{"uid": toUID, "moved": moved})
+
+	return moved, nil
+}
+
+func (app *App) isAccount(ctx context.Context, uid string) bool {
+	_, err := app.DB.GetUserByUID(ctx, uid)
+	return err == nil
+}
+
+// LookupUID returns the uid of the account with login or storage.ErrNotFound
+func (app *App) LookupUID(ctx context.Context, login string) (string, error) {
+	u, err := app.DB.GetUserByLogin(ctx, login)
+	if err != nil {
+		return "", err
+	}
+
+	return u.UID, nil
+}
@@ -5,27 +5,47 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"log"
+	"errors"
 	"net/url"
 	"time"
 
+	"github.com/T-V-N/gourlshortener/internal/audit"
 	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/metrics"
 	"github.com/T-V-N/gourlshortener/internal/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrInvalidURL is returned for URLs that can't be shortened
+var ErrInvalidURL = errors.New("invalid url")
+
+// tracer starts business logic spans, it delegates to the provider installed by tracing.Init
+var tracer = otel.Tracer("github.com/T-V-N/gourlshortener/internal/app")
+
 // App struct contains all the necessary objects for app to perform storage CRUD and the business logic proccess
 type App struct {
 	DB         storage.Storage            // file, db or memory-storage
-	Config     *config.Config             // set of configs
+	cfg        config.Provider            // set of configs, may be reloaded while running
+	Audit      *audit.Log                 // audit log of destructive actions, nothing is recorded if nil
 	deleteChan chan storage.DeletionEntry // channel used by an URL deletion goroutine
+	deletion   deletionStats              // backlog of the deletion goroutine
 }
 
 // NewApp creates and returns an application from st storage and cfg config.
-func NewApp(st storage.Storage, cfg *config.Config) *App {
-	app := &App{DB: st, Config: cfg}
+// Pass a *config.Live to let the app pick up reloaded settings.
+func NewApp(st storage.Storage, cfg config.Provider) *App {
+	app := &App{DB: st, cfg: cfg}
 	return app
 }
 
+// Config returns the current config
+func (app *App) Config() *config.Config {
+	return app.cfg.Get()
+}
+
 // Init inits an app: creates a deletion channel and starts a deletion goroutine
 func (app *App) Init() {
 	delChan := make(chan storage.DeletionEntry)
@@ -37,54 +57,88 @@ func (app *App) Init() {
 func (app *App) deletionConsumer(ch chan storage.DeletionEntry) {
 	buff := []storage.DeletionEntry{}
 	ticker := time.NewTicker(10 * time.Second)
+
+	flush := func() {
+		ctx, span := tracer.Start(context.Background(), "app.flushDeletions")
+		span.SetAttributes(attribute.Int("count", len(buff)))
+
+		defer span.End()
+
+		deleted, err := app.DB.DeleteURLs(ctx, buff)
+		app.deletion.flushed(len(buff), err)
+
+		if err != nil {
+			logger.FromContext(ctx).Error("deleting urls", "error", err, "count", len(buff))
+		} else {
+			metrics.LinksDeleted.Add(float64(len(buff)))
+			app.recordDeleted(ctx, buff, deleted)
+		}
+
+		buff = buff[:0]
+		metrics.DeletionBuffered.Set(0)
+	}
+
 	for {
 		select {
 		case el := <-ch:
 			if len(buff) == 5 {
-				err := app.DB.DeleteURLs(context.Background(), buff)
-
-				if err != nil {
-					log.Println(err)
-				}
-
-				buff = buff[:0]
+				flush()
 			}
 
-			buff = append(buff, storage.DeletionEntry{Hash: el.Hash, UID: el.UID})
-		case <-ticker.C:
-			err := app.DB.DeleteURLs(context.Background(), buff)
-
-			if err != nil {
-				log.Println(err)
-			}
+			buff = append(buff, el)
 
-			buff = buff[:0]
+			metrics.DeletionPending.Dec()
+			metrics.DeletionBuffered.Set(float64(len(buff)))
+		case <-ticker.C:
+			flush()
 		}
 	}
 }
 
 // SaveURL parses a rawURL string, creates short handle (stripped md5 hash of the link) and saves into a storage
-func (app *App) SaveURL(ctx context.Context, rawURL, UID string) (string, error) {
-	_, err := url.ParseRequestURI(rawURL)
+// binding it to domain, see ResolveDomain
+func (app *App) SaveURL(ctx context.Context, rawURL, UID, domain string) (string, error) {
+	return app.saveURL(ctx, storage.URL{URL: rawURL, UID: UID, Domain: domain})
+}
+
+// saveURL validates u.URL, fills in its short handle and saves it
+func (app *App) saveURL(ctx context.Context, u storage.URL) (string, error) {
+	ctx, span := tracer.Start(ctx, "app.SaveURL")
+	defer span.End()
+
+	_, err := url.ParseRequestURI(u.URL)
 	if err != nil {
-		return rawURL, err
+		return u.URL, err
 	}
 
-	hash := md5.Sum([]byte(rawURL))
-	stringHash := hex.EncodeToString(hash[:4])
+	stringHash := Hash(u.URL)
+	u.ShortURL = stringHash
+	u.CreatedAt = time.Now().UTC()
 
-	err = app.DB.SaveURL(ctx, rawURL, UID, stringHash)
+	err = app.DB.SaveURL(ctx, u)
 
 	if err != nil {
 		return stringHash, err
 	}
 
-	return app.Config.BaseURL + "/" + stringHash, nil
+	metrics.LinksCreated.Inc()
+	app.record(ctx, audit.ActionURLCreate, u.UID, stringHash, nil, auditState(u))
+
+	return app.ShortURL(u.Domain, stringHash), nil
 }
 
-// GetURL searches for and URL having id and if found returns it
-func (app *App) GetURL(ctx context.Context, id string) (storage.URL, error) {
-	u, err := app.DB.GetURL(ctx, id)
+// Hash returns the short handle SaveURL gives rawURL: the stripped md5 hash of the link
+func Hash(rawURL string) string {
+	hash := md5.Sum([]byte(rawURL))
+	return hex.EncodeToString(hash[:4])
+}
+
+// GetURL searches for and URL having id on domain and if found returns it
+func (app *App) GetURL(ctx context.Context, domain, id string) (storage.URL, error) {
+	ctx, span := tracer.Start(ctx, "app.GetURL", trace.WithAttributes(attribute.String("domain", domain), attribute.String("hash", id)))
+	defer span.End()
+
+	u, err := app.DB.GetURL(ctx, domain, id)
 
 	if err != nil {
 		return storage.URL{}, err
@@ -95,10 +149,13 @@ func (app *App) GetURL(ctx context.Context, id string) (storage.URL, error) {
 
 // GetURLByUID tries to search all URLs bound to a user with UID and returns a list of URLs
 func (app *App) GetURLByUID(uid string, ctx context.Context) ([]storage.URL, error) {
+	ctx, span := tracer.Start(ctx, "app.GetURLByUID")
+	defer span.End()
+
 	u, err := app.DB.GetUrlsByUID(ctx, uid)
 
 	for i, el := range u {
-		u[i].ShortURL = app.Config.BaseURL + "/" + el.ShortURL
+		u[i].ShortURL = app.ShortURL(el.Domain, el.ShortURL)
 	}
 
 	if err != nil {
@@ -108,6 +165,14 @@ func (app *App) GetURLByUID(uid string, ctx context.Context) ([]storage.URL, err
 	return u, nil
 }
 
+// ForEachUserURL streams every URL of uid including deleted ones ordered by domain and hash to fn
+func (app *App) ForEachUserURL(ctx context.Context, uid string, fn func(storage.URL) error) error {
+	ctx, span := tracer.Start(ctx, "app.ForEachUserURL")
+	defer span.End()
+
+	return app.DB.ForEachUserURL(ctx, uid, fn)
+}
+
 // PingStorage just checks whether the app storage connection is alive or not
 func (app *App) PingStorage(ctx context.Context) error {
 	_, err := app.DB.IsAlive(ctx)
@@ -118,36 +183,166 @@ func (app *App) PingStorage(ctx context.Context) error {
 	return nil
 }
 
-// BatchSaveURL takes a list of URLs and saves them binding to a user with UID
-func (app *App) BatchSaveURL(ctx context.Context, obj []storage.BatchURL, uid string) ([]storage.BatchURL, error) {
+// BatchResult is the outcome of shortening an URL of a batch, see BatchSaveChunk
+type BatchResult struct {
+	CorrelationID string `json:"correlation_id"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Error         string `json:"error,omitempty"` // why the URL was skipped
+}
+
+// BatchSaveURL takes a list of URLs and saves them binding to a user with UID and to domain,
+// unless an URL requests another configured domain. Invalid URLs are skipped.
+func (app *App) BatchSaveURL(ctx context.Context, obj []storage.BatchURL, uid, domain string) ([]storage.BatchURL, error) {
+	ctx, span := tracer.Start(ctx, "app.BatchSaveURL", trace.WithAttributes(attribute.Int("count", len(obj))))
+	defer span.End()
+
 	urls := []storage.URL{}
 	responseURLs := []storage.BatchURL{}
 
 	for _, rawURL := range obj {
-		u, err := url.ParseRequestURI(rawURL.OriginalURL)
+		u, err := app.batchURL(rawURL, uid, domain)
+		if errors.Is(err, ErrUnknownDomain) {
+			return nil, err
+		}
+
 		if err != nil {
 			continue
 		}
 
-		hash := rawURL.CorrelationID
-
-		urls = append(urls, storage.URL{UID: uid, ShortURL: hash, URL: u.String()})
-		responseURLs = append(responseURLs, storage.BatchURL{OriginalURL: "", CorrelationID: hash, ShortURL: app.Config.BaseURL + "/" + hash})
+		urls = append(urls, u)
+		responseURLs = append(responseURLs, storage.BatchURL{OriginalURL: "", CorrelationID: u.ShortURL, ShortURL: app.ShortURL(u.Domain, u.ShortURL)})
 	}
 
-	err := app.DB.BatchSaveURL(ctx, urls)
-	if err != nil {
+	metrics.BatchSize.Observe(float64(len(obj)))
+
+	if err := app.saveBatch(ctx, urls); err != nil {
 		return nil, err
 	}
 
 	return responseURLs, nil
 }
 
-// DeleteListURL stages rawHashes list containing hashes of urls for deletion.
-func (app *App) DeleteListURL(ctx context.Context, rawHashes []string, uid string) error {
+// BatchSaveChunk saves a chunk of a streamed batch like BatchSaveURL, but reports every URL: skipped ones,
+// including those on unknown domains, get a result with an error. The error is returned if saving failed,
+// then none of the URLs were saved.
+func (app *App) BatchSaveChunk(ctx context.Context, obj []storage.BatchURL, uid, domain string) ([]BatchResult, error) {
+	ctx, span := tracer.Start(ctx, "app.BatchSaveChunk", trace.WithAttributes(attribute.Int("count", len(obj))))
+	defer span.End()
+
+	urls := make([]storage.URL, 0, len(obj))
+	results := make([]BatchResult, 0, len(obj))
+
+	for _, rawURL := range obj {
+		u, err := app.batchURL(rawURL, uid, domain)
+		if err != nil {
+			results = append(results, BatchResult{CorrelationID: rawURL.CorrelationID, Error: err.Error()})
+			continue
+		}
+
+		urls = append(urls, u)
+		results = append(results, BatchResult{CorrelationID: u.ShortURL, ShortURL: app.ShortURL(u.Domain, u.ShortURL)})
+	}
+
+	metrics.BatchSize.Observe(float64(len(obj)))
+
+	if err := app.saveBatch(ctx, urls); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// batchURL validates an URL of a batch and returns it as stored, its hash is the correlation id
+func (app *App) batchURL(rawURL storage.BatchURL, uid, domain string) (storage.URL, error) {
+	u, err := url.ParseRequestURI(rawURL.OriginalURL)
+	if err != nil {
+		return storage.URL{}, ErrInvalidURL
+	}
+
+	if rawURL.Domain != "" {
+		if domain, err = app.ResolveDomain(rawURL.Domain, ""); err != nil {
+			return storage.URL{}, err
+		}
+	}
+
+	return storage.URL{UID: uid, ShortURL: rawURL.CorrelationID, URL: u.String(), Domain: domain, CreatedAt: time.Now().UTC()}, nil
+}
+
+// saveBatch saves validated urls of a batch and records them
+func (app *App) saveBatch(ctx context.Context, urls []storage.URL) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	if err := app.DB.BatchSaveURL(ctx, urls); err != nil {
+		return err
+	}
+
+	app.RecordCreated(ctx, urls)
+
+	return nil
+}
+
+// RecordCreated counts urls saved to the storage directly and audits their creation by their owners.
+// The events of urls are appended to the audit log at once.
+func (app *App) RecordCreated(ctx context.Context, urls []storage.URL) {
+	metrics.LinksCreated.Add(float64(len(urls)))
+
+	if app.Audit == nil {
+		return
+	}
+
+	events := make([]audit.Event, 0, len(urls))
+	for _, u := range urls {
+		events = append(events, auditEvent(audit.ActionURLCreate, u.UID, u.ShortURL, nil, auditState(u)))
+	}
+
+	app.recordMany(ctx, events)
+}
+
+// recordDeleted audits URLs deleted by a flush of entries, the actor of a URL is the uid of its entry
+func (app *App) recordDeleted(ctx context.Context, entries []storage.DeletionEntry, deleted []storage.URL) {
+	if app.Audit == nil || len(deleted) == 0 {
+		return
+	}
+
+	actors := make(map[storage.DeletionEntry]string, len(entries))
+	for _, e := range entries {
+		actors[storage.DeletionEntry{Domain: e.Domain, Hash: e.Hash}] = e.UID
+	}
+
+	events := make([]audit.Event, 0, len(deleted))
+
+	for _, after := range deleted {
+		before := after
+		before.IsDeleted = false
+
+		actor := actors[storage.DeletionEntry{Domain: after.Domain, Hash: after.ShortURL}]
+		events = append(events, auditEvent(audit.ActionURLDelete, actor, after.ShortURL, auditState(before), auditState(after)))
+	}
+
+	app.recordMany(ctx, events)
+}
+
+// DeleteListURL stages rawHashes list for deletion. Its items are hashes of urls on domain or short urls.
+// Deletions are audited once they are flushed to the storage.
+func (app *App) DeleteListURL(ctx context.Context, rawHashes []string, uid, domain string) error {
+	ctx, span := tracer.Start(ctx, "app.DeleteListURL", trace.WithAttributes(attribute.Int("count", len(rawHashes))))
+	defer span.End()
+
+	entries := make([]storage.DeletionEntry, 0, len(rawHashes))
+
+	for _, rawHash := range rawHashes {
+		urlDomain, hash := app.parseShortURL(rawHash, domain)
+		entries = append(entries, storage.DeletionEntry{Hash: hash, UID: uid, Domain: urlDomain})
+	}
+
+	metrics.DeletionPending.Add(float64(len(entries)))
+	app.deletion.staged(len(entries))
+
 	go func() {
-		for _, rawHash := range rawHashes {
-			app.deleteChan <- storage.DeletionEntry{Hash: rawHash, UID: uid}
+		for _, e := range entries {
+			app.deleteChan <- e
 		}
 	}()
 
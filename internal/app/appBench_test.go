@@ -34,6 +34,6 @@ func BenchmarkSaveUrl(b *testing.B) {
 	a.Init()
 
 	for i := 0; i < b.N; i++ {
-		a.SaveURL(context.Background(), GenURL(), "test")
+		a.SaveURL(context.Background(), GenURL(), "test", "")
 	}
 }
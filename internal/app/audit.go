@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/audit"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// auditURL is the URL state stored in audit events, unlike storage.URL it keeps the owner uid
+type auditURL struct {
+	UID         string    `json:"uid"`
+	ShortURL    string    `json:"short_url"`
+	URL         string    `json:"original_url"`
+	IsDeleted   bool      `json:"is_deleted"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	Domain      string    `json:"domain,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func auditState(u storage.URL) auditURL {
+	return auditURL(u)
+}
+
+// record writes an audit event. A failing audit sink is logged and doesn't fail the action itself.
+func (app *App) record(ctx context.Context, action, actorUID, target string, before, after interface{}) {
+	if err := app.Audit.Record(ctx, auditEvent(action, actorUID, target, before, after)); err != nil {
+		logger.FromContext(ctx).Error("recording audit event", "error", err, "action", action, "target", target)
+	}
+}
+
+// recordMany writes events with a single sink call, failures are handled like in record
+func (app *App) recordMany(ctx context.Context, events []audit.Event) {
+	if err := app.Audit.RecordMany(ctx, events); err != nil {
+		logger.FromContext(ctx).Error("recording audit events", "error", err, "count", len(events))
+	}
+}
+
+func auditEvent(action, actorUID, target string, before, after interface{}) audit.Event {
+	e := audit.Event{Action: action, ActorUID: actorUID, Target: target}
+
+	if before != nil {
+		e.Before, _ = json.Marshal(before)
+	}
+
+	if after != nil {
+		e.After, _ = json.Marshal(after)
+	}
+
+	return e
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/health"
+)
+
+// deletionStats tracks work staged for the deletion consumer, it is read by health checks
+type deletionStats struct {
+	outstanding atomic.Int64           // entries staged but not yet flushed
+	since       atomic.Int64           // unix nanos of the last flush or of staging into an empty queue
+	lastErr     atomic.Pointer[string] // error of the last flush, nil if it succeeded
+}
+
+func (s *deletionStats) staged(n int) {
+	if s.outstanding.Add(int64(n)) == int64(n) {
+		s.since.Store(time.Now().UnixNano())
+	}
+}
+
+func (s *deletionStats) flushed(n int, err error) {
+	s.outstanding.Add(-int64(n))
+
+	if err != nil {
+		msg := err.Error()
+		s.lastErr.Store(&msg)
+
+		return
+	}
+
+	s.lastErr.Store(nil)
+	s.since.Store(time.Now().UnixNano())
+}
+
+// DeletionLag returns the backlog of staged deletions. Its age is counted from the later of the last successful
+// flush and the moment the backlog became non-empty.
+func (app *App) DeletionLag() health.Lag {
+	lag := health.Lag{Outstanding: app.deletion.outstanding.Load()}
+
+	if lag.Outstanding > 0 {
+		lag.Age = time.Since(time.Unix(0, app.deletion.since.Load()))
+	}
+
+	if msg := app.deletion.lastErr.Load(); msg != nil {
+		lag.LastError = *msg
+	}
+
+	return lag
+}
@@ -0,0 +1,90 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/audit"
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingDeletes fails every deletion
+type failingDeletes struct {
+	storage.Storage
+}
+
+func (s failingDeletes) DeleteURLs(context.Context, []storage.DeletionEntry) ([]storage.URL, error) {
+	return nil, errors.New("storage is down")
+}
+
+func Test_DeletionAudit(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       bool
+		wantEvents int
+	}{
+		{name: "deleted urls are audited after the flush", wantEvents: 4},
+		{name: "a failed flush isn't audited", fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := &config.Config{}
+
+			var st storage.Storage = storage.InitStorage(map[string]storage.URL{}, cfg)
+			if tt.fail {
+				st = failingDeletes{st}
+			}
+
+			a := app.NewApp(st, cfg)
+			a.Audit = audit.NewLog(audit.NewMemorySink(), "")
+			a.Init()
+
+			hashes := []string{}
+
+			for i := 0; i < 4; i++ {
+				_, err := a.SaveURL(ctx, "https://deleted.example/"+strconv.Itoa(i), "owner", "")
+				require.NoError(t, err)
+
+				hashes = append(hashes, app.Hash("https://deleted.example/"+strconv.Itoa(i)))
+			}
+
+			_, err := a.SaveURL(ctx, "https://foreign.example", "stranger", "")
+			require.NoError(t, err)
+
+			// the sixth entry flushes the first five, the foreign url among them is skipped
+			hashes = append(hashes, app.Hash("https://foreign.example"), "unknown")
+			require.NoError(t, a.DeleteListURL(ctx, hashes, "owner", ""))
+
+			deletions := func() []audit.Event {
+				events, err := a.Audit.Query(ctx, audit.Filter{Action: audit.ActionURLDelete})
+				require.NoError(t, err)
+
+				return events
+			}
+
+			if tt.wantEvents == 0 {
+				assert.Eventually(t, func() bool { return a.DeletionLag().LastError != "" }, time.Second, 10*time.Millisecond)
+				assert.Empty(t, deletions())
+
+				return
+			}
+
+			assert.Eventually(t, func() bool { return len(deletions()) == tt.wantEvents }, time.Second, 10*time.Millisecond)
+
+			for _, e := range deletions() {
+				assert.Equal(t, "owner", e.ActorUID)
+				assert.Contains(t, string(e.After), `"is_deleted":true`)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ErrUnknownDomain is returned when a link is requested on a domain that isn't configured
+var ErrUnknownDomain = errors.New("unknown domain")
+
+// ShortURL returns the short URL of hash on domain
+func (app *App) ShortURL(domain, hash string) string {
+	return app.Config().DomainBaseURL(domain) + "/" + hash
+}
+
+// DomainForHost returns the domain links are looked up in for a request to host.
+// Hosts that aren't configured are served the default domain.
+func (app *App) DomainForHost(host string) string {
+	domain, _ := app.Config().LookupDomain(host)
+	return domain
+}
+
+// ResolveDomain returns the domain a new link is bound to: requested (a domain or a base URL) if set,
+// otherwise the domain of host
+func (app *App) ResolveDomain(requested, host string) (string, error) {
+	if requested == "" {
+		return app.DomainForHost(host), nil
+	}
+
+	domain, ok := app.Config().LookupDomain(requested)
+	if !ok {
+		return "", ErrUnknownDomain
+	}
+
+	return domain, nil
+}
+
+// parseShortURL splits raw, either a short URL or a bare hash of domain, into its domain and hash.
+// Short URL hosts are resolved like request hosts, see DomainForHost.
+func (app *App) parseShortURL(raw, domain string) (string, string) {
+	if !strings.Contains(raw, "://") {
+		return domain, raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return domain, raw
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+
+	return app.DomainForHost(u.Host), path[strings.LastIndex(path, "/")+1:]
+}
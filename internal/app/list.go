@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// ErrInvalidCursor is returned for list cursors which weren't issued by ListURLs
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// URLPage is a page of the URLs of a user
+type URLPage struct {
+	URLs []storage.URL // URLs with full short URLs
+	Next string        // cursor of the next page, empty on the last one
+}
+
+// cursor is the position of the last URL of a page, it is passed to clients as base64 encoded JSON
+type cursor struct {
+	CreatedAt time.Time `json:"c"`
+	Domain    string    `json:"d"`
+	Hash      string    `json:"h"`
+}
+
+// EncodeCursor returns an opaque cursor of the pages following u
+func EncodeCursor(u storage.URL) string {
+	raw, _ := json.Marshal(cursor{CreatedAt: u.CreatedAt, Domain: u.Domain, Hash: u.ShortURL})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor returns the URL position a cursor of EncodeCursor points at
+func DecodeCursor(raw string) (storage.URL, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return storage.URL{}, ErrInvalidCursor
+	}
+
+	c := cursor{}
+	if err := json.Unmarshal(data, &c); err != nil || c.Hash == "" {
+		return storage.URL{}, ErrInvalidCursor
+	}
+
+	return storage.URL{CreatedAt: c.CreatedAt, Domain: c.Domain, ShortURL: c.Hash}, nil
+}
+
+// ListURLs returns a page of the URLs of q.UID. The next page is requested with the same query
+// and the Next cursor decoded into q.After, the cursor is only meaningful for the same sort order.
+func (app *App) ListURLs(ctx context.Context, q storage.URLQuery) (URLPage, error) {
+	ctx, span := tracer.Start(ctx, "app.ListURLs")
+	defer span.End()
+
+	limit := q.Limit
+	if limit > 0 {
+		q.Limit++ // one more URL tells whether there is a next page
+	}
+
+	urls, err := app.DB.QueryURLs(ctx, q)
+	if err != nil {
+		return URLPage{}, err
+	}
+
+	page := URLPage{URLs: urls}
+
+	if limit > 0 && len(urls) > limit {
+		page.URLs = urls[:limit]
+		page.Next = EncodeCursor(page.URLs[limit-1])
+	}
+
+	for i, u := range page.URLs {
+		page.URLs[i].ShortURL = app.ShortURL(u.Domain, u.ShortURL)
+	}
+
+	return page, nil
+}
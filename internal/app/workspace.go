@@ -0,0 +1,191 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	"github.com/T-V-N/gourlshortener/internal/audit"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+var (
+	// ErrForbidden is returned when a user has no role in a workspace or the role is too weak for an action
+	ErrForbidden = errors.New("forbidden")
+	// ErrLastOwner is returned when an action would leave a workspace without owners
+	ErrLastOwner = errors.New("workspace must keep at least one owner")
+	// ErrInvalidRole is returned when a role is not one of the workspace roles
+	ErrInvalidRole = errors.New("invalid role")
+)
+
+// authorize checks that uid has at least minRole in the workspace
+func (app *App) authorize(ctx context.Context, uid, workspaceID, minRole string) error {
+	role, err := app.DB.GetRole(ctx, workspaceID, uid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrForbidden
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !storage.RoleAtLeast(role, minRole) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// CreateWorkspace creates a workspace named name and makes uid its owner
+func (app *App) CreateWorkspace(ctx context.Context, uid, name string) (storage.Workspace, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return storage.Workspace{}, err
+	}
+
+	ws := storage.Workspace{ID: hex.EncodeToString(id), Name: name}
+
+	if err := app.DB.CreateWorkspace(ctx, ws, uid); err != nil {
+		return storage.Workspace{}, err
+	}
+
+	app.record(ctx, audit.ActionWorkspaceCreate, uid, ws.ID, nil, ws)
+
+	ws.Role = storage.RoleOwner
+
+	return ws, nil
+}
+
+// GetWorkspaces returns all workspaces uid is a member of
+func (app *App) GetWorkspaces(ctx context.Context, uid string) ([]storage.Workspace, error) {
+	return app.DB.GetWorkspacesByUID(ctx, uid)
+}
+
+// GetMembers returns members of a workspace, any member may list them
+func (app *App) GetMembers(ctx context.Context, uid, workspaceID string) ([]storage.Member, error) {
+	if err := app.authorize(ctx, uid, workspaceID, storage.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	return app.DB.GetMembers(ctx, workspaceID)
+}
+
+// SetMember adds a member or changes the member role, only owners may do it
+func (app *App) SetMember(ctx context.Context, uid string, m storage.Member) error {
+	if !storage.IsValidRole(m.Role) {
+		return ErrInvalidRole
+	}
+
+	if err := app.authorize(ctx, uid, m.WorkspaceID, storage.RoleOwner); err != nil {
+		return err
+	}
+
+	if m.Role != storage.RoleOwner {
+		if err := app.checkNotLastOwner(ctx, m.WorkspaceID, m.UID); err != nil {
+			return err
+		}
+	}
+
+	var before interface{}
+	if role, err := app.DB.GetRole(ctx, m.WorkspaceID, m.UID); err == nil {
+		before = storage.Member{WorkspaceID: m.WorkspaceID, UID: m.UID, Role: role}
+	}
+
+	if err := app.DB.SetMember(ctx, m); err != nil {
+		return err
+	}
+
+	app.record(ctx, audit.ActionMemberSet, uid, m.WorkspaceID, before, m)
+
+	return nil
+}
+
+// RemoveMember removes memberUID from a workspace. Owners may remove anyone, other members only themselves.
+func (app *App) RemoveMember(ctx context.Context, uid, workspaceID, memberUID string) error {
+	minRole := storage.RoleOwner
+	if uid == memberUID {
+		minRole = storage.RoleViewer
+	}
+
+	if err := app.authorize(ctx, uid, workspaceID, minRole); err != nil {
+		return err
+	}
+
+	if err := app.checkNotLastOwner(ctx, workspaceID, memberUID); err != nil {
+		return err
+	}
+
+	role, err := app.DB.GetRole(ctx, workspaceID, memberUID)
+	if err != nil {
+		return err
+	}
+
+	if err = app.DB.RemoveMember(ctx, workspaceID, memberUID); err != nil {
+		return err
+	}
+
+	app.record(ctx, audit.ActionMemberRemove, uid, workspaceID, storage.Member{WorkspaceID: workspaceID, UID: memberUID, Role: role}, nil)
+
+	return nil
+}
+
+// checkNotLastOwner returns ErrLastOwner if memberUID is the only owner of the workspace
+func (app *App) checkNotLastOwner(ctx context.Context, workspaceID, memberUID string) error {
+	members, err := app.DB.GetMembers(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	owners, isOwner := 0, false
+
+	for _, m := range members {
+		if m.Role == storage.RoleOwner {
+			owners++
+			isOwner = isOwner || m.UID == memberUID
+		}
+	}
+
+	if isOwner && owners == 1 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+// SaveWorkspaceURL shortens rawURL on domain into a workspace, editors and owners may do it
+func (app *App) SaveWorkspaceURL(ctx context.Context, uid, workspaceID, rawURL, domain string) (string, error) {
+	if err := app.authorize(ctx, uid, workspaceID, storage.RoleEditor); err != nil {
+		return "", err
+	}
+
+	return app.saveURL(ctx, storage.URL{URL: rawURL, UID: uid, WorkspaceID: workspaceID, Domain: domain})
+}
+
+// GetWorkspaceURLs returns URLs of a workspace, any member may list them
+func (app *App) GetWorkspaceURLs(ctx context.Context, uid, workspaceID string) ([]storage.URL, error) {
+	if err := app.authorize(ctx, uid, workspaceID, storage.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	u, err := app.DB.GetUrlsByWorkspace(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, el := range u {
+		u[i].ShortURL = app.ShortURL(el.Domain, el.ShortURL)
+	}
+
+	return u, nil
+}
+
+// DeleteWorkspaceURLs stages workspace URLs for deletion, editors and owners may do it. See DeleteListURL for rawHashes.
+// The storage authorizes every entry against the membership again when the deletion is performed.
+func (app *App) DeleteWorkspaceURLs(ctx context.Context, uid, workspaceID string, rawHashes []string, domain string) error {
+	if err := app.authorize(ctx, uid, workspaceID, storage.RoleEditor); err != nil {
+		return err
+	}
+
+	return app.DeleteListURL(ctx, rawHashes, uid, domain)
+}
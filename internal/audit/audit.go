@@ -0,0 +1,255 @@
+// Package audit keeps an append-only, hash-chained log of administrative and destructive actions
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+)
+
+// Recorded actions
+const (
+	ActionURLCreate       = "url.create"
+	ActionURLDelete       = "url.delete"
+	ActionURLClaim        = "url.claim"
+	ActionUserRegister    = "user.register"
+	ActionUserPassword    = "user.password"
+	ActionWorkspaceCreate = "workspace.create"
+	ActionMemberSet       = "workspace.member.set"
+	ActionMemberRemove    = "workspace.member.remove"
+)
+
+// maxAppendAttempts limits retries when another instance appended to a shared sink concurrently
+const maxAppendAttempts = 3
+
+var (
+	// ErrConflict is returned by a sink when an event with the same sequence number already exists
+	ErrConflict = errors.New("audit event sequence conflict")
+	// ErrBrokenChain is returned by Verify when an event was modified, removed or inserted
+	ErrBrokenChain = errors.New("audit hash chain is broken")
+)
+
+// Event is a single audit log record. Hash covers every other field including PrevHash,
+// so changing or removing any record breaks the chain.
+type Event struct {
+	Seq      int64           `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Action   string          `json:"action"`
+	ActorUID string          `json:"actor_uid"`
+	IP       string          `json:"ip,omitempty"`
+	Target   string          `json:"target,omitempty"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	PrevHash string          `json:"prev_hash"`
+	Hash     string          `json:"hash"`
+}
+
+// Filter narrows Query results, zero fields match everything
+type Filter struct {
+	ActorUID string
+	Action   string
+	Target   string
+	From     time.Time
+	To       time.Time
+	Limit    int // Keeps only the latest Limit matching events
+}
+
+// Match reports whether e passes the filter (the limit is applied by sinks)
+func (f Filter) Match(e Event) bool {
+	return (f.ActorUID == "" || e.ActorUID == f.ActorUID) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
+}
+
+// Sink persists audit events
+type Sink interface {
+	Append(ctx context.Context, events ...Event) error    // Appends consecutive events at once, ErrConflict if a Seq is taken
+	Last(ctx context.Context) (Event, error)              // Returns the last event or a zero Event if the log is empty
+	Query(ctx context.Context, f Filter) ([]Event, error) // Returns events matching f ordered by Seq, the latest ones if f.Limit is set
+	Close() error                                         // Releases sink resources
+}
+
+// InitSink creates a sink based on the config: a file if AuditLogPath is set, Postgres if DatabaseDSN is set
+// and an in-memory one otherwise
+func InitSink(cfg *config.Config) (Sink, error) {
+	if cfg.AuditLogPath != "" {
+		return NewFileSink(cfg.AuditLogPath), nil
+	}
+
+	if cfg.DatabaseDSN != "" {
+		return NewDBSink(context.Background(), cfg.DatabaseDSN)
+	}
+
+	return NewMemorySink(), nil
+}
+
+// Log chains events and writes them to a sink. A nil *Log records nothing.
+type Log struct {
+	mu     sync.Mutex
+	sink   Sink
+	key    []byte
+	last   Event
+	loaded bool
+}
+
+// NewLog creates a log writing to sink. Events are hashed with an HMAC under key, so whoever can write
+// to the sink can't rebuild a consistent chain after editing it without also knowing the key.
+// An empty key falls back to plain sha256, which only detects accidental damage.
+func NewLog(sink Sink, key string) *Log {
+	return &Log{sink: sink, key: []byte(key)}
+}
+
+// Record fills in the sequence number, time, client IP and hashes of e and appends it to the sink
+func (l *Log) Record(ctx context.Context, e Event) error {
+	return l.RecordMany(ctx, []Event{e})
+}
+
+// RecordMany records events like Record does, chaining them in order and appending them to the sink
+// with a single call. Bulk actions use it to audit a whole chunk at once.
+func (l *Log) RecordMany(ctx context.Context, events []Event) error {
+	if l == nil || len(events) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	ip, _ := ctx.Value(ipKey{}).(string)
+
+	chained := make([]Event, len(events))
+
+	for i, e := range events {
+		e.Time = now
+
+		if e.IP == "" {
+			e.IP = ip
+		}
+
+		chained[i] = e
+	}
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		if !l.loaded {
+			last, err := l.sink.Last(ctx)
+			if err != nil {
+				return err
+			}
+
+			l.last, l.loaded = last, true
+		}
+
+		prev := l.last
+
+		for i := range chained {
+			chained[i].Seq = prev.Seq + 1
+			chained[i].PrevHash = prev.Hash
+			chained[i].Hash = hashEvent(chained[i], l.key)
+			prev = chained[i]
+		}
+
+		err := l.sink.Append(ctx, chained...)
+		if errors.Is(err, ErrConflict) {
+			l.loaded = false
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		l.last = prev
+
+		return nil
+	}
+
+	return ErrConflict
+}
+
+// Query returns events matching f
+func (l *Log) Query(ctx context.Context, f Filter) ([]Event, error) {
+	if l == nil {
+		return []Event{}, nil
+	}
+
+	return l.sink.Query(ctx, f)
+}
+
+// Verify walks the whole log and checks the hash chain. It returns the number of verified events;
+// on a broken chain the error names the first bad sequence number.
+func (l *Log) Verify(ctx context.Context) (int, error) {
+	events, err := l.Query(ctx, Filter{})
+	if err != nil {
+		return 0, err
+	}
+
+	prev := Event{}
+
+	for i, e := range events {
+		if e.Seq != prev.Seq+1 || e.PrevHash != prev.Hash || hashEvent(e, l.key) != e.Hash {
+			return i, fmt.Errorf("%w at seq %d", ErrBrokenChain, prev.Seq+1)
+		}
+
+		prev = e
+	}
+
+	return len(events), nil
+}
+
+// Close closes the underlying sink
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.sink.Close()
+}
+
+// hashEvent returns the hex HMAC-SHA256 under key, or the sha256 if key is empty, of e serialized with an empty Hash
+func hashEvent(e Event, key []byte) string {
+	e.Hash = ""
+	e.Time = e.Time.UTC()
+
+	data, _ := json.Marshal(e)
+
+	if len(key) == 0 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type ipKey struct{}
+
+// WithIP returns a context carrying the client ip recorded in events
+func WithIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ipKey{}, ip)
+}
+
+// Middleware puts the client ip of every request into its context for Record
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithIP(r.Context(), ip)))
+	})
+}
@@ -0,0 +1,111 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/audit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "audit-key"
+
+func Test_Log(t *testing.T) {
+	ctx := audit.WithIP(context.Background(), "10.0.0.1")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := audit.NewLog(audit.NewFileSink(path), testKey)
+
+	assert.NoError(t, l.Record(ctx, audit.Event{Action: audit.ActionURLCreate, ActorUID: "alice", Target: "e62e2446"}))
+	assert.NoError(t, l.Record(ctx, audit.Event{Action: audit.ActionURLDelete, ActorUID: "bob", Target: "e62e2446", Before: json.RawMessage(`{"is_deleted":false}`)}))
+	assert.NoError(t, l.Record(ctx, audit.Event{Action: audit.ActionURLCreate, ActorUID: "alice", Target: "16358727"}))
+
+	t.Run("filters", func(t *testing.T) {
+		events, err := l.Query(ctx, audit.Filter{ActorUID: "alice"})
+		assert.NoError(t, err)
+		assert.Len(t, events, 2)
+		assert.Equal(t, "10.0.0.1", events[0].IP)
+
+		events, _ = l.Query(ctx, audit.Filter{Target: "e62e2446", Action: audit.ActionURLDelete})
+		assert.Len(t, events, 1)
+
+		events, _ = l.Query(ctx, audit.Filter{From: time.Now().Add(time.Hour)})
+		assert.Len(t, events, 0)
+
+		events, _ = l.Query(ctx, audit.Filter{Limit: 2})
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, []int64{2, 3}, []int64{events[0].Seq, events[1].Seq})
+		}
+	})
+
+	t.Run("chain continues after reopening", func(t *testing.T) {
+		reopened := audit.NewLog(audit.NewFileSink(path), testKey)
+		assert.NoError(t, reopened.Record(ctx, audit.Event{Action: audit.ActionUserRegister, ActorUID: "alice"}))
+
+		n, err := reopened.Verify(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, n)
+	})
+
+	t.Run("tampering breaks the chain", func(t *testing.T) {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(path, bytes.Replace(data, []byte(`"bob"`), []byte(`"eve"`), 1), 0o600))
+
+		_, err = audit.NewLog(audit.NewFileSink(path), testKey).Verify(ctx)
+		assert.ErrorIs(t, err, audit.ErrBrokenChain)
+	})
+
+	t.Run("rebuilt chain needs the key", func(t *testing.T) {
+		events, err := audit.NewLog(audit.NewFileSink(path), testKey).Query(ctx, audit.Filter{})
+		assert.NoError(t, err)
+
+		forged := filepath.Join(t.TempDir(), "audit.log")
+		unkeyed := audit.NewLog(audit.NewFileSink(forged), "")
+
+		for _, e := range events[1:] {
+			assert.NoError(t, unkeyed.Record(ctx, audit.Event{Action: e.Action, ActorUID: e.ActorUID, Target: e.Target}))
+		}
+
+		_, err = unkeyed.Verify(ctx)
+		assert.NoError(t, err)
+
+		_, err = audit.NewLog(audit.NewFileSink(forged), testKey).Verify(ctx)
+		assert.ErrorIs(t, err, audit.ErrBrokenChain)
+	})
+
+	t.Run("nil log records nothing", func(t *testing.T) {
+		var nilLog *audit.Log
+		assert.NoError(t, nilLog.Record(ctx, audit.Event{Action: audit.ActionURLCreate}))
+	})
+}
+
+func Test_LogRecordMany(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := audit.NewLog(audit.NewFileSink(path), testKey)
+
+	assert.NoError(t, l.Record(ctx, audit.Event{Action: audit.ActionUserRegister, ActorUID: "alice"}))
+	assert.NoError(t, l.RecordMany(ctx, []audit.Event{
+		{Action: audit.ActionURLCreate, ActorUID: "alice", Target: "e62e2446"},
+		{Action: audit.ActionURLCreate, ActorUID: "alice", Target: "16358727"},
+	}))
+	assert.NoError(t, l.RecordMany(ctx, nil))
+
+	reopened := audit.NewLog(audit.NewFileSink(path), testKey)
+	assert.NoError(t, reopened.Record(ctx, audit.Event{Action: audit.ActionURLDelete, ActorUID: "alice", Target: "e62e2446"}))
+
+	events, err := reopened.Query(ctx, audit.Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, events, 4)
+	assert.Equal(t, int64(3), events[2].Seq)
+
+	n, err := reopened.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
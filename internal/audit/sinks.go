@@ -0,0 +1,322 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MemorySink keeps events in memory, it is used when no persistent sink is configured
+type MemorySink struct {
+	mu     sync.RWMutex
+	events []Event
+}
+
+// NewMemorySink creates an empty in-memory sink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// Append appends events
+func (s *MemorySink) Append(ctx context.Context, events ...Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	if len(s.events) > 0 && s.events[len(s.events)-1].Seq >= events[0].Seq {
+		return ErrConflict
+	}
+
+	s.events = append(s.events, events...)
+
+	return nil
+}
+
+// Last returns the last event
+func (s *MemorySink) Last(ctx context.Context) (Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.events) == 0 {
+		return Event{}, nil
+	}
+
+	return s.events[len(s.events)-1], nil
+}
+
+// Query returns events matching f
+func (s *MemorySink) Query(ctx context.Context, f Filter) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return filterEvents(s.events, f), nil
+}
+
+// Close does nothing
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// FileSink appends events to a file, one JSON event per line
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSink creates a sink writing to the file at path
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Append appends events to the file with a single write
+func (s *FileSink) Append(ctx context.Context, events ...Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := []byte{}
+
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		data = append(append(data, line...), '\n')
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.Write(data)
+
+	return err
+}
+
+// Last returns the last event of the file
+func (s *FileSink) Last(ctx context.Context) (Event, error) {
+	events, err := s.read()
+	if err != nil || len(events) == 0 {
+		return Event{}, err
+	}
+
+	return events[len(events)-1], nil
+}
+
+// Query returns events matching f
+func (s *FileSink) Query(ctx context.Context, f Filter) ([]Event, error) {
+	events, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterEvents(events, f), nil
+}
+
+// Close does nothing, the file is opened per append
+func (s *FileSink) Close() error {
+	return nil
+}
+
+func (s *FileSink) read() ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []Event{}
+
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return events, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 1<<20)
+
+	for scanner.Scan() {
+		e := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, scanner.Err()
+}
+
+// DBSink stores events in the audit_log Postgres table
+type DBSink struct {
+	conn *pgxpool.Pool
+}
+
+// NewDBSink connects to the database and creates the audit_log table if it doesn't exist
+func NewDBSink(ctx context.Context, dsn string) (*DBSink, error) {
+	conn, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Exec(ctx, `
+	CREATE TABLE IF NOT EXISTS
+	audit_log
+	(seq bigint PRIMARY KEY, time timestamptz NOT NULL, action varchar NOT NULL, actor_uid varchar NOT NULL,
+	ip varchar NOT NULL, target varchar NOT NULL, before text, after text, prev_hash varchar NOT NULL, hash varchar NOT NULL);
+	`)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &DBSink{conn}, nil
+}
+
+const eventColumns = "seq, time, action, actor_uid, ip, target, before, after, prev_hash, hash"
+
+// Append inserts events with a single COPY, a taken sequence number is reported as ErrConflict
+// and no event is inserted
+func (s *DBSink) Append(ctx context.Context, events ...Event) error {
+	_, err := s.conn.CopyFrom(ctx, pgx.Identifier{"audit_log"}, strings.Split(eventColumns, ", "),
+		pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+			e := events[i]
+			return []any{e.Seq, e.Time, e.Action, e.ActorUID, e.IP, e.Target, nullableText(e.Before), nullableText(e.After), e.PrevHash, e.Hash}, nil
+		}))
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return ErrConflict
+	}
+
+	return err
+}
+
+// Last returns the event with the highest sequence number
+func (s *DBSink) Last(ctx context.Context) (Event, error) {
+	e, err := scanEvent(s.conn.QueryRow(ctx, "SELECT "+eventColumns+" FROM audit_log ORDER BY seq DESC LIMIT 1"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Event{}, nil
+	}
+
+	return e, err
+}
+
+// Query returns events matching f, with a limit the latest ones are selected and then put back in Seq order
+func (s *DBSink) Query(ctx context.Context, f Filter) ([]Event, error) {
+	query := `SELECT ` + eventColumns + ` FROM audit_log
+	WHERE ($1 = '' OR actor_uid = $1) AND ($2 = '' OR action = $2) AND ($3 = '' OR target = $3)
+	AND ($4::timestamptz IS NULL OR time >= $4) AND ($5::timestamptz IS NULL OR time < $5)`
+
+	args := []any{f.ActorUID, f.Action, f.Target, nullableTime(f.From), nullableTime(f.To)}
+
+	if f.Limit > 0 {
+		query = `SELECT ` + eventColumns + ` FROM (` + query + ` ORDER BY seq DESC LIMIT $6) latest ORDER BY seq`
+		args = append(args, f.Limit)
+	} else {
+		query += " ORDER BY seq"
+	}
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []Event{}
+
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// Close closes the connection pool
+func (s *DBSink) Close() error {
+	s.conn.Close()
+	return nil
+}
+
+func scanEvent(row pgx.Row) (Event, error) {
+	e := Event{}
+
+	var before, after *string
+
+	err := row.Scan(&e.Seq, &e.Time, &e.Action, &e.ActorUID, &e.IP, &e.Target, &before, &after, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return Event{}, err
+	}
+
+	e.Time = e.Time.UTC()
+
+	if before != nil {
+		e.Before = json.RawMessage(*before)
+	}
+
+	if after != nil {
+		e.After = json.RawMessage(*after)
+	}
+
+	return e, nil
+}
+
+func nullableText(raw json.RawMessage) *string {
+	if raw == nil {
+		return nil
+	}
+
+	s := string(raw)
+
+	return &s
+}
+
+func nullableTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
+
+// filterEvents returns events matching f, keeping only the latest f.Limit of them if it's set
+func filterEvents(events []Event, f Filter) []Event {
+	result := []Event{}
+
+	for _, e := range events {
+		if f.Match(e) {
+			result = append(result, e)
+		}
+	}
+
+	if f.Limit > 0 && len(result) > f.Limit {
+		result = result[len(result)-f.Limit:]
+	}
+
+	return result
+}
@@ -0,0 +1,74 @@
+// Package bloom provides a Bloom filter of short codes and a storage rejecting codes it has never seen
+package bloom
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// Filter is a Bloom filter of strings safe for concurrent use. Test never reports an added key as absent,
+// it reports a key that wasn't added as present with about the false positive rate the filter was sized for.
+type Filter struct {
+	seed maphash.Seed
+	k    uint64 // hashes per key
+
+	mu   sync.RWMutex // guards bits
+	bits []uint64
+}
+
+// NewFilter returns a filter sized to hold n keys with false positive rate p
+func NewFilter(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+
+	return &Filter{
+		seed: maphash.MakeSeed(),
+		k:    uint64(k),
+		bits: make([]uint64, (uint64(m)+63)/64),
+	}
+}
+
+// locations calls fn with the bit positions of key, it's double hashing of two halves of one hash
+func (f *Filter) locations(key string, fn func(word int, mask uint64) bool) {
+	h := maphash.String(f.seed, key)
+	h1, h2 := h&math.MaxUint32, h>>32|1
+	m := uint64(len(f.bits)) * 64
+
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % m
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return
+		}
+	}
+}
+
+// Add adds key to the filter
+func (f *Filter) Add(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.locations(key, func(word int, mask uint64) bool {
+		f.bits[word] |= mask
+		return true
+	})
+}
+
+// Test reports whether key may have been added
+func (f *Filter) Test(key string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	found := true
+
+	f.locations(key, func(word int, mask uint64) bool {
+		found = f.bits[word]&mask != 0
+		return found
+	})
+
+	return found
+}
@@ -0,0 +1,92 @@
+package bloom_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/bloom"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// falsePositiveRate fills a filter sized for n keys with n keys and returns the share of tries other keys pass
+func falsePositiveRate(n, tries int, p float64) float64 {
+	f := bloom.NewFilter(n, p)
+
+	for i := 0; i < n; i++ {
+		f.Add("stored/" + strconv.Itoa(i))
+	}
+
+	passed := 0
+
+	for i := 0; i < tries; i++ {
+		if f.Test("unknown/" + strconv.Itoa(i)) {
+			passed++
+		}
+	}
+
+	return float64(passed) / float64(tries)
+}
+
+func Test_Filter(t *testing.T) {
+	t.Run("added keys are found", func(t *testing.T) {
+		f := bloom.NewFilter(1000, 0.01)
+
+		for i := 0; i < 2000; i++ {
+			f.Add(strconv.Itoa(i))
+		}
+
+		for i := 0; i < 2000; i++ {
+			assert.True(t, f.Test(strconv.Itoa(i)))
+		}
+	})
+
+	tests := []struct {
+		name string
+		p    float64
+	}{
+		{"one percent", 0.01},
+		{"a tenth of a percent", 0.001},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate := falsePositiveRate(10000, 100000, tt.p)
+			assert.Less(t, rate, 2*tt.p)
+		})
+	}
+}
+
+func BenchmarkFilterTest(b *testing.B) {
+	f := bloom.NewFilter(1000000, 0.01)
+
+	for i := 0; i < 1000000; i++ {
+		f.Add("stored/" + strconv.Itoa(i))
+	}
+
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "unknown/" + strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		f.Test(keys[i%len(keys)])
+	}
+}
+
+// BenchmarkFalsePositiveRate reports the measured false positive rate of a full filter for each configured rate
+func BenchmarkFalsePositiveRate(b *testing.B) {
+	for _, p := range []float64{0.1, 0.01, 0.001} {
+		b.Run(strconv.FormatFloat(p, 'f', -1, 64), func(b *testing.B) {
+			rate := 0.0
+
+			for i := 0; i < b.N; i++ {
+				rate = falsePositiveRate(100000, 100000, p)
+			}
+
+			b.ReportMetric(rate, "fp-rate")
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package bloom
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/metrics"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// Options configures FilteredStorage
+type Options struct {
+	Capacity          int           // codes the filter is sized for, it grows to twice the stored codes on rebuilds
+	FalsePositiveRate float64       // share of unknown codes passed to the storage when the filter holds Capacity codes
+	RebuildInterval   time.Duration // how often the filter is rebuilt from the storage, only on Flush if 0
+}
+
+// FilteredStorage answers GetURL of codes missing from its filter with ErrNotFound without asking
+// the wrapped storage. The filter is built from all stored codes by Run and takes codes saved through
+// FilteredStorage, use Evict for codes saved elsewhere, e.g. by other instances. Until the filter is
+// built all lookups are passed through.
+type FilteredStorage struct {
+	storage.Storage
+
+	opts    Options
+	rebuild chan struct{} // requests a rebuild from Run
+
+	mu     sync.Mutex // guards fields below
+	filter *Filter    // nil until built and after Flush
+	next   *Filter    // filter being built, it takes saves made while the storage is scanned
+	codes  int        // codes found by the last rebuild
+}
+
+// FilterStorage wraps st with a filter described by opts, call Run to build it
+func FilterStorage(st storage.Storage, opts Options) *FilteredStorage {
+	return &FilteredStorage{
+		Storage: st,
+		opts:    opts,
+		rebuild: make(chan struct{}, 1),
+	}
+}
+
+func key(domain, hash string) string {
+	return domain + "/" + hash
+}
+
+// filters returns the filters saved codes have to be added to
+func (s *FilteredStorage) filters() []*Filter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fs := make([]*Filter, 0, 2)
+
+	for _, f := range []*Filter{s.filter, s.next} {
+		if f != nil {
+			fs = append(fs, f)
+		}
+	}
+
+	return fs
+}
+
+func (s *FilteredStorage) add(domain, hash string) {
+	k := key(domain, hash)
+
+	for _, f := range s.filters() {
+		f.Add(k)
+	}
+}
+
+// GetURL returns ErrNotFound for codes that were never stored, other lookups are passed to the wrapped storage
+func (s *FilteredStorage) GetURL(ctx context.Context, domain, hash string) (storage.URL, error) {
+	s.mu.Lock()
+	f := s.filter
+	s.mu.Unlock()
+
+	if f == nil {
+		return s.Storage.GetURL(ctx, domain, hash)
+	}
+
+	if !f.Test(key(domain, hash)) {
+		metrics.BloomLookups.WithLabelValues("rejected").Inc()
+		return storage.URL{}, storage.ErrNotFound
+	}
+
+	u, err := s.Storage.GetURL(ctx, domain, hash)
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		metrics.BloomLookups.WithLabelValues("false_positive").Inc()
+	case err == nil:
+		metrics.BloomLookups.WithLabelValues("passed").Inc()
+	}
+
+	return u, err
+}
+
+// SaveURL adds the code to the filter and saves u. The code is added again after saving: a rebuild
+// starting in between scans the storage before u is saved and would miss it.
+func (s *FilteredStorage) SaveURL(ctx context.Context, u storage.URL) error {
+	s.add(u.Domain, u.ShortURL)
+	defer s.add(u.Domain, u.ShortURL)
+
+	return s.Storage.SaveURL(ctx, u)
+}
+
+// BatchSaveURL adds the codes to the filter and saves urls, see SaveURL
+func (s *FilteredStorage) BatchSaveURL(ctx context.Context, urls []storage.URL) error {
+	addAll := func() {
+		for _, u := range urls {
+			s.add(u.Domain, u.ShortURL)
+		}
+	}
+
+	addAll()
+	defer addAll()
+
+	return s.Storage.BatchSaveURL(ctx, urls)
+}
+
+// Evict adds a code saved elsewhere to the filter, so FilteredStorage can be passed to DBStorage.ListenChanges.
+// Codes of updated and deleted URLs are added too, they are in the filter already.
+func (s *FilteredStorage) Evict(domain, hash string) {
+	s.add(domain, hash)
+}
+
+// Flush turns the filter off until it is rebuilt, codes saved elsewhere may have been missed
+func (s *FilteredStorage) Flush() {
+	s.mu.Lock()
+	s.filter = nil
+	s.mu.Unlock()
+
+	select {
+	case s.rebuild <- struct{}{}:
+	default:
+	}
+}
+
+// FlushChanges rebuilds the filter after an unknown set of URLs was inserted elsewhere. Updates and
+// deletions never add codes, so the filter is kept for them.
+func (s *FilteredStorage) FlushChanges(kind string) {
+	if kind == storage.ChangeInsert {
+		s.Flush()
+	}
+}
+
+// Run builds the filter and rebuilds it every RebuildInterval and after Flush until ctx is done
+func (s *FilteredStorage) Run(ctx context.Context) {
+	var tick <-chan time.Time
+
+	if s.opts.RebuildInterval > 0 {
+		ticker := time.NewTicker(s.opts.RebuildInterval)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		if err := s.Rebuild(ctx); err != nil && ctx.Err() == nil {
+			logger.FromContext(ctx).Error("rebuilding bloom filter", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-s.rebuild:
+		}
+	}
+}
+
+// Rebuild builds a new filter from all stored codes and replaces the current one with it.
+// Stored codes only grow between rebuilds, purged and failed saves leave their codes in the filter.
+func (s *FilteredStorage) Rebuild(ctx context.Context) error {
+	s.mu.Lock()
+	next := NewFilter(max(s.opts.Capacity, 2*s.codes), s.opts.FalsePositiveRate)
+	s.next = next
+	s.mu.Unlock()
+
+	codes := 0
+	err := s.Storage.ForEachURL(ctx, func(u storage.URL) error {
+		next.Add(key(u.Domain, u.ShortURL))
+		codes++
+
+		return nil
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next = nil
+
+	if err != nil {
+		return err
+	}
+
+	s.filter, s.codes = next, codes
+	metrics.BloomCodes.Set(float64(codes))
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package bloom_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/bloom"
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStorage counts lookups reaching the storage
+type countingStorage struct {
+	storage.Storage
+	gets atomic.Int64
+}
+
+func (s *countingStorage) GetURL(ctx context.Context, domain, hash string) (storage.URL, error) {
+	s.gets.Add(1)
+	return s.Storage.GetURL(ctx, domain, hash)
+}
+
+func Test_FilteredStorage(t *testing.T) {
+	ctx := context.Background()
+	stored := storage.URL{UID: "owner", ShortURL: "stored", URL: "https://example.com"}
+
+	tests := []struct {
+		name     string
+		run      func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage)
+		wantGets int64
+	}{
+		{
+			name: "unknown codes are rejected",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				assert.NoError(t, st.Rebuild(ctx))
+
+				_, err := st.GetURL(ctx, "", "unknown")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+			wantGets: 0,
+		},
+		{
+			name: "stored codes are looked up",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				assert.NoError(t, st.Rebuild(ctx))
+
+				u, err := st.GetURL(ctx, "", "stored")
+				assert.NoError(t, err)
+				assert.Equal(t, stored.URL, u.URL)
+			},
+			wantGets: 1,
+		},
+		{
+			name: "codes are rejected per domain",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				assert.NoError(t, st.Rebuild(ctx))
+
+				_, err := st.GetURL(ctx, "brand.io", "stored")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+			wantGets: 0,
+		},
+		{
+			name: "lookups pass until the filter is built",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				_, err := st.GetURL(ctx, "", "unknown")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+			wantGets: 1,
+		},
+		{
+			name: "saved codes are added",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				assert.NoError(t, st.Rebuild(ctx))
+				assert.NoError(t, st.SaveURL(ctx, storage.URL{UID: "owner", ShortURL: "new", URL: "https://new.example"}))
+				assert.NoError(t, st.BatchSaveURL(ctx, []storage.URL{{UID: "owner", ShortURL: "batch", URL: "https://batch.example"}}))
+
+				_, err := st.GetURL(ctx, "", "new")
+				assert.NoError(t, err)
+				_, err = st.GetURL(ctx, "", "batch")
+				assert.NoError(t, err)
+			},
+			wantGets: 2,
+		},
+		{
+			name: "codes saved elsewhere are added on evict",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				assert.NoError(t, st.Rebuild(ctx))
+				assert.NoError(t, backend.SaveURL(ctx, storage.URL{UID: "owner", ShortURL: "remote", URL: "https://remote.example"}))
+
+				_, err := st.GetURL(ctx, "", "remote")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				st.Evict("", "remote")
+
+				_, err = st.GetURL(ctx, "", "remote")
+				assert.NoError(t, err)
+			},
+			wantGets: 1,
+		},
+		{
+			name: "flush turns the filter off until rebuilt",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				assert.NoError(t, st.Rebuild(ctx))
+				st.Flush()
+
+				_, _ = st.GetURL(ctx, "", "unknown")
+
+				assert.NoError(t, st.Rebuild(ctx))
+
+				_, _ = st.GetURL(ctx, "", "unknown")
+			},
+			wantGets: 1,
+		},
+		{
+			name: "updates and deletions elsewhere keep the filter",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				assert.NoError(t, st.Rebuild(ctx))
+				st.FlushChanges(storage.ChangeUpdate)
+				st.FlushChanges(storage.ChangeDelete)
+
+				_, err := st.GetURL(ctx, "", "unknown")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+			wantGets: 0,
+		},
+		{
+			name: "bulk inserts elsewhere turn the filter off until rebuilt",
+			run: func(t *testing.T, st *bloom.FilteredStorage, backend storage.Storage) {
+				assert.NoError(t, st.Rebuild(ctx))
+				st.FlushChanges(storage.ChangeInsert)
+
+				_, _ = st.GetURL(ctx, "", "unknown")
+			},
+			wantGets: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := storage.InitStorage(map[string]storage.URL{}, &config.Config{})
+			assert.NoError(t, backend.SaveURL(ctx, stored))
+
+			counting := &countingStorage{Storage: backend}
+			st := bloom.FilterStorage(counting, bloom.Options{Capacity: 100, FalsePositiveRate: 0.001})
+
+			tt.run(t, st, backend)
+
+			assert.Equal(t, tt.wantGets, counting.gets.Load())
+		})
+	}
+}
+
+func Test_RejectedRedirect(t *testing.T) {
+	counting := &countingStorage{Storage: storage.InitStorage(map[string]storage.URL{}, &config.Config{})}
+	st := bloom.FilterStorage(counting, bloom.Options{Capacity: 100, FalsePositiveRate: 0.001})
+	assert.NoError(t, st.Rebuild(context.Background()))
+
+	h := handler.InitHandler(app.NewApp(st, &config.Config{BaseURL: "http://localhost:8080"}))
+
+	router := chi.NewRouter()
+	router.Get("/{urlHash}", h.HandleGetURL)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/wp-login", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Zero(t, counting.gets.Load())
+}
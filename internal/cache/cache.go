@@ -0,0 +1,209 @@
+// Package cache provides a read-through cache of redirect lookups in front of a storage
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/metrics"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// Options configures CacheStorage
+type Options struct {
+	Size        int              // most codes kept, found or not, least recently used ones are dropped first
+	TTL         time.Duration    // how long a found URL is kept
+	NegativeTTL time.Duration    // how long an unknown code is kept, unknown codes aren't cached if 0
+	Now         func() time.Time // clock, time.Now if nil
+}
+
+// entry is a cached lookup, found is false for unknown codes
+type entry struct {
+	key     string
+	url     storage.URL
+	found   bool
+	expires time.Time
+}
+
+// CachedStorage caches GetURL results of the wrapped storage. Writes through it evict the codes
+// they change, use Evict and Flush for changes made elsewhere, e.g. by other instances.
+type CachedStorage struct {
+	storage.Storage
+
+	opts Options
+
+	mu      sync.Mutex               // guards fields below
+	entries map[string]*list.Element // key to an element of lru holding *entry
+	lru     *list.List               // most recently used first
+	gen     uint64                   // incremented by every invalidation, see GetURL
+}
+
+// CacheStorage wraps st with a cache described by opts
+func CacheStorage(st storage.Storage, opts Options) *CachedStorage {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &CachedStorage{
+		Storage: st,
+		opts:    opts,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func key(domain, hash string) string {
+	return domain + "/" + hash
+}
+
+// GetURL returns the cached lookup of hash on domain or asks the wrapped storage and caches the answer
+func (s *CachedStorage) GetURL(ctx context.Context, domain, hash string) (storage.URL, error) {
+	k := key(domain, hash)
+
+	s.mu.Lock()
+	e, ok := s.lookup(k)
+	gen := s.gen
+	s.mu.Unlock()
+
+	if ok {
+		if !e.found {
+			metrics.CacheLookups.WithLabelValues("negative_hit").Inc()
+			return storage.URL{}, storage.ErrNotFound
+		}
+
+		metrics.CacheLookups.WithLabelValues("hit").Inc()
+
+		return e.url, nil
+	}
+
+	metrics.CacheLookups.WithLabelValues("miss").Inc()
+
+	u, err := s.Storage.GetURL(ctx, domain, hash)
+
+	switch {
+	case err == nil:
+		s.store(gen, &entry{key: k, url: u, found: true, expires: s.opts.Now().Add(s.opts.TTL)})
+	case errors.Is(err, storage.ErrNotFound) && s.opts.NegativeTTL > 0:
+		s.store(gen, &entry{key: k, expires: s.opts.Now().Add(s.opts.NegativeTTL)})
+	}
+
+	return u, err
+}
+
+// lookup returns a live entry and marks it used, expired entries are dropped. Must be called with s.mu held.
+func (s *CachedStorage) lookup(k string) (*entry, bool) {
+	el, ok := s.entries[k]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if !s.opts.Now().Before(e.expires) {
+		s.remove(el)
+		return nil, false
+	}
+
+	s.lru.MoveToFront(el)
+
+	return e, true
+}
+
+// store caches e unless the cache was invalidated since gen was read: the lookup may have raced
+// with a write and returned what the write replaced
+func (s *CachedStorage) store(gen uint64, e *entry) {
+	if s.opts.Size <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if gen != s.gen {
+		return
+	}
+
+	if el, ok := s.entries[e.key]; ok {
+		s.remove(el)
+	}
+
+	s.entries[e.key] = s.lru.PushFront(e)
+
+	for s.lru.Len() > s.opts.Size {
+		s.remove(s.lru.Back())
+	}
+
+	metrics.CacheEntries.Set(float64(s.lru.Len()))
+}
+
+// remove drops el. Must be called with s.mu held.
+func (s *CachedStorage) remove(el *list.Element) {
+	delete(s.entries, el.Value.(*entry).key)
+	s.lru.Remove(el)
+	metrics.CacheEntries.Set(float64(s.lru.Len()))
+}
+
+// Evict drops the cached lookup of hash on domain
+func (s *CachedStorage) Evict(domain, hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gen++
+
+	if el, ok := s.entries[key(domain, hash)]; ok {
+		s.remove(el)
+	}
+}
+
+// Flush drops all cached lookups
+func (s *CachedStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gen++
+	s.entries = make(map[string]*list.Element)
+	s.lru.Init()
+	metrics.CacheEntries.Set(0)
+}
+
+// SaveURL saves u and evicts its code, which may be cached as unknown
+func (s *CachedStorage) SaveURL(ctx context.Context, u storage.URL) error {
+	defer s.Evict(u.Domain, u.ShortURL)
+	return s.Storage.SaveURL(ctx, u)
+}
+
+// BatchSaveURL saves urls and evicts their codes
+func (s *CachedStorage) BatchSaveURL(ctx context.Context, urls []storage.URL) error {
+	defer func() {
+		for _, u := range urls {
+			s.Evict(u.Domain, u.ShortURL)
+		}
+	}()
+
+	return s.Storage.BatchSaveURL(ctx, urls)
+}
+
+// DeleteURLs marks URLs deleted and evicts their codes
+func (s *CachedStorage) DeleteURLs(ctx context.Context, entries []storage.DeletionEntry) ([]storage.URL, error) {
+	defer func() {
+		for _, e := range entries {
+			s.Evict(e.Domain, e.Hash)
+		}
+	}()
+
+	return s.Storage.DeleteURLs(ctx, entries)
+}
+
+// ReassignURLs changes owners of cached URLs, so the cache is flushed
+func (s *CachedStorage) ReassignURLs(ctx context.Context, fromUID, toUID string) (int, error) {
+	defer s.Flush()
+	return s.Storage.ReassignURLs(ctx, fromUID, toUID)
+}
+
+// PurgeDeleted removes cached deleted URLs, so the cache is flushed
+func (s *CachedStorage) PurgeDeleted(ctx context.Context) (int, error) {
+	defer s.Flush()
+	return s.Storage.PurgeDeleted(ctx)
+}
@@ -0,0 +1,215 @@
+package cache_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/cache"
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStorage counts lookups reaching the storage and may delay them like a database round trip
+type countingStorage struct {
+	storage.Storage
+	gets  atomic.Int64
+	delay time.Duration
+}
+
+func (s *countingStorage) GetURL(ctx context.Context, domain, hash string) (storage.URL, error) {
+	s.gets.Add(1)
+	time.Sleep(s.delay)
+
+	return s.Storage.GetURL(ctx, domain, hash)
+}
+
+// clock is a manually advanced time source
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func initCache(size int) (*cache.CachedStorage, *countingStorage, *clock) {
+	backend := &countingStorage{Storage: storage.InitStorage(map[string]storage.URL{}, &config.Config{})}
+	c := &clock{now: time.Unix(0, 0)}
+
+	return cache.CacheStorage(backend, cache.Options{Size: size, TTL: time.Minute, NegativeTTL: time.Second, Now: c.Now}), backend, c
+}
+
+func Test_CachedStorage(t *testing.T) {
+	ctx := context.Background()
+	link := storage.URL{UID: "owner", ShortURL: "abc", URL: "https://example.com"}
+
+	tests := []struct {
+		name     string
+		run      func(t *testing.T, st *cache.CachedStorage, c *clock)
+		wantGets int64
+	}{
+		{
+			name: "found urls are cached",
+			run: func(t *testing.T, st *cache.CachedStorage, c *clock) {
+				assert.NoError(t, st.SaveURL(ctx, link))
+
+				for i := 0; i < 3; i++ {
+					u, err := st.GetURL(ctx, "", "abc")
+					assert.NoError(t, err)
+					assert.Equal(t, link.URL, u.URL)
+				}
+			},
+			wantGets: 1,
+		},
+		{
+			name: "unknown codes are cached",
+			run: func(t *testing.T, st *cache.CachedStorage, c *clock) {
+				for i := 0; i < 3; i++ {
+					_, err := st.GetURL(ctx, "", "abc")
+					assert.ErrorIs(t, err, storage.ErrNotFound)
+				}
+			},
+			wantGets: 1,
+		},
+		{
+			name: "domains are cached apart",
+			run: func(t *testing.T, st *cache.CachedStorage, c *clock) {
+				assert.NoError(t, st.SaveURL(ctx, link))
+
+				_, err := st.GetURL(ctx, "", "abc")
+				assert.NoError(t, err)
+				_, err = st.GetURL(ctx, "brand.io", "abc")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			},
+			wantGets: 2,
+		},
+		{
+			name: "entries expire",
+			run: func(t *testing.T, st *cache.CachedStorage, c *clock) {
+				assert.NoError(t, st.SaveURL(ctx, link))
+				_, _ = st.GetURL(ctx, "", "abc")
+				_, _ = st.GetURL(ctx, "", "missing")
+
+				c.now = c.now.Add(2 * time.Second)
+				_, _ = st.GetURL(ctx, "", "abc")
+				_, _ = st.GetURL(ctx, "", "missing")
+
+				c.now = c.now.Add(time.Minute)
+				_, _ = st.GetURL(ctx, "", "abc")
+			},
+			wantGets: 4,
+		},
+		{
+			name: "saving evicts an unknown code",
+			run: func(t *testing.T, st *cache.CachedStorage, c *clock) {
+				_, err := st.GetURL(ctx, "", "abc")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+
+				assert.NoError(t, st.BatchSaveURL(ctx, []storage.URL{link}))
+
+				u, err := st.GetURL(ctx, "", "abc")
+				assert.NoError(t, err)
+				assert.Equal(t, link.URL, u.URL)
+			},
+			wantGets: 2,
+		},
+		{
+			name: "deleting evicts",
+			run: func(t *testing.T, st *cache.CachedStorage, c *clock) {
+				assert.NoError(t, st.SaveURL(ctx, link))
+				_, _ = st.GetURL(ctx, "", "abc")
+
+				deleted, err := st.DeleteURLs(ctx, []storage.DeletionEntry{{UID: "owner", Hash: "abc"}})
+				assert.NoError(t, err)
+				assert.Len(t, deleted, 1)
+
+				u, err := st.GetURL(ctx, "", "abc")
+				assert.NoError(t, err)
+				assert.True(t, u.IsDeleted)
+			},
+			wantGets: 2,
+		},
+		{
+			name: "reassigning flushes",
+			run: func(t *testing.T, st *cache.CachedStorage, c *clock) {
+				assert.NoError(t, st.SaveURL(ctx, link))
+				_, _ = st.GetURL(ctx, "", "abc")
+
+				_, err := st.ReassignURLs(ctx, "owner", "heir")
+				assert.NoError(t, err)
+
+				u, err := st.GetURL(ctx, "", "abc")
+				assert.NoError(t, err)
+				assert.Equal(t, "heir", u.UID)
+			},
+			wantGets: 2,
+		},
+		{
+			name: "least recently used codes are dropped",
+			run: func(t *testing.T, st *cache.CachedStorage, c *clock) {
+				_, _ = st.GetURL(ctx, "", "a")
+				_, _ = st.GetURL(ctx, "", "b")
+				_, _ = st.GetURL(ctx, "", "a")
+				_, _ = st.GetURL(ctx, "", "c") // drops b
+
+				_, _ = st.GetURL(ctx, "", "a")
+				_, _ = st.GetURL(ctx, "", "b")
+			},
+			wantGets: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, backend, c := initCache(2)
+
+			tt.run(t, st, c)
+
+			assert.Equal(t, tt.wantGets, backend.gets.Load())
+		})
+	}
+}
+
+// benchmarkRedirect follows a short link through the handler, st answers lookups
+func benchmarkRedirect(b *testing.B, st storage.Storage) {
+	cfg := &config.Config{BaseURL: "http://localhost:8080"}
+	a := app.NewApp(st, cfg)
+	h := handler.InitHandler(a)
+
+	router := chi.NewRouter()
+	router.Get("/{urlHash}", h.HandleGetURL)
+
+	if err := st.SaveURL(context.Background(), storage.URL{UID: "owner", ShortURL: "abc", URL: "https://example.com"}); err != nil {
+		b.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/abc", nil)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+
+		if w.Code != http.StatusTemporaryRedirect {
+			b.Fatalf("got status %d", w.Code)
+		}
+	}
+}
+
+// dbDelay approximates a database round trip
+const dbDelay = 200 * time.Microsecond
+
+func BenchmarkRedirectUncached(b *testing.B) {
+	benchmarkRedirect(b, &countingStorage{Storage: storage.InitStorage(map[string]storage.URL{}, &config.Config{}), delay: dbDelay})
+}
+
+func BenchmarkRedirectCached(b *testing.B) {
+	backend := &countingStorage{Storage: storage.InitStorage(map[string]storage.URL{}, &config.Config{}), delay: dbDelay}
+	benchmarkRedirect(b, cache.CacheStorage(backend, cache.Options{Size: 1000, TTL: time.Minute}))
+}
@@ -0,0 +1,159 @@
+// Package client is a Go client of the shortener HTTP API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// maxErrorBody limits how much of an error response is kept in StatusError
+const maxErrorBody = 512
+
+// StatusError is returned when the server responds with an unexpected status
+type StatusError struct {
+	Code int    // HTTP status code
+	Body string // beginning of the response body
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server responded %d %s: %s", e.Code, http.StatusText(e.Code), e.Body)
+}
+
+// Client calls the API on behalf of the user identified by Token
+type Client struct {
+	BaseURL string       // server URL, e.g. http://localhost:8080
+	Token   string       // auth token (the auth cookie value), updated when the server issues a new one
+	HTTP    *http.Client // client used for requests
+}
+
+// New creates a client of the server at baseURL authenticated with token, an empty token gets a new anonymous user
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends a request with the auth cookie, remembers a re-issued token and checks the status against ok
+func (c *Client) do(ctx context.Context, method, path string, body any, ok ...int) (*http.Response, error) {
+	var reader io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.Token != "" {
+		req.AddCookie(&http.Cookie{Name: auth.CookieName, Value: c.Token})
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == auth.CookieName {
+			c.Token = cookie.Value
+		}
+	}
+
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	return nil, &StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+}
+
+// Shorten shortens rawURL and returns the short URL. created is false if the URL was already shortened.
+func (c *Client) Shorten(ctx context.Context, rawURL string) (short string, created bool, err error) {
+	resp, err := c.do(ctx, http.MethodPost, "/api/shorten", handler.URL{URL: rawURL}, http.StatusCreated, http.StatusConflict)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	result := handler.ShortenResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", false, err
+	}
+
+	return result.Result, resp.StatusCode == http.StatusCreated, nil
+}
+
+// Batch shortens urls using their correlation ids as short codes
+func (c *Client) Batch(ctx context.Context, urls []storage.BatchURL) ([]storage.BatchURL, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/api/shorten/batch", urls, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := []storage.BatchURL{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// List returns URLs of the user
+func (c *Client) List(ctx context.Context) ([]storage.URL, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/user/urls", nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	urls := []storage.URL{}
+
+	if resp.StatusCode == http.StatusNoContent {
+		return urls, nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&urls); err != nil {
+		return nil, err
+	}
+
+	return urls, nil
+}
+
+// Delete stages URLs with hashes for deletion, the server deletes them asynchronously
+func (c *Client) Delete(ctx context.Context, hashes []string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/api/user/urls", hashes, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
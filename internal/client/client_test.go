@@ -0,0 +1,217 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/client"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reply is a canned response of the fake server
+type reply struct {
+	status int
+	body   string
+}
+
+// serve starts a server expecting requests with the client token to method and path, it decodes
+// a JSON request body into got and responds with r
+func serve(t *testing.T, method, path string, got any, r reply) *client.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, method, req.Method)
+		assert.Equal(t, path, req.URL.Path)
+
+		if cookie, err := req.Cookie(auth.CookieName); assert.NoError(t, err) {
+			assert.Equal(t, "token", cookie.Value)
+		}
+
+		if got != nil {
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(got))
+		}
+
+		w.WriteHeader(r.status)
+		_, _ = w.Write([]byte(r.body))
+	}))
+	t.Cleanup(srv.Close)
+
+	return client.New(srv.URL+"/", "token")
+}
+
+func Test_ClientShorten(t *testing.T) {
+	tests := []struct {
+		name        string
+		reply       reply
+		wantShort   string
+		wantCreated bool
+		wantCode    int
+	}{
+		{name: "created", reply: reply{http.StatusCreated, `{"result":"http://sho.rt/abc"}`}, wantShort: "http://sho.rt/abc", wantCreated: true},
+		{name: "already shortened", reply: reply{http.StatusConflict, `{"result":"http://sho.rt/abc"}`}, wantShort: "http://sho.rt/abc"},
+		{name: "rejected", reply: reply{http.StatusBadRequest, "Wrong URL format\n"}, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := handler.URL{}
+			c := serve(t, http.MethodPost, "/api/shorten", &got, tt.reply)
+
+			short, created, err := c.Shorten(context.Background(), "https://example.com")
+			assert.Equal(t, "https://example.com", got.URL)
+
+			if tt.wantCode != 0 {
+				statusErr := &client.StatusError{}
+				require.ErrorAs(t, err, &statusErr)
+				assert.Equal(t, tt.wantCode, statusErr.Code)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantShort, short)
+			assert.Equal(t, tt.wantCreated, created)
+		})
+	}
+}
+
+func Test_ClientBatch(t *testing.T) {
+	urls := []storage.BatchURL{{CorrelationID: "a", OriginalURL: "https://a.example"}}
+
+	t.Run("created", func(t *testing.T) {
+		got := []storage.BatchURL{}
+		c := serve(t, http.MethodPost, "/api/shorten/batch", &got, reply{http.StatusCreated, `[{"correlation_id":"a","short_url":"http://sho.rt/a"}]`})
+
+		res, err := c.Batch(context.Background(), urls)
+		require.NoError(t, err)
+		assert.Equal(t, urls, got)
+		assert.Equal(t, []storage.BatchURL{{CorrelationID: "a", ShortURL: "http://sho.rt/a"}}, res)
+	})
+
+	t.Run("conflict is an error", func(t *testing.T) {
+		c := serve(t, http.MethodPost, "/api/shorten/batch", &[]storage.BatchURL{}, reply{http.StatusConflict, "taken"})
+
+		_, err := c.Batch(context.Background(), urls)
+
+		statusErr := &client.StatusError{}
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusConflict, statusErr.Code)
+	})
+}
+
+func Test_ClientList(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    reply
+		want     []storage.URL
+		wantCode int
+	}{
+		{name: "urls", reply: reply{http.StatusOK, `[{"short_url":"http://sho.rt/a","original_url":"https://a.example"}]`}, want: []storage.URL{{ShortURL: "http://sho.rt/a", URL: "https://a.example"}}},
+		{name: "no urls", reply: reply{http.StatusNoContent, ""}, want: []storage.URL{}},
+		{name: "failure", reply: reply{http.StatusInternalServerError, "Something went wrong"}, wantCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := serve(t, http.MethodGet, "/api/user/urls", nil, tt.reply)
+
+			urls, err := c.List(context.Background())
+			if tt.wantCode != 0 {
+				statusErr := &client.StatusError{}
+				require.ErrorAs(t, err, &statusErr)
+				assert.Equal(t, tt.wantCode, statusErr.Code)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, urls)
+		})
+	}
+}
+
+func Test_ClientDelete(t *testing.T) {
+	t.Run("accepted", func(t *testing.T) {
+		got := []string{}
+		c := serve(t, http.MethodDelete, "/api/user/urls", &got, reply{http.StatusAccepted, ""})
+
+		require.NoError(t, c.Delete(context.Background(), []string{"a", "b"}))
+		assert.Equal(t, []string{"a", "b"}, got)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		c := serve(t, http.MethodDelete, "/api/user/urls", &[]string{}, reply{http.StatusBadRequest, "Can't parse hashes"})
+
+		err := c.Delete(context.Background(), []string{"a"})
+
+		statusErr := &client.StatusError{}
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusBadRequest, statusErr.Code)
+	})
+}
+
+func Test_ClientToken(t *testing.T) {
+	tokens := []string{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(auth.CookieName); err == nil {
+			token = cookie.Value
+		}
+
+		tokens = append(tokens, token)
+
+		// a token is issued to an anonymous caller and re-issued on the second request
+		if token == "" || len(tokens) == 2 {
+			http.SetCookie(w, &http.Cookie{Name: auth.CookieName, Value: "issued-" + strconv.Itoa(len(tokens))})
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: "other", Value: "ignored"})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, "")
+
+	for i := 0; i < 3; i++ {
+		_, err := c.List(context.Background())
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"", "issued-1", "issued-2"}, tokens)
+	assert.Equal(t, "issued-2", c.Token)
+}
+
+func Test_StatusError(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantBody string
+	}{
+		{name: "short body is trimmed", body: "  not found \n", wantBody: "not found"},
+		{name: "long body is cut", body: strings.Repeat("x", 2000), wantBody: strings.Repeat("x", 512)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := serve(t, http.MethodGet, "/api/user/urls", nil, reply{http.StatusNotFound, tt.body})
+
+			_, err := c.List(context.Background())
+
+			statusErr := &client.StatusError{}
+			require.ErrorAs(t, err, &statusErr)
+			assert.Equal(t, http.StatusNotFound, statusErr.Code)
+			assert.Equal(t, tt.wantBody, statusErr.Body)
+			assert.Equal(t, "server responded 404 Not Found: "+tt.wantBody, err.Error())
+		})
+	}
+}
@@ -1,39 +1,218 @@
-// Package config containing necessary env set and init for the related service
+// Package config containing necessary env set and init for the related service.
+// Settings are layered with precedence flags > env > config file > defaults.
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
 )
 
-// Config for the service
+// DefaultBaseURL is used when no base url is configured, its scheme becomes https when HTTPS is enabled
+const DefaultBaseURL = "http://localhost:8080"
+
+// Profiler modes
+const (
+	ProfilerOff       = "off"
+	ProfilerAdmin     = "admin"
+	ProfilerProtected = "protected"
+)
+
+// File storage durability modes, see FileDurability
+const (
+	DurabilityAlways = "always" // every write is synced on its own before it is acknowledged
+	DurabilityBatch  = "batch"  // concurrent writes are appended and synced together, then acknowledged
+	DurabilityNone   = "none"   // writes are batched and acknowledged without a sync, a crash of the host may lose them
+)
+
+// Config for the service. Fields tagged reload:"true" can be changed on a running service, see Live.Reload.
 type Config struct {
-	BaseURL         string `env:"BASE_URL" envDefault:"http://localhost:8080"` // URL where server will be started
-	ServerAddress   string `env:"SERVER_ADDRESS" envDefault:":8080"`           // Server port
-	FileStoragePath string `env:"FILE_STORAGE_PATH"`                           // Path to a file which will be used as a storage
-	SecretKey       string `env:"SECRET_KEY" envDefault:"hello"`               // Secret for hashing ops
-	DatabaseDSN     string `env:"DATABASE_DSN"`                                // Database connection string for DB-style storage
+	BaseURL                 string   `json:"base_url" yaml:"base_url" env:"BASE_URL" envDefault:"http://localhost:8080" reload:"true"`                            // URL where server will be started
+	Domains                 []string `json:"domains" yaml:"domains" env:"DOMAINS" envSeparator:"," reload:"true"`                                                 // Base URLs of additional short link domains, see DomainBaseURL
+	ServerAddress           string   `json:"server_address" yaml:"server_address" env:"SERVER_ADDRESS" envDefault:":8080"`                                        // Server port
+	FileStoragePath         string   `json:"file_storage_path" yaml:"file_storage_path" env:"FILE_STORAGE_PATH"`                                                  // Path to a file which will be used as a storage
+	FileDurability          string   `json:"file_durability" yaml:"file_durability" env:"FILE_DURABILITY" envDefault:"batch"`                                     // always, batch or none, see DurabilityBatch
+	SecretKey               string   `json:"secret_key" yaml:"secret_key" env:"SECRET_KEY" envDefault:"hello" reload:"true"`                                      // Secret for hashing ops
+	PreviousSecretKeys      []string `json:"previous_secret_keys" yaml:"previous_secret_keys" env:"PREVIOUS_SECRET_KEYS" envSeparator:"," reload:"true"`          // Retired secrets still accepted when verifying cookies, for key rotation
+	DatabaseDSN             string   `json:"database_dsn" yaml:"database_dsn" env:"DATABASE_DSN"`                                                                 // Database connection string for DB-style storage
+	AdminUIDs               []string `json:"admin_uids" yaml:"admin_uids" env:"ADMIN_UIDS" envSeparator:"," reload:"true"`                                        // UIDs allowed to use the admin API
+	AdminToken              string   `json:"admin_token" yaml:"admin_token" env:"ADMIN_TOKEN" reload:"true"`                                                      // Bearer token allowed to use the admin API
+	AuditLogPath            string   `json:"audit_log_path" yaml:"audit_log_path" env:"AUDIT_LOG_PATH"`                                                           // Path to the audit log file, Postgres or memory is used if empty
+	AuditKey                string   `json:"audit_key" yaml:"audit_key" env:"AUDIT_KEY"`                                                                          // Key of the audit hash chain, events can be rewritten undetected by anyone with sink access if empty
+	MaxBodySize             int64    `json:"max_body_size" yaml:"max_body_size" env:"MAX_BODY_SIZE" envDefault:"10485760"`                                        // Limit of a request body as sent, 0 is unlimited
+	MaxDecompressedBodySize int64    `json:"max_decompressed_body_size" yaml:"max_decompressed_body_size" env:"MAX_DECOMPRESSED_BODY_SIZE" envDefault:"33554432"` // Limit of a decompressed request body, 0 is unlimited
+	MaxStreamBodySize       int64    `json:"max_stream_body_size" yaml:"max_stream_body_size" env:"MAX_STREAM_BODY_SIZE" envDefault:"1073741824"`                 // Limit of a streamed (NDJSON) request body as sent and decompressed, 0 is unlimited
+	LogLevel                string   `json:"log_level" yaml:"log_level" env:"LOG_LEVEL" envDefault:"info" reload:"true"`                                          // debug, info, warn or error
+	AdminAddress            string   `json:"admin_address" yaml:"admin_address" env:"ADMIN_ADDRESS"`                                                              // Address of the admin listener serving metrics, main listener is used if empty
+	LogFormat               string   `json:"log_format" yaml:"log_format" env:"LOG_FORMAT" envDefault:"text"`                                                     // text or json
+	TraceExporter           string   `json:"trace_exporter" yaml:"trace_exporter" env:"TRACE_EXPORTER"`                                                           // none, stdout or otlp, tracing is off if empty
+	OTLPEndpoint            string   `json:"otlp_endpoint" yaml:"otlp_endpoint" env:"OTLP_ENDPOINT"`                                                              // OTLP/HTTP collector URL, OTEL_EXPORTER_OTLP_* envs are used if empty
+	TraceSampleRatio        float64  `json:"trace_sample_ratio" yaml:"trace_sample_ratio" env:"TRACE_SAMPLE_RATIO" envDefault:"1"`                                // Share of root traces sampled, from 0 to 1
+	ProfilerMode            string   `json:"profiler_mode" yaml:"profiler_mode" env:"PROFILER_MODE" envDefault:"off"`                                             // off, admin (served on AdminAddress) or protected (admins and TrustedSubnet only)
+	TrustedSubnet           string   `json:"trusted_subnet" yaml:"trusted_subnet" env:"TRUSTED_SUBNET" reload:"true"`                                             // CIDR allowed to use protected endpoints without credentials
+	ProfilesDir             string   `json:"profiles_dir" yaml:"profiles_dir" env:"PROFILES_DIR" envDefault:"profiles"`                                           // Directory captured profiles are written to
+	EnableHTTPS             bool     `json:"enable_https" yaml:"enable_https" env:"ENABLE_HTTPS"`                                                                 // Serve HTTPS on ServerAddress
+	TLSCertFile             string   `json:"tls_cert_file" yaml:"tls_cert_file" env:"TLS_CERT_FILE"`                                                              // PEM certificate chain
+	TLSKeyFile              string   `json:"tls_key_file" yaml:"tls_key_file" env:"TLS_KEY_FILE"`                                                                 // PEM private key
+	TLSSelfSigned           bool     `json:"tls_self_signed" yaml:"tls_self_signed" env:"TLS_SELF_SIGNED"`                                                        // Generate a self-signed certificate for development, it is written to the cert/key paths if they are set and don't exist
+	TLSMinVersion           string   `json:"tls_min_version" yaml:"tls_min_version" env:"TLS_MIN_VERSION" envDefault:"1.2"`                                       // 1.0, 1.1, 1.2 or 1.3
+	TLSCipherSuites         []string `json:"tls_cipher_suites" yaml:"tls_cipher_suites" env:"TLS_CIPHER_SUITES" envSeparator:","`                                 // TLS 1.0-1.2 cipher suite names, Go defaults are used if empty
+	HTTPRedirectAddress     string   `json:"http_redirect_address" yaml:"http_redirect_address" env:"HTTP_REDIRECT_ADDRESS"`                                      // Address of a plain HTTP listener redirecting to BaseURL, off if empty
+	HSTSMaxAge              int      `json:"hsts_max_age" yaml:"hsts_max_age" env:"HSTS_MAX_AGE"`                                                                 // Strict-Transport-Security max-age in seconds sent over HTTPS, off if 0
+	PagesDir                string   `json:"pages_dir" yaml:"pages_dir" env:"PAGES_DIR"`                                                                          // Directory with page templates overriding the embedded ones, see pages.New
+	ImportsDir              string   `json:"imports_dir" yaml:"imports_dir" env:"IMPORTS_DIR" envDefault:"imports"`                                               // Directory import uploads, results and job states are kept in, imports are disabled if empty
+	ImportsRetentionSeconds int      `json:"imports_retention_seconds" yaml:"imports_retention_seconds" env:"IMPORTS_RETENTION_SECONDS" envDefault:"604800"`      // How long finished import jobs and their results are kept, forever if 0
+	CacheSize               int      `json:"cache_size" yaml:"cache_size" env:"CACHE_SIZE" envDefault:"10000"`                                                    // Most short codes the redirect cache keeps, the cache is off if 0
+	CacheTTLSeconds         int      `json:"cache_ttl_seconds" yaml:"cache_ttl_seconds" env:"CACHE_TTL_SECONDS" envDefault:"300"`                                 // How long the redirect cache keeps a found URL
+	CacheNegativeTTLSeconds int      `json:"cache_negative_ttl_seconds" yaml:"cache_negative_ttl_seconds" env:"CACHE_NEGATIVE_TTL_SECONDS" envDefault:"30"`       // How long the redirect cache keeps an unknown code, unknown codes aren't cached if 0
+	BloomCapacity           int      `json:"bloom_capacity" yaml:"bloom_capacity" env:"BLOOM_CAPACITY" envDefault:"1000000"`                                      // Short codes the bloom filter rejecting unknown codes is sized for, the filter is off if 0
+	BloomFalsePositiveRate  float64  `json:"bloom_false_positive_rate" yaml:"bloom_false_positive_rate" env:"BLOOM_FALSE_POSITIVE_RATE" envDefault:"0.01"`        // Share of unknown codes the bloom filter lets through to the storage
+	BloomRebuildSeconds     int      `json:"bloom_rebuild_seconds" yaml:"bloom_rebuild_seconds" env:"BLOOM_REBUILD_SECONDS" envDefault:"3600"`                    // How often the bloom filter is rebuilt from the storage, never if 0
+	DrainDelaySeconds       int      `json:"drain_delay_seconds" yaml:"drain_delay_seconds" env:"DRAIN_DELAY_SECONDS" envDefault:"5"`                             // How long /readyz reports draining before the server stops accepting connections
+	ShutdownTimeoutSeconds  int      `json:"shutdown_timeout_seconds" yaml:"shutdown_timeout_seconds" env:"SHUTDOWN_TIMEOUT_SECONDS" envDefault:"30"`             // How long in-flight requests may run after draining
 }
 
-// Init tries to parse os.env and flags passed to the service run command.
-// Flags have priority over os envs.
-// Then in returns a config ready for usage by other service layers.
+// Init loads the config from os args, env and the config file, see Load
 func Init() (*Config, error) {
+	return Load(os.Args[1:])
+}
+
+// flagFields maps command line flags to Config fields
+var flagFields = map[string]string{
+	"a": "ServerAddress",
+	"b": "BaseURL",
+	"f": "FileStoragePath",
+	"s": "SecretKey",
+	"d": "DatabaseDSN",
+}
+
+// NewFlagSet returns the flag set accepted by the service, its values are written to fcfg.
+// configPath receives the -c flag.
+func NewFlagSet(name string, fcfg *Config, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.StringVar(configPath, "c", "", "path to a JSON or YAML config file")
+	fs.StringVar(&fcfg.ServerAddress, "a", "", "server address")
+	fs.StringVar(&fcfg.BaseURL, "b", "", "base url to use in strings")
+	fs.StringVar(&fcfg.FileStoragePath, "f", "", "where to save db")
+	fs.StringVar(&fcfg.SecretKey, "s", "", "secret key")
+	fs.StringVar(&fcfg.DatabaseDSN, "d", "", "database connection string")
+
+	return fs
+}
+
+// Load builds the config from defaults, then the config file given by -c or CONFIG, then env vars
+// and finally args. Only env vars that are set and flags that are passed override earlier layers.
+func Load(args []string) (*Config, error) {
+	cfg, _, err := LoadArgs(args)
+	return cfg, err
+}
+
+// LoadArgs is Load that also returns the args left after the flags, for subcommands
+func LoadArgs(args []string) (*Config, []string, error) {
+	fcfg, configPath := &Config{}, ""
+
+	fs := NewFlagSet("shortener", fcfg, &configPath)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
 	cfg := &Config{}
-	err := env.Parse(cfg)
+	if err := env.Parse(cfg, env.Options{Environment: map[string]string{}}); err != nil {
+		return nil, nil, fmt.Errorf("error: %w", err)
+	}
+
+	if configPath == "" {
+		configPath = os.Getenv("CONFIG")
+	}
 
+	if configPath != "" {
+		if err := loadFile(configPath, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	fcfgValue, cfgValue := reflect.ValueOf(fcfg).Elem(), reflect.ValueOf(cfg).Elem()
+
+	fs.Visit(func(f *flag.Flag) {
+		if field, ok := flagFields[f.Name]; ok {
+			cfgValue.FieldByName(field).Set(fcfgValue.FieldByName(field))
+		}
+	})
+
+	if cfg.EnableHTTPS && cfg.BaseURL == DefaultBaseURL {
+		cfg.BaseURL = "https" + strings.TrimPrefix(DefaultBaseURL, "http")
+	}
+
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+
+	for i, d := range cfg.Domains {
+		cfg.Domains[i] = strings.TrimRight(strings.TrimSpace(d), "/")
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// loadFile reads a YAML file (.yaml or .yml) or a JSON file (anything else) into cfg,
+// keys missing from the file keep their current values
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("error: %w", err)
+		return fmt.Errorf("reading config file: %w", err)
 	}
 
-	flag.StringVar(&cfg.ServerAddress, "a", cfg.ServerAddress, "Server address")
-	flag.StringVar(&cfg.BaseURL, "b", cfg.BaseURL, "base url to use in strings")
-	flag.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath, "where to save db")
-	flag.StringVar(&cfg.SecretKey, "s", cfg.SecretKey, "secret key")
-	flag.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "secret key")
-	flag.Parse()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+
+		err = dec.Decode(cfg)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+
+		err = dec.Decode(cfg)
+	}
+
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv overrides fields of cfg whose env vars are set
+func applyEnv(cfg *Config) error {
+	envCfg := &Config{}
+	if err := env.Parse(envCfg); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+
+	t := reflect.TypeOf(*cfg)
+	cfgValue, envValue := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(envCfg).Elem()
+
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := os.LookupEnv(t.Field(i).Tag.Get("env")); ok {
+			cfgValue.Field(i).Set(envValue.Field(i))
+		}
+	}
 
-	return cfg, nil
+	return nil
 }
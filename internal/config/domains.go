@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// A domain is the lower-cased host name of a base URL without the port. Links are bound to a domain,
+// the domain of BaseURL is stored as the default domain "".
+
+// DomainOf returns the domain of a base URL, a host or a host:port
+func DomainOf(raw string) string {
+	host := raw
+
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return ""
+		}
+
+		host = u.Host
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// LookupDomain returns the domain host belongs to and whether it is configured.
+// The domain of BaseURL is returned as the default domain "".
+func (cfg *Config) LookupDomain(host string) (string, bool) {
+	domain := DomainOf(host)
+	if domain == "" {
+		return "", false
+	}
+
+	if domain == DomainOf(cfg.BaseURL) {
+		return "", true
+	}
+
+	for _, d := range cfg.Domains {
+		if DomainOf(d) == domain {
+			return domain, true
+		}
+	}
+
+	return "", false
+}
+
+// DomainBaseURL returns the base URL short links of domain are built from. Domains which are
+// no longer configured keep the scheme of BaseURL.
+func (cfg *Config) DomainBaseURL(domain string) string {
+	if domain == "" || domain == DomainOf(cfg.BaseURL) {
+		return cfg.BaseURL
+	}
+
+	for _, d := range cfg.Domains {
+		if DomainOf(d) == domain {
+			return d
+		}
+	}
+
+	scheme := "http"
+	if u, err := url.Parse(cfg.BaseURL); err == nil && u.Scheme != "" {
+		scheme = u.Scheme
+	}
+
+	return scheme + "://" + domain
+}
+
+// validateDomains checks every domain like the base URL and rejects repeated domains
+func validateDomains(baseURL string, domains []string) error {
+	errs := []error{}
+	seen := map[string]bool{DomainOf(baseURL): true}
+
+	for _, d := range domains {
+		if err := validateBaseURL(d); err != nil {
+			errs = append(errs, fmt.Errorf("domains: %w", err))
+			continue
+		}
+
+		if seen[DomainOf(d)] {
+			errs = append(errs, fmt.Errorf("domains: %q repeats a configured domain", d))
+		}
+
+		seen[DomainOf(d)] = true
+	}
+
+	return errors.Join(errs...)
+}
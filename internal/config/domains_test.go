@@ -0,0 +1,42 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Domains(t *testing.T) {
+	cfg := &config.Config{
+		BaseURL: "https://sho.rt",
+		Domains: []string{"https://brand-a.io", "http://go.brand-b.com:8080/l"},
+	}
+
+	tests := []struct {
+		name        string
+		host        string
+		wantDomain  string
+		wantOK      bool
+		wantBaseURL string
+	}{
+		{name: "base url host", host: "sho.rt", wantDomain: "", wantOK: true, wantBaseURL: "https://sho.rt"},
+		{name: "configured domain", host: "brand-a.io", wantDomain: "brand-a.io", wantOK: true, wantBaseURL: "https://brand-a.io"},
+		{name: "port and case are ignored", host: "Go.Brand-B.com:443", wantDomain: "go.brand-b.com", wantOK: true, wantBaseURL: "http://go.brand-b.com:8080/l"},
+		{name: "base url of a domain", host: "https://brand-a.io/", wantDomain: "brand-a.io", wantOK: true, wantBaseURL: "https://brand-a.io"},
+		{name: "unknown host", host: "127.0.0.1:8080", wantDomain: "", wantOK: false, wantBaseURL: "https://sho.rt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain, ok := cfg.LookupDomain(tt.host)
+
+			assert.Equal(t, tt.wantDomain, domain)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantBaseURL, cfg.DomainBaseURL(domain))
+		})
+	}
+
+	assert.Equal(t, "https://removed.io", cfg.DomainBaseURL("removed.io"), "removed domains keep the base url scheme")
+}
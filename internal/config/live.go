@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// ErrNotReloadable is returned by Reload when fields that need a restart were changed
+	ErrNotReloadable = errors.New("fields can't be changed without a restart")
+	// ErrDefaultDomainChanged is returned by Reload when the domain of BaseURL was changed
+	ErrDefaultDomainChanged = errors.New("the base_url host can't be changed")
+)
+
+// Provider returns the config to use right now. Both a static *Config and a reloadable *Live are providers.
+type Provider interface {
+	Get() *Config
+}
+
+// Get returns cfg itself, so a plain *Config can be used as a Provider
+func (cfg *Config) Get() *Config {
+	return cfg
+}
+
+// SigningKeys returns the secret used for new signatures followed by the retired ones accepted for verification
+func (cfg *Config) SigningKeys() []string {
+	return append([]string{cfg.SecretKey}, cfg.PreviousSecretKeys...)
+}
+
+// Live holds the config of a running service and swaps it atomically on reload.
+// Configs returned by Get must not be modified.
+type Live struct {
+	cfg  atomic.Pointer[Config]
+	args []string
+}
+
+// NewLive creates a holder with the initial config cfg, reloads will parse args again
+func NewLive(cfg *Config, args []string) *Live {
+	l := &Live{args: args}
+	l.cfg.Store(cfg)
+
+	return l
+}
+
+// Get returns the current config
+func (l *Live) Get() *Config {
+	return l.cfg.Load()
+}
+
+// Reload loads the config again from the file, env and the original args and validates it.
+// If only reloadable fields changed the new config replaces the current one and the names of changed
+// fields are returned together with validation warnings, otherwise the current config is kept.
+func (l *Live) Reload() (changed, warnings []string, err error) {
+	next, err := Load(l.args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	warnings, err = next.Validate()
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	changed, fixed := diff(l.Get(), next)
+	if len(fixed) > 0 {
+		return nil, warnings, fmt.Errorf("%w: %s", ErrNotReloadable, strings.Join(fixed, ", "))
+	}
+
+	if err = checkDefaultDomain(l.Get(), next); err != nil {
+		return nil, warnings, err
+	}
+
+	l.cfg.Store(next)
+
+	return changed, warnings, nil
+}
+
+// checkDefaultDomain rejects a change of the BaseURL domain, which links are stored under as the default domain "".
+// Changing it would move all of them to another host, and moving a domain between BaseURL and Domains would orphan
+// the links stored under one of them. The scheme and the port of BaseURL can still change.
+func checkDefaultDomain(current, next *Config) error {
+	from, to := DomainOf(current.BaseURL), DomainOf(next.BaseURL)
+	if from == to {
+		return nil
+	}
+
+	for _, d := range next.Domains {
+		if DomainOf(d) == from {
+			return fmt.Errorf("%w: %q would move from base_url to domains", ErrDefaultDomainChanged, from)
+		}
+	}
+
+	for _, d := range current.Domains {
+		if DomainOf(d) == to {
+			return fmt.Errorf("%w: %q would move from domains to base_url", ErrDefaultDomainChanged, to)
+		}
+	}
+
+	return fmt.Errorf("%w: %q would become %q", ErrDefaultDomainChanged, from, to)
+}
+
+// diff returns the json names of fields that differ between a and b, split into reloadable and fixed ones
+func diff(a, b *Config) (reloadable, fixed []string) {
+	t := reflect.TypeOf(*a)
+	av, bv := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			continue
+		}
+
+		name := t.Field(i).Tag.Get("json")
+		if t.Field(i).Tag.Get("reload") == "true" {
+			reloadable = append(reloadable, name)
+		} else {
+			fixed = append(fixed, name)
+		}
+	}
+
+	return reloadable, fixed
+}
@@ -0,0 +1,95 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LiveReload(t *testing.T) {
+	path := writeFile(t, "config.yaml", "log_level: info\nsecret_key: a-long-enough-secret-key\n")
+	args := []string{"-c", path}
+
+	cfg, err := config.Load(args)
+	assert.NoError(t, err)
+
+	live := config.NewLive(cfg, args)
+
+	tests := []struct {
+		name        string
+		file        string
+		wantChanged []string
+		wantErr     error
+		wantLevel   string
+	}{
+		{
+			name:        "reloadable fields are swapped",
+			file:        "log_level: debug\nsecret_key: another-long-secret-key\nadmin_uids: [a]\n",
+			wantChanged: []string{"secret_key", "admin_uids", "log_level"},
+			wantLevel:   "debug",
+		},
+		{
+			name:      "fixed fields are rejected",
+			file:      "log_level: warn\nsecret_key: another-long-secret-key\nserver_address: \":9090\"\n",
+			wantErr:   config.ErrNotReloadable,
+			wantLevel: "debug",
+		},
+		{
+			name:        "base url scheme and port are reloadable",
+			file:        "log_level: debug\nsecret_key: another-long-secret-key\nadmin_uids: [a]\nbase_url: https://localhost\ndomains: [https://brand.io]\n",
+			wantChanged: []string{"base_url", "domains"},
+			wantLevel:   "debug",
+		},
+		{
+			name:      "base url host is fixed",
+			file:      "log_level: warn\nsecret_key: another-long-secret-key\nbase_url: https://sho.rt\ndomains: [https://brand.io]\n",
+			wantErr:   config.ErrDefaultDomainChanged,
+			wantLevel: "debug",
+		},
+		{
+			name:      "base url host can't move to domains",
+			file:      "log_level: warn\nsecret_key: another-long-secret-key\nbase_url: https://sho.rt\ndomains: [https://localhost, https://brand.io]\n",
+			wantErr:   config.ErrDefaultDomainChanged,
+			wantLevel: "debug",
+		},
+		{
+			name:      "domain can't move to base url",
+			file:      "log_level: warn\nsecret_key: another-long-secret-key\nbase_url: https://brand.io\n",
+			wantErr:   config.ErrDefaultDomainChanged,
+			wantLevel: "debug",
+		},
+		{
+			name:      "invalid config is rejected",
+			file:      "log_level: loud\n",
+			wantLevel: "debug",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, os.WriteFile(path, []byte(tt.file), 0o600))
+
+			before := live.Get()
+			changed, _, err := live.Reload()
+
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.wantChanged == nil:
+				assert.Error(t, err)
+			default:
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantChanged, changed)
+			}
+
+			if err != nil {
+				assert.Same(t, before, live.Get())
+			}
+
+			assert.Equal(t, tt.wantLevel, live.Get().LogLevel)
+		})
+	}
+}
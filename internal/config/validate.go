@@ -0,0 +1,237 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/T-V-N/gourlshortener/internal/tlsutil"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DefaultSecretKey is the built-in secret, Validate warns when it is used
+const DefaultSecretKey = "hello"
+
+// minSecretKeyLength is the shortest secret Validate accepts without a warning
+const minSecretKeyLength = 16
+
+// redacted replaces secrets in Redacted
+const redacted = "[redacted]"
+
+// dsnPassword matches the password of a key/value DSN
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Validate checks that the config can be used to start the service. Fatal problems are joined into
+// the returned error, problems the service can still run with (e.g. a weak secret) are returned as warnings.
+func (cfg *Config) Validate() (warnings []string, err error) {
+	errs := []error{}
+
+	if err := validateBaseURL(cfg.BaseURL); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateDomains(cfg.BaseURL, cfg.Domains); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateAddress("server address", cfg.ServerAddress); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.AdminAddress != "" {
+		if err := validateAddress("admin address", cfg.AdminAddress); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if cfg.DatabaseDSN != "" {
+		if _, err := pgconn.ParseConfig(cfg.DatabaseDSN); err != nil {
+			// pgconn errors quote the DSN, so only the reason is reported
+			errs = append(errs, errors.New("database dsn is malformed"))
+		}
+	}
+
+	switch cfg.FileDurability {
+	case DurabilityAlways, DurabilityBatch, DurabilityNone, "":
+	default:
+		errs = append(errs, fmt.Errorf("unknown file durability %q", cfg.FileDurability))
+	}
+
+	switch {
+	case cfg.SecretKey == "":
+		errs = append(errs, errors.New("secret key is empty"))
+	case cfg.SecretKey == DefaultSecretKey:
+		warnings = append(warnings, "secret key is the built-in default, auth cookies can be forged")
+	case len(cfg.SecretKey) < minSecretKeyLength:
+		warnings = append(warnings, fmt.Sprintf("secret key is shorter than %d characters", minSecretKeyLength))
+	}
+
+	if cfg.AuditKey == "" {
+		warnings = append(warnings, "audit key is empty, the audit log can be rewritten by anyone with access to its sink")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("unknown log level %q", cfg.LogLevel))
+	}
+
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" && cfg.LogFormat != "" {
+		errs = append(errs, fmt.Errorf("unknown log format %q", cfg.LogFormat))
+	}
+
+	switch cfg.ProfilerMode {
+	case ProfilerOff, ProfilerProtected, "":
+	case ProfilerAdmin:
+		if cfg.AdminAddress == "" {
+			errs = append(errs, errors.New("profiler mode admin requires an admin address"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown profiler mode %q", cfg.ProfilerMode))
+	}
+
+	if cfg.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(cfg.TrustedSubnet); err != nil {
+			errs = append(errs, fmt.Errorf("trusted subnet: %w", err))
+		}
+	}
+
+	switch cfg.TraceExporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("unknown trace exporter %q", cfg.TraceExporter))
+	}
+
+	if cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("trace sample ratio %v is not between 0 and 1", cfg.TraceSampleRatio))
+	}
+
+	if cfg.EnableHTTPS {
+		if !cfg.TLSSelfSigned && (cfg.TLSCertFile == "" || cfg.TLSKeyFile == "") {
+			errs = append(errs, errors.New("https needs a certificate and a key file or a self-signed certificate"))
+		}
+
+		if u, err := url.Parse(cfg.BaseURL); err == nil && u.Scheme == "http" {
+			warnings = append(warnings, "https is enabled but the base url uses http")
+		}
+	}
+
+	if cfg.HTTPRedirectAddress != "" {
+		if err := validateAddress("http redirect address", cfg.HTTPRedirectAddress); err != nil {
+			errs = append(errs, err)
+		}
+
+		if !cfg.EnableHTTPS {
+			warnings = append(warnings, "http redirect listener is set but https is disabled")
+		}
+	}
+
+	if _, err := tlsutil.ParseVersion(cfg.TLSMinVersion); cfg.TLSMinVersion != "" && err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := tlsutil.ParseCipherSuites(cfg.TLSCipherSuites); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.DrainDelaySeconds < 0 || cfg.ShutdownTimeoutSeconds < 0 {
+		errs = append(errs, errors.New("drain delay and shutdown timeout can't be negative"))
+	}
+
+	if cfg.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("hsts max age can't be negative"))
+	}
+
+	if cfg.MaxBodySize < 0 || cfg.MaxDecompressedBodySize < 0 || cfg.MaxStreamBodySize < 0 {
+		errs = append(errs, errors.New("body size limits can't be negative"))
+	}
+
+	if cfg.CacheSize < 0 || cfg.CacheTTLSeconds < 0 || cfg.CacheNegativeTTLSeconds < 0 {
+		errs = append(errs, errors.New("cache size and ttls can't be negative"))
+	}
+
+	if cfg.BloomCapacity < 0 || cfg.BloomRebuildSeconds < 0 {
+		errs = append(errs, errors.New("bloom filter capacity and rebuild interval can't be negative"))
+	}
+
+	if cfg.BloomCapacity > 0 && (cfg.BloomFalsePositiveRate <= 0 || cfg.BloomFalsePositiveRate >= 1) {
+		errs = append(errs, fmt.Errorf("bloom false positive rate %v is not between 0 and 1", cfg.BloomFalsePositiveRate))
+	}
+
+	return warnings, errors.Join(errs...)
+}
+
+// validateBaseURL requires an absolute http(s) URL without a query or fragment
+func validateBaseURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("base url: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("base url %q must start with http:// or https://", raw)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("base url %q has no host", raw)
+	}
+
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("base url %q can't have a query or a fragment", raw)
+	}
+
+	return nil
+}
+
+// validateAddress requires a host:port listen address with a valid port
+func validateAddress(name, addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%s %q has an invalid port", name, addr)
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the config with secrets and the DSN password replaced, safe to print or log
+func (cfg *Config) Redacted() *Config {
+	c := *cfg
+	c.AdminUIDs = append([]string(nil), cfg.AdminUIDs...)
+	c.PreviousSecretKeys = nil
+
+	for range cfg.PreviousSecretKeys {
+		c.PreviousSecretKeys = append(c.PreviousSecretKeys, redacted)
+	}
+
+	if c.SecretKey != "" {
+		c.SecretKey = redacted
+	}
+
+	if c.AdminToken != "" {
+		c.AdminToken = redacted
+	}
+
+	if c.AuditKey != "" {
+		c.AuditKey = redacted
+	}
+
+	c.DatabaseDSN = redactDSN(c.DatabaseDSN)
+
+	return &c
+}
+
+// redactDSN hides the password of a URL or key/value DSN
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
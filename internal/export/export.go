@@ -0,0 +1,232 @@
+// Package export writes links of a user in formats meant for backups and migrations to other services
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV           = "csv"
+	FormatNDJSON        = "ndjson"
+	FormatJSON          = "json"
+	FormatHTMLBookmarks = "html-bookmarks" // Netscape bookmark file browsers import
+)
+
+// Link statuses
+const (
+	StatusActive  = "active"
+	StatusDeleted = "deleted"
+)
+
+// ErrUnknownFormat is returned for formats other than csv, ndjson, json and html-bookmarks
+var ErrUnknownFormat = errors.New("unknown format, use csv, ndjson, json or html-bookmarks")
+
+// Link is an exported URL. Clicks and tags aren't recorded by the service, so links have no fields for them.
+type Link struct {
+	ShortURL    string     `json:"short_url"` // the short link
+	Code        string     `json:"code"`      // the short code the link ends with
+	OriginalURL string     `json:"original_url"`
+	Domain      string     `json:"domain,omitempty"`
+	WorkspaceID string     `json:"workspace_id,omitempty"`
+	Status      string     `json:"status"`               // active or deleted
+	CreatedAt   *time.Time `json:"created_at,omitempty"` // nil if unknown
+}
+
+// Writer writes links one by one, Close completes the output and flushes it but doesn't close the underlying writer
+type Writer interface {
+	Write(l Link) error
+	Close() error
+}
+
+// format describes the output of a format
+type format struct {
+	contentType string
+	extension   string
+	new         func(w *bufio.Writer) (Writer, error)
+}
+
+var formats = map[string]format{
+	FormatCSV:           {"text/csv; charset=utf-8", "csv", newCSVWriter},
+	FormatNDJSON:        {"application/x-ndjson", "ndjson", newNDJSONWriter},
+	FormatJSON:          {"application/json", "json", newJSONWriter},
+	FormatHTMLBookmarks: {"text/html; charset=utf-8", "html", newBookmarksWriter},
+}
+
+// ContentType returns the content type of a format
+func ContentType(name string) string {
+	return formats[name].contentType
+}
+
+// Extension returns the file name extension of a format
+func Extension(name string) string {
+	return formats[name].extension
+}
+
+// NewWriter returns a writer of links in format to w
+func NewWriter(w io.Writer, name string) (Writer, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+
+	return f.new(bufio.NewWriter(w))
+}
+
+// csvHeader is the first line of CSV exports
+var csvHeader = []string{"short_url", "code", "original_url", "domain", "workspace_id", "status", "created_at"}
+
+type csvWriter struct {
+	bw *bufio.Writer
+	cw *csv.Writer
+}
+
+func newCSVWriter(bw *bufio.Writer) (Writer, error) {
+	cw := csv.NewWriter(bw)
+
+	return &csvWriter{bw, cw}, cw.Write(csvHeader)
+}
+
+func (w *csvWriter) Write(l Link) error {
+	createdAt := ""
+	if l.CreatedAt != nil {
+		createdAt = l.CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	return w.cw.Write([]string{l.ShortURL, l.Code, l.OriginalURL, l.Domain, l.WorkspaceID, l.Status, createdAt})
+}
+
+func (w *csvWriter) Close() error {
+	w.cw.Flush()
+
+	if err := w.cw.Error(); err != nil {
+		return err
+	}
+
+	return w.bw.Flush()
+}
+
+type ndjsonWriter struct {
+	bw  *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(bw *bufio.Writer) (Writer, error) {
+	return &ndjsonWriter{bw, newEncoder(bw)}, nil
+}
+
+// newEncoder returns an encoder leaving the characters of URLs as they are
+func newEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	return enc
+}
+
+func (w *ndjsonWriter) Write(l Link) error {
+	return w.enc.Encode(l)
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.bw.Flush()
+}
+
+// jsonWriter writes a JSON array, an element per line
+type jsonWriter struct {
+	bw    *bufio.Writer
+	buf   bytes.Buffer
+	enc   *json.Encoder // encodes to buf
+	empty bool
+}
+
+func newJSONWriter(bw *bufio.Writer) (Writer, error) {
+	w := &jsonWriter{bw: bw, empty: true}
+	w.enc = newEncoder(&w.buf)
+	_, err := bw.WriteString("[")
+
+	return w, err
+}
+
+func (w *jsonWriter) Write(l Link) error {
+	sep := ",\n"
+	if w.empty {
+		sep, w.empty = "\n", false
+	}
+
+	if _, err := w.bw.WriteString(sep); err != nil {
+		return err
+	}
+
+	w.buf.Reset()
+
+	if err := w.enc.Encode(l); err != nil {
+		return err
+	}
+
+	_, err := w.bw.Write(bytes.TrimSuffix(w.buf.Bytes(), []byte("\n")))
+
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	end := "\n]\n"
+	if w.empty {
+		end = "]\n"
+	}
+
+	if _, err := w.bw.WriteString(end); err != nil {
+		return err
+	}
+
+	return w.bw.Flush()
+}
+
+// bookmarksWriter writes a Netscape bookmark file: a bookmark of the original URL titled with the short link
+// per active link. Deleted links are left out, bookmarks have no status.
+type bookmarksWriter struct {
+	bw *bufio.Writer
+}
+
+const bookmarksHeader = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`
+
+func newBookmarksWriter(bw *bufio.Writer) (Writer, error) {
+	_, err := bw.WriteString(bookmarksHeader)
+
+	return &bookmarksWriter{bw}, err
+}
+
+func (w *bookmarksWriter) Write(l Link) error {
+	if l.Status == StatusDeleted {
+		return nil
+	}
+
+	addDate := ""
+	if l.CreatedAt != nil {
+		addDate = fmt.Sprintf(` ADD_DATE="%d"`, l.CreatedAt.Unix())
+	}
+
+	_, err := fmt.Fprintf(w.bw, "    <DT><A HREF=\"%s\"%s>%s</A>\n", html.EscapeString(l.OriginalURL), addDate, html.EscapeString(l.ShortURL))
+
+	return err
+}
+
+func (w *bookmarksWriter) Close() error {
+	if _, err := w.bw.WriteString("</DL><p>\n"); err != nil {
+		return err
+	}
+
+	return w.bw.Flush()
+}
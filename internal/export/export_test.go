@@ -0,0 +1,99 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/export"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var createdAt = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+var links = []export.Link{
+	{ShortURL: "http://localhost:8080/a1", Code: "a1", OriginalURL: "https://example.com/?q=1&r=<2>", Status: export.StatusActive, CreatedAt: &createdAt},
+	{ShortURL: "https://go.example/b2", Code: "b2", OriginalURL: "https://example.com/b", Domain: "go.example", Status: export.StatusDeleted},
+}
+
+func Test_NewWriter(t *testing.T) {
+	tests := []struct {
+		format string
+		links  []export.Link
+		want   string
+	}{
+		{
+			format: export.FormatCSV,
+			links:  links,
+			want: "short_url,code,original_url,domain,workspace_id,status,created_at\n" +
+				"http://localhost:8080/a1,a1,https://example.com/?q=1&r=<2>,,,active,2024-05-01T12:30:00Z\n" +
+				"https://go.example/b2,b2,https://example.com/b,go.example,,deleted,\n",
+		},
+		{
+			format: export.FormatNDJSON,
+			links:  links,
+			want: `{"short_url":"http://localhost:8080/a1","code":"a1","original_url":"https://example.com/?q=1&r=<2>","status":"active","created_at":"2024-05-01T12:30:00Z"}` + "\n" +
+				`{"short_url":"https://go.example/b2","code":"b2","original_url":"https://example.com/b","domain":"go.example","status":"deleted"}` + "\n",
+		},
+		{
+			format: export.FormatJSON,
+			links:  nil,
+			want:   "[]\n",
+		},
+		{
+			format: export.FormatHTMLBookmarks,
+			links:  links,
+			want: `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><A HREF="https://example.com/?q=1&amp;r=&lt;2&gt;" ADD_DATE="1714566600">http://localhost:8080/a1</A>
+</DL><p>
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+
+			w, err := export.NewWriter(buf, tt.format)
+			require.NoError(t, err)
+
+			for _, l := range tt.links {
+				require.NoError(t, w.Write(l))
+			}
+
+			require.NoError(t, w.Close())
+			assert.Equal(t, tt.want, buf.String())
+			assert.NotEmpty(t, export.ContentType(tt.format))
+			assert.NotEmpty(t, export.Extension(tt.format))
+		})
+	}
+}
+
+func Test_JSONIsAnArray(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	w, err := export.NewWriter(buf, export.FormatJSON)
+	require.NoError(t, err)
+
+	for _, l := range links {
+		require.NoError(t, w.Write(l))
+	}
+
+	require.NoError(t, w.Close())
+
+	got := []export.Link{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, links, got)
+}
+
+func Test_UnknownFormat(t *testing.T) {
+	_, err := export.NewWriter(&bytes.Buffer{}, "xml")
+	assert.ErrorIs(t, err, export.ErrUnknownFormat)
+}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+)
+
+// Credentials is used to register and log in a user
+type Credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	Claim    bool   `json:"claim,omitempty"` // login only: move the URLs of the current anonymous uid to the account
+}
+
+// PasswordChange is used to change the password of the current account
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// ClaimRequest carries an anonymous auth token (e.g. copied from another browser) whose URLs should be claimed
+type ClaimRequest struct {
+	Token string `json:"token"`
+}
+
+// ClaimResult is returned by login and claim handlers
+type ClaimResult struct {
+	UID     string `json:"uid,omitempty"`
+	Claimed int    `json:"claimed"`
+}
+
+// HandleRegister creates an account bound to the current uid, so its URLs stay with the user,
+// and sets the session cookie of the account.
+// HTTP response codes:
+//
+//	201 - the account was created, the cookie is set
+//	400 - request body is malformed, login is empty or password is too short
+//	409 - login is taken or the current uid is already registered
+//	500 - something wrong on the app layer
+func (h *Handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	creds := Credentials{}
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		http.Error(w, "Error while parsing credentials", http.StatusBadRequest)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	err := h.app.Register(ctx, uid, creds.Login, creds.Password)
+
+	switch {
+	case errors.Is(err, app.ErrInvalidCredentials):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, app.ErrAlreadyRegistered):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	cookie, err := auth.SessionCookie(uid, 0, h.app.Config().SigningKeys()...)
+	if err != nil {
+		http.Error(w, "Can't issue the token", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, cookie)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandleLogin checks credentials and sets the account session cookie. URLs of the current anonymous uid are only
+// claimed if the claim field is set, otherwise they stay with the anonymous uid and can be claimed later by its token.
+// The session lasts until the user logs out or changes the password.
+// HTTP response codes:
+//
+//	200 - OK, the cookie is set, the number of claimed URLs is in the body
+//	400 - request body is malformed
+//	401 - wrong login or password
+//	500 - something wrong on the app layer
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	creds := Credentials{}
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		http.Error(w, "Error while parsing credentials", http.StatusBadRequest)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	u, claimed, err := h.app.Login(ctx, uid, creds.Login, creds.Password, creds.Claim)
+	if errors.Is(err, app.ErrInvalidCredentials) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	cookie, err := auth.SessionCookie(u.UID, u.SessionVersion, h.app.Config().SigningKeys()...)
+	if err != nil {
+		http.Error(w, "Can't issue the token", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, cookie)
+	w.Header().Set("content-type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(ClaimResult{UID: u.UID, Claimed: claimed}); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleLogout revokes all sessions of the current account and replaces the cookie with a new anonymous one
+// HTTP response codes:
+//
+//	200 - OK, a new anonymous cookie is set
+//	500 - the sessions can't be revoked or the cookie can't be generated
+func (h *Handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	if err := h.app.Logout(ctx, uid); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	cookie, _, err := auth.NewCookie(h.app.Config().SecretKey)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, cookie)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleChangePassword changes the password of the current account. All sessions of the account are revoked,
+// the caller gets a new session cookie.
+// HTTP response codes:
+//
+//	200 - OK, a new session cookie is set
+//	400 - request body is malformed or the new password is too short
+//	401 - the old password is wrong
+//	403 - the current uid is anonymous
+//	500 - something wrong on the app layer
+func (h *Handler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	req := PasswordChange{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Error while parsing passwords", http.StatusBadRequest)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	u, err := h.app.ChangePassword(ctx, uid, req.OldPassword, req.NewPassword)
+
+	switch {
+	case errors.Is(err, app.ErrWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, app.ErrInvalidCredentials):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, app.ErrNotRegistered):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	cookie, err := auth.SessionCookie(u.UID, u.SessionVersion, h.app.Config().SigningKeys()...)
+	if err != nil {
+		http.Error(w, "Can't issue the token", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, cookie)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleClaim moves URLs of another anonymous uid (identified by its auth token) to the current account
+// HTTP response codes:
+//
+//	200 - OK, the number of claimed URLs is in the body
+//	400 - request body is malformed or the token is invalid
+//	403 - the current uid is anonymous
+//	409 - the token belongs to an account
+//	500 - something wrong on the app layer
+func (h *Handler) HandleClaim(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	req := ClaimRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Error while parsing token", http.StatusBadRequest)
+		return
+	}
+
+	fromUID, err := auth.UIDFromToken(req.Token, h.app.Config().SigningKeys()...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	claimed, err := h.app.Claim(ctx, uid, fromUID)
+
+	switch {
+	case errors.Is(err, app.ErrNotRegistered):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, app.ErrAlreadyRegistered):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(ClaimResult{Claimed: claimed}); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,144 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HandleAccountFlow(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	hn := handler.InitHandler(a)
+
+	_, accountUID, _ := auth.NewCookie(cfg.SecretKey)
+	anonCookie, anonUID, _ := auth.NewCookie(cfg.SecretKey)
+
+	do := func(h http.HandlerFunc, uid string, body interface{}) *httptest.ResponseRecorder {
+		buf := bytes.NewBuffer([]byte{})
+		_ = json.NewEncoder(buf).Encode(body)
+
+		request := httptest.NewRequest(http.MethodPost, "/", buf)
+		request = request.WithContext(context.WithValue(request.Context(), auth.UIDKey{}, uid))
+
+		w := httptest.NewRecorder()
+		h(w, request)
+
+		return w
+	}
+
+	t.Run("register with a short password", func(t *testing.T) {
+		w := do(hn.HandleRegister, accountUID, handler.Credentials{Login: "bob", Password: "short"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("register", func(t *testing.T) {
+		w := do(hn.HandleRegister, accountUID, handler.Credentials{Login: "bob", Password: "long enough"})
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("register the same login", func(t *testing.T) {
+		w := do(hn.HandleRegister, anonUID, handler.Credentials{Login: "bob", Password: "long enough"})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("login with a wrong password", func(t *testing.T) {
+		w := do(hn.HandleLogin, anonUID, handler.Credentials{Login: "bob", Password: "wrong password"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("login keeps anonymous URLs unless asked to claim them", func(t *testing.T) {
+		_, err := a.SaveURL(context.Background(), "https://claimed.example", anonUID, "")
+		assert.NoError(t, err)
+
+		w := do(hn.HandleLogin, anonUID, handler.Credentials{Login: "bob", Password: "long enough"})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		res := handler.ClaimResult{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.Equal(t, 0, res.Claimed)
+
+		urls, _ := a.GetURLByUID(anonUID, context.Background())
+		assert.Len(t, urls, 1)
+	})
+
+	t.Run("login claims anonymous URLs", func(t *testing.T) {
+		w := do(hn.HandleLogin, anonUID, handler.Credentials{Login: "bob", Password: "long enough", Claim: true})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		res := handler.ClaimResult{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.Equal(t, accountUID, res.UID)
+		assert.Equal(t, 1, res.Claimed)
+
+		cookie := w.Result().Cookies()[0]
+		uid, err := auth.UIDFromToken(cookie.Value, cfg.SecretKey)
+		assert.NoError(t, err)
+		assert.Equal(t, accountUID, uid)
+
+		urls, _ := a.GetURLByUID(accountUID, context.Background())
+		assert.Len(t, urls, 1)
+	})
+
+	t.Run("claim by a token from another device", func(t *testing.T) {
+		_, otherUID, _ := auth.NewCookie(cfg.SecretKey)
+		otherCookie, _ := auth.CookieForUID(otherUID, cfg.SecretKey)
+		_, err := a.SaveURL(context.Background(), "https://other-device.example", otherUID, "")
+		assert.NoError(t, err)
+
+		w := do(hn.HandleClaim, accountUID, handler.ClaimRequest{Token: otherCookie.Value})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		urls, _ := a.GetURLByUID(accountUID, context.Background())
+		assert.Len(t, urls, 2)
+	})
+
+	t.Run("claim from an anonymous uid", func(t *testing.T) {
+		w := do(hn.HandleClaim, anonUID, handler.ClaimRequest{Token: anonCookie.Value})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("claim with a forged token", func(t *testing.T) {
+		w := do(hn.HandleClaim, accountUID, handler.ClaimRequest{Token: "deadbeef"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	passwordTests := []struct {
+		name       string
+		uid        string
+		req        handler.PasswordChange
+		wantStatus int
+	}{
+		{"change the password of an anonymous uid", anonUID, handler.PasswordChange{OldPassword: "long enough", NewPassword: "even longer"}, http.StatusForbidden},
+		{"change the password to a short one", accountUID, handler.PasswordChange{OldPassword: "long enough", NewPassword: "short"}, http.StatusBadRequest},
+		{"change the password with a wrong one", accountUID, handler.PasswordChange{OldPassword: "wrong password", NewPassword: "even longer"}, http.StatusUnauthorized},
+		{"change the password", accountUID, handler.PasswordChange{OldPassword: "long enough", NewPassword: "even longer"}, http.StatusOK},
+	}
+
+	for _, tt := range passwordTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(hn.HandleChangePassword, tt.uid, tt.req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+
+	t.Run("login with the changed password", func(t *testing.T) {
+		w := do(hn.HandleLogin, anonUID, handler.Credentials{Login: "bob", Password: "long enough"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = do(hn.HandleLogin, anonUID, handler.Credentials{Login: "bob", Password: "even longer"})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/audit"
+)
+
+// AuditVerifyResult is returned by the audit chain verification
+type AuditVerifyResult struct {
+	Events int    `json:"events"`
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error,omitempty"`
+}
+
+// parseAuditFilter reads the actor, action, target, from, to (RFC 3339) and limit (latest events) query params
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	f := audit.Filter{ActorUID: q.Get("actor"), Action: q.Get("action"), Target: q.Get("target")}
+
+	var err error
+
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			return f, errors.New("invalid limit")
+		}
+	}
+
+	return f, nil
+}
+
+// HandleAuditQuery returns audit events matching the query filters. It must be mounted behind the admin MW.
+// HTTP response codes:
+//
+//	200 - OK, events are in the body
+//	400 - a filter is malformed
+//	500 - the audit sink can't be read
+func (h *Handler) HandleAuditQuery(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.app.Audit.Query(ctx, f)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJSON(w, events)
+}
+
+// HandleAuditVerify checks the audit hash chain. It must be mounted behind the admin MW.
+// HTTP response codes:
+//
+//	200 - OK, the chain is intact
+//	409 - the chain is broken, the first bad event is in the body
+//	500 - the audit sink can't be read
+func (h *Handler) HandleAuditVerify(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	n, err := h.app.Audit.Verify(ctx)
+	if err != nil && !errors.Is(err, audit.ErrBrokenChain) {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	res := AuditVerifyResult{Events: n, Valid: err == nil}
+	if err != nil {
+		res.Error = err.Error()
+
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+	}
+
+	h.writeJSON(w, res)
+}
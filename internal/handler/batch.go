@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// streamChunkSize is the number of URLs of a streamed batch saved together
+const streamChunkSize = 1000
+
+// StreamError is the last line of a streamed batch response that failed after results were sent
+type StreamError struct {
+	Error string `json:"error"`
+}
+
+// Content types of streamed bodies
+const (
+	NDJSONContentType = "application/x-ndjson"
+	CSVContentType    = "text/csv"
+)
+
+// mediaType returns the media type of the request body
+func mediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType
+}
+
+// IsStream reports whether the request body is processed as a stream rather than read at once:
+// NDJSON batches, import uploads and migrated exports. Such bodies may be far larger than others.
+func IsStream(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+
+	switch r.URL.Path {
+	case "/api/shorten/batch":
+		return mediaType(r) == NDJSONContentType
+	case "/api/imports", "/api/user/urls/migrate":
+		return true
+	}
+
+	return false
+}
+
+// handleShortenBatchStream saves a batch sent as NDJSON, one storage.BatchURL per line, in chunks of streamChunkSize.
+// Results of a chunk are sent as NDJSON lines of app.BatchResult once the chunk is saved. Skipped URLs get
+// results with an error, a chunk that can't be saved ends the response with a StreamError line.
+// The batch runs until the request is cancelled, there is no timeout.
+// HTTP response codes:
+//
+//	201 - the first chunk was saved, results follow
+//	400 - the first chunk is malformed
+//	413 - the body is larger than allowed before the first chunk is complete
+//	500 - the first chunk can't be saved
+//	505 - an HTTP/1 body can't be read while results are sent, e.g. the writer is wrapped by a MW
+func (h *Handler) handleShortenBatchStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	uid, _ := ctx.Value(auth.UIDKey{}).(string)
+	domain := h.app.DomainForHost(r.Host)
+
+	rc := http.NewResponseController(w)
+
+	// HTTP/1 bodies are closed once a response is written otherwise, HTTP/2 is full duplex anyway
+	if err := rc.EnableFullDuplex(); err != nil && r.ProtoMajor < 2 {
+		logger.FromContext(ctx).Error("enabling full duplex", "error", err)
+		http.Error(w, "Streamed batches need full duplex HTTP/1.1 or HTTP/2", http.StatusHTTPVersionNotSupported)
+
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	enc := json.NewEncoder(w)
+	chunk := make([]storage.BatchURL, 0, streamChunkSize)
+	started := false
+
+	// fail answers with status if nothing was sent yet, or ends the results with a StreamError
+	fail := func(status int, msg string) {
+		if !started {
+			http.Error(w, msg, status)
+			return
+		}
+
+		if err := enc.Encode(StreamError{Error: msg}); err != nil {
+			logger.FromContext(ctx).Error("writing response", "error", err)
+		}
+	}
+
+	save := func() bool {
+		results, err := h.app.BatchSaveChunk(ctx, chunk, uid, domain)
+		chunk = chunk[:0]
+
+		if err != nil {
+			logger.FromContext(ctx).Error("saving batch chunk", "error", err)
+			fail(http.StatusInternalServerError, "Something went wrong")
+
+			return false
+		}
+
+		if !started {
+			w.Header().Set("content-type", NDJSONContentType)
+			w.WriteHeader(http.StatusCreated)
+			started = true
+		}
+
+		for _, res := range results {
+			if err := enc.Encode(res); err != nil {
+				logger.FromContext(ctx).Error("writing response", "error", err)
+				return false
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			logger.FromContext(ctx).Error("flushing batch results", "error", err)
+			fail(http.StatusInternalServerError, "Results can't be streamed")
+
+			return false
+		}
+
+		return true
+	}
+
+	for {
+		item := storage.BatchURL{}
+
+		err := dec.Decode(&item)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			fail(bodyErrorStatus(err, http.StatusBadRequest), "Error while parsing URL")
+			return
+		}
+
+		chunk = append(chunk, item)
+
+		if len(chunk) == streamChunkSize && !save() {
+			return
+		}
+	}
+
+	if len(chunk) > 0 || !started {
+		save()
+	}
+}
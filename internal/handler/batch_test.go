@@ -0,0 +1,243 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/audit"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/middleware/chain"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkStorage counts batches and fails them from the failFrom-th one if it's set
+type chunkStorage struct {
+	storage.Storage
+	batches  int
+	failFrom int
+}
+
+func (s *chunkStorage) BatchSaveURL(ctx context.Context, urls []storage.URL) error {
+	s.batches++
+	if s.failFrom > 0 && s.batches >= s.failFrom {
+		return errors.New("storage is down")
+	}
+
+	return s.Storage.BatchSaveURL(ctx, urls)
+}
+
+// postStream posts an NDJSON batch body to h served over HTTP/1, streamed batches need a real connection
+func postStream(t *testing.T, h http.Handler, body io.Reader) *http.Response {
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/api/shorten/batch", body)
+	require.NoError(t, err)
+	request.Header.Set("Content-Type", handler.NDJSONContentType)
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	t.Cleanup(func() { response.Body.Close() })
+
+	return response
+}
+
+// ndjson returns n batch lines for valid URLs followed by extra lines
+func ndjson(n int, extra ...string) string {
+	b := strings.Builder{}
+
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `{"correlation_id":"c%d","original_url":"https://example.com/%d"}`+"\n", i, i)
+	}
+
+	for _, line := range extra {
+		b.WriteString(line + "\n")
+	}
+
+	return b.String()
+}
+
+func Test_HandleShortenBatchStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		failFrom    int
+		statusCode  int
+		results     int
+		errors      int
+		batches     int
+		streamError bool
+	}{
+		{name: "chunks", body: ndjson(2500, `{"correlation_id":"bad","original_url":"not an url"}`), statusCode: http.StatusCreated, results: 2501, errors: 1, batches: 3},
+		{name: "unknown domain", body: ndjson(1, `{"correlation_id":"x","original_url":"https://x.example","domain":"nowhere.io"}`), statusCode: http.StatusCreated, results: 2, errors: 1, batches: 1},
+		{name: "empty", body: "", statusCode: http.StatusCreated},
+		{name: "malformed first chunk", body: ndjson(10, "{broken"), statusCode: http.StatusBadRequest},
+		{name: "malformed later chunk", body: ndjson(1500, "{broken"), statusCode: http.StatusCreated, results: 1000, batches: 1, streamError: true},
+		{name: "storage fails on the first chunk", body: ndjson(10), failFrom: 1, statusCode: http.StatusInternalServerError, batches: 1},
+		{name: "storage fails on a later chunk", body: ndjson(2500), failFrom: 2, statusCode: http.StatusCreated, results: 1000, batches: 2, streamError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := InitTestConfig()
+			st := &chunkStorage{Storage: storage.InitStorage(map[string]storage.URL{}, cfg), failFrom: tt.failFrom}
+
+			hn := handler.InitHandler(app.NewApp(st, cfg))
+
+			response := postStream(t, http.HandlerFunc(hn.HandleShortenBatchURL), strings.NewReader(tt.body))
+
+			assert.Equal(t, tt.statusCode, response.StatusCode)
+
+			if tt.statusCode != http.StatusCreated {
+				_, _ = io.Copy(io.Discard, response.Body)
+				assert.Equal(t, tt.batches, st.batches)
+
+				return
+			}
+
+			results, errs, streamError := 0, 0, false
+			dec := json.NewDecoder(response.Body)
+
+			for {
+				line := map[string]string{}
+				if err := dec.Decode(&line); err != nil {
+					assert.ErrorIs(t, err, io.EOF)
+					break
+				}
+
+				if _, ok := line["correlation_id"]; !ok {
+					streamError = true
+					continue
+				}
+
+				results++
+
+				if line["error"] != "" {
+					errs++
+				}
+			}
+
+			// the body ends once the handler returns
+			assert.Equal(t, tt.batches, st.batches)
+			assert.Equal(t, tt.results, results)
+			assert.Equal(t, tt.errors, errs)
+			assert.Equal(t, tt.streamError, streamError)
+		})
+	}
+}
+
+// appendSink counts calls appending audit events
+type appendSink struct {
+	audit.Sink
+	appends int
+}
+
+func (s *appendSink) Append(ctx context.Context, events ...audit.Event) error {
+	s.appends++
+	return s.Sink.Append(ctx, events...)
+}
+
+func Test_HandleShortenBatchStreamAudit(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	sink := &appendSink{Sink: audit.NewMemorySink()}
+
+	a := app.NewApp(storage.InitStorage(map[string]storage.URL{}, cfg), cfg)
+	a.Audit = audit.NewLog(sink, "")
+	hn := handler.InitHandler(a)
+
+	response := postStream(t, http.HandlerFunc(hn.HandleShortenBatchURL), strings.NewReader(ndjson(2500)))
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Equal(t, 3, sink.appends, "one audit append per chunk")
+
+	n, err := a.Audit.Verify(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2500, n)
+}
+
+func Test_HandleShortenBatchStreamIsIncremental(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	a := app.NewApp(storage.InitStorage(map[string]storage.URL{}, cfg), cfg)
+	hn := handler.InitHandler(a)
+
+	// the production MWs, a compressing writer among them, must let the body be read while results are sent
+	router := chi.NewRouter()
+	router.Use(chain.New(chain.Options{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Config: cfg,
+		Auth:   auth.New(cfg, a),
+	})...)
+	router.Post("/api/shorten/batch", hn.HandleShortenBatchURL)
+
+	body, input := io.Pipe()
+	go io.WriteString(input, ndjson(1000))
+
+	// a server that stopped reading the body would block the client forever
+	timeout := time.AfterFunc(10*time.Second, func() { input.CloseWithError(errors.New("the body isn't read")) })
+	defer timeout.Stop()
+
+	response := postStream(t, router, body)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+
+	// results of the first chunk arrive while the request body is still open
+	reader := bufio.NewReader(response.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, line, `"correlation_id":"c0"`)
+
+	// the rest of the body is still read after the first results were flushed
+	go func() {
+		for i := 1000; i < 3000; i++ {
+			fmt.Fprintf(input, `{"correlation_id":"c%d","original_url":"https://example.com/%d"}`+"\n", i, i)
+		}
+
+		input.Close()
+	}()
+
+	results := 1
+
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		require.NoError(t, err)
+		require.NotContains(t, line, `"error"`)
+
+		results++
+	}
+
+	assert.Equal(t, 3000, results)
+}
+
+func Test_HandleShortenBatchStreamWithoutFullDuplex(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	hn := handler.InitHandler(app.NewApp(storage.InitStorage(map[string]storage.URL{}, cfg), cfg))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(ndjson(10)))
+	request.Header.Set("Content-Type", handler.NDJSONContentType)
+
+	// a recorder can't read the body while the response is written
+	w := httptest.NewRecorder()
+	hn.HandleShortenBatchURL(w, request)
+
+	assert.Equal(t, http.StatusHTTPVersionNotSupported, w.Code)
+}
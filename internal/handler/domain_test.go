@@ -0,0 +1,131 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Domains(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	cfg.Domains = []string{"https://brand-a.io", "https://brand-b.io"}
+
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	hn := handler.InitHandler(a)
+
+	router := chi.NewRouter()
+	router.Get("/{urlHash}", hn.HandleGetURL)
+	router.Get("/api/user/urls", hn.HandleListURL)
+	router.Post("/api/shorten", hn.HandleShortenURL)
+	router.Post("/api/shorten/batch", hn.HandleShortenBatchURL)
+
+	do := func(method, host, path string, body interface{}) *httptest.ResponseRecorder {
+		buf := bytes.NewBuffer([]byte{})
+		if body != nil {
+			_ = json.NewEncoder(buf).Encode(body)
+		}
+
+		request := httptest.NewRequest(method, path, buf)
+		request.Host = host
+		request = request.WithContext(context.WithValue(request.Context(), auth.UIDKey{}, "user"))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+
+		return w
+	}
+
+	shortenTests := []struct {
+		name       string
+		host       string
+		body       handler.URL
+		wantStatus int
+		wantResult string
+	}{
+		{name: "request host domain", host: "brand-a.io", body: handler.URL{URL: "https://youtube.com"}, wantStatus: http.StatusCreated, wantResult: "https://brand-a.io/e62e2446"},
+		{name: "same code on another domain", host: "brand-b.io", body: handler.URL{URL: "https://youtube.com"}, wantStatus: http.StatusCreated, wantResult: "https://brand-b.io/e62e2446"},
+		{name: "domain from the body", host: "localhost:8080", body: handler.URL{URL: "https://vimeo.com", Domain: "brand-b.io"}, wantStatus: http.StatusCreated},
+		{name: "unknown host uses the base url", host: "10.0.0.1", body: handler.URL{URL: "https://google.com"}, wantStatus: http.StatusCreated},
+		{name: "unknown domain", host: "brand-a.io", body: handler.URL{URL: "https://youtube.com", Domain: "brand-c.io"}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range shortenTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(http.MethodPost, tt.host, "/api/shorten", tt.body)
+			assert.Equal(t, tt.wantStatus, w.Code)
+
+			if tt.wantResult != "" {
+				result := handler.ShortenResult{}
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+				assert.Equal(t, tt.wantResult, result.Result)
+			}
+		})
+	}
+
+	w := do(http.MethodPost, "brand-a.io", "/api/shorten/batch", []storage.BatchURL{
+		{CorrelationID: "promo", OriginalURL: "https://a.example"},
+		{CorrelationID: "promo", OriginalURL: "https://b.example", Domain: "https://brand-b.io"},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	batch := []storage.BatchURL{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&batch))
+	assert.Equal(t, "https://brand-a.io/promo", batch[0].ShortURL)
+	assert.Equal(t, "https://brand-b.io/promo", batch[1].ShortURL)
+
+	deleted, err := st.DeleteURLs(context.Background(), []storage.DeletionEntry{{UID: "user", Domain: "brand-a.io", Hash: "promo"}})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+
+	getTests := []struct {
+		name       string
+		host       string
+		hash       string
+		wantStatus int
+		wantLoc    string
+	}{
+		{name: "brand a", host: "brand-a.io", hash: "e62e2446", wantStatus: http.StatusTemporaryRedirect, wantLoc: "https://youtube.com"},
+		{name: "brand b", host: "Brand-B.io:443", hash: "e62e2446", wantStatus: http.StatusTemporaryRedirect, wantLoc: "https://youtube.com"},
+		{name: "deleted on brand a only", host: "brand-a.io", hash: "promo", wantStatus: http.StatusGone},
+		{name: "kept on brand b", host: "brand-b.io", hash: "promo", wantStatus: http.StatusTemporaryRedirect, wantLoc: "https://b.example"},
+		{name: "not on the base url domain", host: "localhost:8080", hash: "promo", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range getTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(http.MethodGet, tt.host, "/"+tt.hash, nil)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantLoc, w.Header().Get("Location"))
+		})
+	}
+
+	w = do(http.MethodGet, "localhost:8080", "/api/user/urls", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	urls := []storage.URL{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&urls))
+
+	shortURLs := []string{}
+	for _, u := range urls {
+		shortURLs = append(shortURLs, u.ShortURL)
+	}
+
+	assert.Len(t, shortURLs, 5)
+	assert.Subset(t, shortURLs, []string{"https://brand-a.io/e62e2446", "https://brand-b.io/e62e2446", "https://brand-b.io/promo"})
+	assert.NotContains(t, shortURLs, "https://brand-a.io/promo")
+}
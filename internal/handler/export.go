@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/T-V-N/gourlshortener/internal/export"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// HandleExportURL streams the links of the current user including deleted ones in the format query param:
+// csv, ndjson, json (the default) or html-bookmarks, see export.Link. Links are read from the storage
+// while they are written, a failure in the middle cuts the body short. Clicks and tags aren't recorded,
+// so exported links carry neither click totals nor tags.
+// HTTP response codes:
+//
+//	200 - the links are in the body
+//	400 - unknown format
+func (h *Handler) HandleExportURL(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatJSON
+	}
+
+	ew, err := export.NewWriter(w, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="urls.`+export.Extension(format)+`"`)
+
+	err = h.app.ForEachUserURL(r.Context(), uid, func(u storage.URL) error {
+		return ew.Write(h.exportLink(u))
+	})
+	if err == nil {
+		err = ew.Close()
+	}
+
+	if err != nil {
+		logger.FromContext(r.Context()).Error("exporting urls", "error", err)
+	}
+}
+
+// exportLink converts an URL as stored to an exported one
+func (h *Handler) exportLink(u storage.URL) export.Link {
+	l := export.Link{
+		ShortURL:    h.app.ShortURL(u.Domain, u.ShortURL),
+		Code:        u.ShortURL,
+		OriginalURL: u.URL,
+		Domain:      u.Domain,
+		WorkspaceID: u.WorkspaceID,
+		Status:      export.StatusActive,
+	}
+
+	if u.IsDeleted {
+		l.Status = export.StatusDeleted
+	}
+
+	if !u.CreatedAt.IsZero() {
+		l.CreatedAt = &u.CreatedAt
+	}
+
+	return l
+}
@@ -0,0 +1,94 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/export"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandleExportURL(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	hn := handler.InitHandler(a)
+
+	ctx := context.Background()
+
+	for _, u := range []string{"https://export.example/1", "https://export.example/2"} {
+		_, err := a.SaveURL(ctx, u, "owner", "")
+		require.NoError(t, err)
+	}
+
+	_, err := a.SaveURL(ctx, "https://export.example/other", "stranger", "")
+	require.NoError(t, err)
+
+	deleted, err := st.DeleteURLs(ctx, []storage.DeletionEntry{{UID: "owner", Hash: app.Hash("https://export.example/2")}})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+
+	tests := []struct {
+		query       string
+		statusCode  int
+		contentType string
+		lines       int
+	}{
+		{"", http.StatusOK, "application/json", 4},
+		{"?format=ndjson", http.StatusOK, "application/x-ndjson", 2},
+		{"?format=csv", http.StatusOK, "text/csv; charset=utf-8", 3},
+		{"?format=html-bookmarks", http.StatusOK, "text/html; charset=utf-8", 7},
+		{"?format=xml", http.StatusBadRequest, "text/plain; charset=utf-8", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls/export"+tt.query, nil)
+			request = request.WithContext(context.WithValue(request.Context(), auth.UIDKey{}, "owner"))
+
+			w := httptest.NewRecorder()
+			hn.HandleExportURL(w, request)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), tt.lines)
+			assert.NotContains(t, w.Body.String(), "other")
+		})
+	}
+
+	t.Run("links", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/api/user/urls/export?format=json", nil)
+		request = request.WithContext(context.WithValue(request.Context(), auth.UIDKey{}, "owner"))
+
+		w := httptest.NewRecorder()
+		hn.HandleExportURL(w, request)
+
+		links := []export.Link{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &links))
+		require.Len(t, links, 2)
+
+		statuses := map[string]string{}
+
+		for _, l := range links {
+			statuses[l.OriginalURL] = l.Status
+			assert.Equal(t, "http://localhost:8080/"+l.Code, l.ShortURL)
+			assert.NotNil(t, l.CreatedAt)
+		}
+
+		assert.Equal(t, map[string]string{
+			"https://export.example/1": export.StatusActive,
+			"https://export.example/2": export.StatusDeleted,
+		}, statuses)
+	})
+}
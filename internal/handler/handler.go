@@ -7,12 +7,15 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/imports"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/metrics"
 	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/pages"
 	"github.com/T-V-N/gourlshortener/internal/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgerrcode"
@@ -21,12 +24,15 @@ import (
 
 // Handler processes request using the App layer actions
 type Handler struct {
-	app *app.App
+	app     *app.App
+	Pages   *pages.Pages     // pages shown to people following short links, the embedded ones by default
+	Imports *imports.Manager // runs bulk imports, imports are disabled if nil
 }
 
 // URL is used during JSON (un)marshalling ops related to urls
 type URL struct {
-	URL string `json:"url"`
+	URL    string `json:"url"`
+	Domain string `json:"domain,omitempty"` // configured domain or its base url to shorten on, the request host domain if empty
 }
 
 // ShortenResult is used during for some handlers while marshalling and unmarshalling
@@ -34,19 +40,32 @@ type ShortenResult struct {
 	Result string `json:"result"`
 }
 
+// bodyErrorStatus returns 413 if reading the request body failed because of the body size limits
+// and fallback otherwise
+func bodyErrorStatus(err error, fallback int) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return fallback
+}
+
 // InitHandler creates handlers for an app
 func InitHandler(a *app.App) *Handler {
-	return &Handler{a}
+	return &Handler{app: a, Pages: pages.Default()}
 }
 
 // HandleGetURL uses gets urlHash from URLParam (if any) and redirects a user to the
-// bound URL.
+// bound URL. The URL is looked up on the domain of the request host. Unknown and deleted
+// URLs are answered with pages negotiated by Accept, see pages.Render.
 // HTTP response codes:
 //
 //	307 - if URL exists (user being redirected)
 //	400 - no urlHash query param passed or
-//	500 - something wrong on the app layer
+//	404 - there is no such URL
 //	410 - the bound URL was deleted
+//	500 - something wrong on the app layer
 func (h *Handler) HandleGetURL(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
@@ -58,7 +77,11 @@ func (h *Handler) HandleGetURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url, err := h.app.GetURL(ctx, id)
+	url, err := h.app.GetURL(ctx, h.app.DomainForHost(r.Host), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.Pages.Render(w, r, pages.NotFound, pages.Data{})
+		return
+	}
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -66,19 +89,21 @@ func (h *Handler) HandleGetURL(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if url.IsDeleted {
-		http.Error(w, "Gone", http.StatusGone)
+		h.Pages.Render(w, r, pages.Gone, pages.Data{})
 		return
 	}
 
+	metrics.LinksRedirected.Inc()
+
 	w.Header().Add("Location", url.URL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
-// HandlePostURL gets an URL from the body and saves it.
+// HandlePostURL gets an URL from the body and saves it on the domain query param or the request host domain.
 // HTTP response codes:
 //
 //	201 - an URL was created
-//	400 - request contains wrong URL (unparsable, not an URL etc)
+//	400 - request contains wrong URL (unparsable, not an URL etc) or an unknown domain
 //	500 - something wrong on the app layer
 func (h *Handler) HandlePostURL(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
@@ -88,17 +113,24 @@ func (h *Handler) HandlePostURL(w http.ResponseWriter, r *http.Request) {
 
 	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
 
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+
+	domain, err := h.app.ResolveDomain(r.URL.Query().Get("domain"), r.Host)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	hash, err := h.app.SaveURL(ctx, string(body), uid)
+	hash, err := h.app.SaveURL(ctx, string(body), uid, domain)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			metrics.LinksConflicts.Inc()
 			w.WriteHeader(http.StatusConflict)
-			_, err = w.Write([]byte(h.app.Config.BaseURL + "/" + hash))
+			_, err = w.Write([]byte(h.app.ShortURL(domain, hash)))
 
 			if err != nil {
 				http.Error(w, "Unknown error", http.StatusInternalServerError)
@@ -117,15 +149,15 @@ func (h *Handler) HandlePostURL(w http.ResponseWriter, r *http.Request) {
 
 	_, err = w.Write([]byte(hash))
 	if err != nil {
-		log.Println(err.Error())
+		logger.FromContext(ctx).Error("writing response", "error", err)
 	}
 }
 
-// HandleShortenURL basically doest the same as HandlePostURL but responds with JSON
+// HandleShortenURL basically doest the same as HandlePostURL but responds with JSON, the domain is taken from the body
 // HTTP response codes:
 //
 //	201 - an URL was created
-//	400 - request contains wrong URL (unparsable, not an URL etc)
+//	400 - request contains wrong URL (unparsable, not an URL etc) or an unknown domain
 //	500 - something wrong on the app layer / handler got problems with marshalling the data
 func (h *Handler) HandleShortenURL(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
@@ -133,20 +165,27 @@ func (h *Handler) HandleShortenURL(w http.ResponseWriter, r *http.Request) {
 
 	obj := URL{}
 	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
-		http.Error(w, "Error while parsing URL", http.StatusBadRequest)
+		http.Error(w, "Error while parsing URL", bodyErrorStatus(err, http.StatusBadRequest))
 		return
 	}
 
 	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
 
-	hash, err := h.app.SaveURL(ctx, obj.URL, uid)
+	domain, err := h.app.ResolveDomain(obj.Domain, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := h.app.SaveURL(ctx, obj.URL, uid, domain)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			metrics.LinksConflicts.Inc()
 			w.Header().Set("content-type", "application/json")
 			w.WriteHeader(http.StatusConflict)
 
-			shortenedURL := ShortenResult{Result: h.app.Config.BaseURL + "/" + hash}
+			shortenedURL := ShortenResult{Result: h.app.ShortURL(domain, hash)}
 
 			err = json.NewEncoder(w).Encode(shortenedURL)
 			if err != nil {
@@ -171,26 +210,37 @@ func (h *Handler) HandleShortenURL(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// HandleShortenBatchURL saves a list of URLs
+// HandleShortenBatchURL saves a list of URLs on their domains or the request host domain.
+// NDJSON bodies (application/x-ndjson) are streamed, see handleShortenBatchStream.
 // HTTP response codes:
 //
 //	201 - an URL was created
-//	400 - request contains wrong URL (unparsable, not an URL etc)
+//	400 - request contains wrong URL (unparsable, not an URL etc) or an unknown domain
 //	500 - something wrong on the app layer / handler got problems with marshalling the data
 func (h *Handler) HandleShortenBatchURL(w http.ResponseWriter, r *http.Request) {
+	if mediaType(r) == NDJSONContentType {
+		h.handleShortenBatchStream(w, r)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
 	obj := []storage.BatchURL{}
 
 	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
-		http.Error(w, "Error while parsing URL", http.StatusBadRequest)
+		http.Error(w, "Error while parsing URL", bodyErrorStatus(err, http.StatusBadRequest))
 		return
 	}
 
 	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
 
-	urls, err := h.app.BatchSaveURL(ctx, obj, uid)
+	urls, err := h.app.BatchSaveURL(ctx, obj, uid, h.app.DomainForHost(r.Host))
+	if errors.Is(err, app.ErrUnknownDomain) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err != nil {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
@@ -206,38 +256,6 @@ func (h *Handler) HandleShortenBatchURL(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-// HandleListURL returns the list of URLs belonging to a certain user (presumably an authorized one)
-// HTTP response codes:
-//
-//	200 - OK, urls are in the body
-//	400 - request contains wrong URL (unparsable, not an URL etc)
-//	500 - something wrong on the app layer / handler got problems with marshalling the data
-//	204 - a user has no URLs saved
-func (h *Handler) HandleListURL(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
-
-	uid := r.Context().Value(auth.UIDKey{})
-
-	url, err := h.app.GetURLByUID(uid.(string), ctx)
-	if err != nil {
-		http.Error(w, "Error getting URLs ;(", http.StatusBadRequest)
-		return
-	}
-
-	if len(url) == 0 {
-		http.Error(w, "No content", http.StatusNoContent)
-		return
-	}
-
-	w.Header().Set("content-type", "application/json")
-
-	if err != json.NewEncoder(w).Encode(url) {
-		http.Error(w, "Something went wrong", http.StatusBadRequest)
-		return
-	}
-}
-
 // HandlePing is just here to check whether the server storage is alive
 // HTTP response codes:
 //
@@ -257,7 +275,7 @@ func (h *Handler) HandlePing(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// HandleDeleteListURL stages a list of URLs for deletion
+// HandleDeleteListURL stages a list of URLs for deletion. Items are short URLs or hashes of the request host domain.
 // HTTP response codes:
 //
 //	202 - Accepted. The URLs from the list will be deleted (sometime)
@@ -272,7 +290,7 @@ func (h *Handler) HandleDeleteListURL(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&rawHashes)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), bodyErrorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -281,7 +299,7 @@ func (h *Handler) HandleDeleteListURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.app.DeleteListURL(ctx, rawHashes, uid.(string))
+	err = h.app.DeleteListURL(ctx, rawHashes, uid.(string), h.app.DomainForHost(r.Host))
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
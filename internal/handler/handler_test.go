@@ -122,6 +122,14 @@ func Test_HandlerGetURL(t *testing.T) {
 				location:   "",
 			},
 		},
+		{
+			name:  "unknown link",
+			param: "00000000",
+			want: want{
+				statusCode: http.StatusNotFound,
+				location:   "",
+			},
+		},
 	}
 
 	cfg, _ := InitTestConfig()
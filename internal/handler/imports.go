@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/T-V-N/gourlshortener/internal/imports"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/go-chi/chi/v5"
+)
+
+// importFormat returns the upload format of r: the format query param or the one of its content type
+func importFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+
+	switch mediaType(r) {
+	case CSVContentType:
+		return imports.FormatCSV
+	case NDJSONContentType:
+		return imports.FormatNDJSON
+	}
+
+	return ""
+}
+
+// HandleCreateImport stores a CSV or NDJSON upload and queues its import, the format is taken from the format
+// query param or the content type. NDJSON lines are batch items, see storage.BatchURL; CSV needs a header
+// with an original_url column and may have correlation_id and domain ones. Items without a correlation id
+// get the short URL POST / would give them. URLs are bound to the domain query param or the request host domain
+// unless an item names another one.
+// HTTP response codes:
+//
+//	202 - the job was queued, it is in the body and its URL in Location
+//	400 - unknown format or domain
+//	413 - the upload is larger than allowed
+//	503 - imports are disabled
+//	500 - the upload can't be stored
+func (h *Handler) HandleCreateImport(w http.ResponseWriter, r *http.Request) {
+	if h.Imports == nil {
+		http.Error(w, "Imports are disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	domain, err := h.app.ResolveDomain(r.URL.Query().Get("domain"), r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.Imports.Create(uid, domain, importFormat(r), r.Body)
+	if errors.Is(err, imports.ErrUnknownFormat) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		logger.FromContext(r.Context()).Error("storing import upload", "error", err)
+		http.Error(w, "Can't store the upload", bodyErrorStatus(err, http.StatusInternalServerError))
+
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("Location", "/api/imports/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+
+	if err = json.NewEncoder(w).Encode(job); err != nil {
+		logger.FromContext(r.Context()).Error("writing response", "error", err)
+	}
+}
+
+// HandleGetImport returns an import job of the current user with its progress and counts
+// HTTP response codes:
+//
+//	200 - the job is in the body
+//	404 - there is no such job
+//	503 - imports are disabled
+func (h *Handler) HandleGetImport(w http.ResponseWriter, r *http.Request) {
+	if h.Imports == nil {
+		http.Error(w, "Imports are disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	job, err := h.Imports.Get(uid, chi.URLParam(r, "importID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(job); err != nil {
+		logger.FromContext(r.Context()).Error("writing response", "error", err)
+	}
+}
+
+// HandleGetImportResult downloads the result of an import job of the current user as NDJSON, a line per
+// upload item in upload order, see imports.Result. A running job returns the results of its finished chunks.
+// HTTP response codes:
+//
+//	200 - the result is in the body
+//	404 - there is no such job
+//	503 - imports are disabled
+//	500 - the result can't be read
+func (h *Handler) HandleGetImportResult(w http.ResponseWriter, r *http.Request) {
+	if h.Imports == nil {
+		http.Error(w, "Imports are disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	result, job, err := h.Imports.OpenResult(uid, chi.URLParam(r, "importID"))
+	if errors.Is(err, imports.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, "Can't read the result", http.StatusInternalServerError)
+		return
+	}
+
+	defer result.Close()
+
+	w.Header().Set("content-type", NDJSONContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="import-`+job.ID+`.ndjson"`)
+	http.ServeContent(w, r, "", job.UpdatedAt, result)
+}
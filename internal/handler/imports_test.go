@@ -0,0 +1,141 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/imports"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandleImports(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	hn := handler.InitHandler(a)
+
+	router := chi.NewRouter()
+	router.Post("/api/imports", hn.HandleCreateImport)
+	router.Get("/api/imports/{importID}", hn.HandleGetImport)
+	router.Get("/api/imports/{importID}/result", hn.HandleGetImportResult)
+
+	do := func(method, path, uid, contentType, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		request = request.WithContext(context.WithValue(request.Context(), auth.UIDKey{}, uid))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+
+		return w
+	}
+
+	t.Run("imports are disabled", func(t *testing.T) {
+		w := do(http.MethodPost, "/api/imports", "user", "text/csv", "original_url\nhttps://example.com\n")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	var err error
+	hn.Imports, err = imports.New(t.TempDir(), a)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hn.Imports.Run(ctx)
+
+	t.Run("unknown format", func(t *testing.T) {
+		w := do(http.MethodPost, "/api/imports", "user", "application/xml", "<urls/>")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unknown domain", func(t *testing.T) {
+		w := do(http.MethodPost, "/api/imports?domain=unknown.example", "user", "text/csv", "original_url\n")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unknown job", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/imports/missing", "user", "", "").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/imports/missing/result", "user", "", "").Code)
+	})
+
+	for _, tc := range []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+	}{
+		{"csv", "/api/imports", "text/csv; charset=utf-8", "original_url,correlation_id\nhttps://csv.example,csv\nnot an url,\n"},
+		{"ndjson by query", "/api/imports?format=ndjson", "text/plain", `{"correlation_id":"nd","original_url":"https://ndjson.example"}` + "\n{\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := do(http.MethodPost, tc.path, "user", tc.contentType, tc.body)
+			require.Equal(t, http.StatusAccepted, w.Code)
+
+			job := imports.Job{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+			assert.Equal(t, "/api/imports/"+job.ID, w.Header().Get("Location"))
+
+			assert.Eventually(t, func() bool {
+				w := do(http.MethodGet, "/api/imports/"+job.ID, "user", "", "")
+				_ = json.NewDecoder(w.Body).Decode(&job)
+
+				return job.Status == imports.StatusDone
+			}, 5*time.Second, 5*time.Millisecond)
+
+			assert.Equal(t, 1, job.Imported)
+			assert.Equal(t, 1, job.Failed)
+
+			assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/imports/"+job.ID, "stranger", "", "").Code)
+
+			w = do(http.MethodGet, "/api/imports/"+job.ID+"/result", "user", "", "")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, handler.NDJSONContentType, w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Header().Get("Content-Disposition"), job.ID)
+
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			require.Len(t, lines, 2)
+
+			first := imports.Result{}
+			require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+			assert.Equal(t, 1, first.Item)
+			assert.NotEmpty(t, first.ShortURL)
+		})
+	}
+}
+
+func Test_IsStream(t *testing.T) {
+	tests := []struct {
+		method      string
+		path        string
+		contentType string
+		want        bool
+	}{
+		{http.MethodPost, "/api/shorten/batch", "application/x-ndjson", true},
+		{http.MethodPost, "/api/shorten/batch", "application/json", false},
+		{http.MethodPost, "/api/imports", "text/csv", true},
+		{http.MethodGet, "/api/imports", "text/csv", false},
+		{http.MethodPost, "/api/user/urls/migrate", "text/plain", true},
+		{http.MethodPost, "/", "application/x-ndjson", false},
+	}
+
+	for _, tt := range tests {
+		request := httptest.NewRequest(tt.method, tt.path, nil)
+		request.Header.Set("Content-Type", tt.contentType)
+
+		assert.Equal(t, tt.want, handler.IsStream(request), tt.method+" "+tt.path+" "+tt.contentType)
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// maxListLimit is the largest page HandleListURL returns
+const maxListLimit = 1000
+
+// listDateLayout is accepted by the date range params besides RFC 3339
+const listDateLayout = "2006-01-02"
+
+// HandleListURL returns the URLs belonging to a certain user (presumably an authorized one).
+// Without the limit param every matching URL is returned, otherwise a page of up to limit URLs and a
+// Link header with rel="next" pointing at the next page, whose cursor param has to be passed along
+// with the same filters. Query params:
+//
+//	limit        - page size, 1 to 1000
+//	cursor       - position returned in the Link header of the previous page
+//	sort         - code (the default) or created, prefixed with "-" for a descending order
+//	domain       - configured domain or its base url the URLs are served on, any if absent
+//	q            - case-insensitive substring of the original URL or the code
+//	status       - active (the default), deleted or all
+//	created_from - RFC 3339 time or a date the URLs were created at or after
+//	created_to   - RFC 3339 time the URLs were created before, or the last date they were created on
+//
+// Clicks and tags aren't recorded, so sorting by clicks and the tag param are rejected.
+// HTTP response codes:
+//
+//	200 - OK, urls are in the body
+//	400 - a query param is malformed, or the storage failed
+//	204 - a user has no URLs matching the params
+func (h *Handler) HandleListURL(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	q, err := h.listQuery(r, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.app.ListURLs(ctx, q)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("listing urls", "error", err)
+		http.Error(w, "Error getting URLs ;(", http.StatusBadRequest)
+
+		return
+	}
+
+	if len(page.URLs) == 0 {
+		http.Error(w, "No content", http.StatusNoContent)
+		return
+	}
+
+	if page.Next != "" {
+		next := *r.URL
+		params := next.Query()
+		params.Set("cursor", page.Next)
+		next.RawQuery = params.Encode()
+
+		w.Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(page.URLs); err != nil {
+		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+}
+
+// listQuery parses the query params of HandleListURL
+func (h *Handler) listQuery(r *http.Request, uid string) (storage.URLQuery, error) {
+	params := r.URL.Query()
+	q := storage.URLQuery{UID: uid, Search: params.Get("q"), Status: storage.StatusActive}
+
+	if params.Has("tag") {
+		return q, errors.New("tags aren't supported")
+	}
+
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+
+		q.Limit = limit
+	}
+
+	if raw := params.Get("cursor"); raw != "" {
+		after, err := app.DecodeCursor(raw)
+		if err != nil {
+			return q, err
+		}
+
+		q.After = &after
+	}
+
+	sort := params.Get("sort")
+	q.Desc = strings.HasPrefix(sort, "-")
+
+	switch strings.TrimPrefix(sort, "-") {
+	case "", storage.SortCode:
+		q.Sort = storage.SortCode
+	case storage.SortCreated:
+		q.Sort = storage.SortCreated
+	case "clicks":
+		return q, errors.New("clicks aren't recorded, sort by code or created")
+	default:
+		return q, errors.New("sort must be code or created")
+	}
+
+	if params.Has("domain") {
+		domain, err := h.app.ResolveDomain(params.Get("domain"), r.Host)
+		if err != nil {
+			return q, err
+		}
+
+		q.Domain = &domain
+	}
+
+	switch status := params.Get("status"); status {
+	case "":
+	case storage.StatusActive, storage.StatusDeleted, storage.StatusAll:
+		q.Status = status
+	default:
+		return q, errors.New("status must be active, deleted or all")
+	}
+
+	var err error
+
+	if q.CreatedFrom, err = parseListTime(params.Get("created_from"), false); err != nil {
+		return q, errors.New("created_from must be an RFC 3339 time or a date")
+	}
+
+	if q.CreatedTo, err = parseListTime(params.Get("created_to"), true); err != nil {
+		return q, errors.New("created_to must be an RFC 3339 time or a date")
+	}
+
+	return q, nil
+}
+
+// parseListTime parses an RFC 3339 time or a date, zero if raw is empty.
+// A date is the start of its day, or the start of the next day if it is an inclusive upper bound.
+func parseListTime(raw string, upper bool) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(listDateLayout, raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
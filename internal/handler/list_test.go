@@ -0,0 +1,113 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandleListURL(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	hn := handler.InitHandler(a)
+
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := a.SaveURL(ctx, "https://list.example/"+strconv.Itoa(i), "owner", "")
+		require.NoError(t, err)
+	}
+
+	_, err := a.SaveURL(ctx, "https://other.example", "owner", "")
+	require.NoError(t, err)
+
+	do := func(target string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request = request.WithContext(context.WithValue(request.Context(), auth.UIDKey{}, "owner"))
+
+		w := httptest.NewRecorder()
+		hn.HandleListURL(w, request)
+
+		return w
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		seen := []string{}
+		target := "/api/user/urls?limit=2&q=list.example&sort=-created"
+
+		for pages := 0; target != ""; pages++ {
+			require.Less(t, pages, 3)
+
+			w := do(target)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			urls := []storage.URL{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&urls))
+
+			for _, u := range urls {
+				seen = append(seen, u.URL)
+				assert.True(t, strings.HasPrefix(u.ShortURL, cfg.BaseURL+"/"))
+			}
+
+			target = ""
+
+			if link := w.Header().Get("Link"); link != "" {
+				assert.True(t, strings.HasSuffix(link, `>; rel="next"`))
+				target = link[1:strings.Index(link, ">")]
+				assert.Contains(t, target, "q=list.example")
+			}
+		}
+
+		assert.Equal(t, []string{
+			"https://list.example/4", "https://list.example/3", "https://list.example/2", "https://list.example/1", "https://list.example/0",
+		}, seen)
+	})
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantCount  int
+	}{
+		{"everything without a limit", "/api/user/urls", http.StatusOK, 6},
+		{"no matches", "/api/user/urls?q=nothing", http.StatusNoContent, 0},
+		{"date range", "/api/user/urls?created_from=2000-01-01&created_to=2999-12-31", http.StatusOK, 6},
+		{"deleted", "/api/user/urls?status=deleted", http.StatusNoContent, 0},
+		{"default domain", "/api/user/urls?domain=" + cfg.BaseURL, http.StatusOK, 6},
+		{"limit too large", "/api/user/urls?limit=1001", http.StatusBadRequest, 0},
+		{"bad cursor", "/api/user/urls?cursor=nope", http.StatusBadRequest, 0},
+		{"clicks aren't recorded", "/api/user/urls?sort=-clicks", http.StatusBadRequest, 0},
+		{"tags aren't supported", "/api/user/urls?tag=promo", http.StatusBadRequest, 0},
+		{"unknown status", "/api/user/urls?status=archived", http.StatusBadRequest, 0},
+		{"unknown domain", "/api/user/urls?domain=brand-c.io", http.StatusBadRequest, 0},
+		{"bad date", "/api/user/urls?created_from=yesterday", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.target)
+			assert.Equal(t, tt.wantStatus, w.Code)
+
+			if tt.wantStatus == http.StatusOK {
+				urls := []storage.URL{}
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&urls))
+				assert.Len(t, urls, tt.wantCount)
+				assert.Empty(t, w.Header().Get("Link"))
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/migrate"
+)
+
+// HandleMigrateURL imports links of the current user exported by another shortener, the format query param
+// is bitly, yourls-sql or yourls-csv. Links keep their original codes on the domain query param or the request
+// host domain where the codes are free; the result reports the links that didn't, see migrate.Import.
+// The export is read and saved while it is received.
+// HTTP response codes:
+//
+//	200 - the export was imported, the result is in the body
+//	400 - unknown format or domain, or the export can't be read
+//	413 - the export is larger than allowed
+//	500 - the links can't be saved, those saved before the failure are kept
+func (h *Handler) HandleMigrateURL(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	domain, err := h.app.ResolveDomain(r.URL.Query().Get("domain"), r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := migrate.Import(r.Context(), h.app.DB, r.Body, migrate.Options{
+		Format: r.URL.Query().Get("format"),
+		UID:    uid,
+		Domain: domain,
+		Saved:  h.app.RecordCreated,
+	})
+
+	switch {
+	case errors.Is(err, migrate.ErrUnknownFormat) || errors.Is(err, migrate.ErrMalformed):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Error("migrating urls", "error", err)
+		http.Error(w, "Can't import the export", bodyErrorStatus(err, http.StatusInternalServerError))
+
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(res); err != nil {
+		logger.FromContext(r.Context()).Error("writing response", "error", err)
+	}
+}
@@ -0,0 +1,70 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/migrate"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandleMigrateURL(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	hn := handler.InitHandler(a)
+
+	export := "Bitlink,Long URL,Title,Created\nbit.ly/mig1,https://migrate.example/1,One,2023-01-02\nbit.ly/mig1,https://migrate.example/2,Two,2023-01-03\n"
+
+	tests := []struct {
+		name       string
+		query      string
+		body       string
+		statusCode int
+		result     migrate.Result
+	}{
+		{"bitly", "?format=bitly", export, http.StatusOK, migrate.Result{Kept: 1, Renamed: 1}},
+		{"again", "?format=bitly", export, http.StatusOK, migrate.Result{Existing: 2}},
+		{"unknown format", "?format=rebrandly", export, http.StatusBadRequest, migrate.Result{}},
+		{"unknown domain", "?format=bitly&domain=unknown.example", export, http.StatusBadRequest, migrate.Result{}},
+		{"malformed dump", "?format=yourls-sql", "INSERT INTO yourls_url VALUES ('a", http.StatusBadRequest, migrate.Result{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/user/urls/migrate"+tt.query, strings.NewReader(tt.body))
+			request = request.WithContext(context.WithValue(request.Context(), auth.UIDKey{}, "user"))
+
+			w := httptest.NewRecorder()
+			hn.HandleMigrateURL(w, request)
+
+			require.Equal(t, tt.statusCode, w.Code)
+
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+
+			res := migrate.Result{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+			res.Reports = nil
+			assert.Equal(t, tt.result, res)
+		})
+	}
+
+	u, err := st.GetURL(context.Background(), "", "mig1")
+	require.NoError(t, err)
+	assert.Equal(t, "user", u.UID)
+	assert.Equal(t, "https://migrate.example/1", u.URL)
+}
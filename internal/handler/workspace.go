@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/metrics"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// WorkspaceRequest is used to create a workspace
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+// MemberRequest adds a member to a workspace or changes the member role. The member is
+// identified either by uid or by the login of a registered account.
+type MemberRequest struct {
+	UID   string `json:"uid,omitempty"`
+	Login string `json:"login,omitempty"`
+	Role  string `json:"role"`
+}
+
+// workspaceErrorStatus maps app layer workspace errors to HTTP status codes
+func workspaceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, app.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, app.ErrInvalidRole):
+		return http.StatusBadRequest
+	case errors.Is(err, app.ErrLastOwner):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// HandleCreateWorkspace creates a workspace owned by the current user
+// HTTP response codes:
+//
+//	201 - the workspace was created, it is in the body
+//	400 - request body is malformed or the name is empty
+//	500 - something wrong on the app layer
+func (h *Handler) HandleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	req := WorkspaceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "Error while parsing workspace", http.StatusBadRequest)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	ws, err := h.app.CreateWorkspace(ctx, uid, req.Name)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err = json.NewEncoder(w).Encode(ws); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleListWorkspaces returns workspaces of the current user with the user role in each of them
+// HTTP response codes:
+//
+//	200 - OK, workspaces are in the body
+//	204 - the user is not a member of any workspace
+//	500 - something wrong on the app layer
+func (h *Handler) HandleListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	workspaces, err := h.app.GetWorkspaces(ctx, uid)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if len(workspaces) == 0 {
+		http.Error(w, "No content", http.StatusNoContent)
+		return
+	}
+
+	h.writeJSON(w, workspaces)
+}
+
+// HandleListMembers returns members of a workspace, any member may list them
+// HTTP response codes:
+//
+//	200 - OK, members are in the body
+//	403 - the user is not a member of the workspace
+//	500 - something wrong on the app layer
+func (h *Handler) HandleListMembers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	members, err := h.app.GetMembers(ctx, uid, chi.URLParam(r, "workspaceID"))
+	if err != nil {
+		http.Error(w, err.Error(), workspaceErrorStatus(err))
+		return
+	}
+
+	h.writeJSON(w, members)
+}
+
+// HandleSetMember adds a member to a workspace or changes the member role, only owners may do it
+// HTTP response codes:
+//
+//	200 - OK, the membership was saved
+//	400 - request body is malformed or the role is unknown
+//	403 - the user is not an owner of the workspace
+//	404 - no account with the login
+//	409 - the change would leave the workspace without owners
+//	500 - something wrong on the app layer
+func (h *Handler) HandleSetMember(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	req := MemberRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.UID == "" && req.Login == "") {
+		http.Error(w, "Error while parsing member", http.StatusBadRequest)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	memberUID := req.UID
+	if req.Login != "" {
+		var err error
+
+		memberUID, err = h.app.LookupUID(ctx, req.Login)
+		if err != nil {
+			http.Error(w, err.Error(), workspaceErrorStatus(err))
+			return
+		}
+	}
+
+	m := storage.Member{WorkspaceID: chi.URLParam(r, "workspaceID"), UID: memberUID, Role: req.Role}
+
+	if err := h.app.SetMember(ctx, uid, m); err != nil {
+		http.Error(w, err.Error(), workspaceErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleRemoveMember removes a member from a workspace. Owners may remove anyone, other members only themselves.
+// HTTP response codes:
+//
+//	200 - OK, the member was removed
+//	403 - the user may not remove the member
+//	404 - the member is not in the workspace
+//	409 - the member is the last owner
+//	500 - something wrong on the app layer
+func (h *Handler) HandleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	err := h.app.RemoveMember(ctx, uid, chi.URLParam(r, "workspaceID"), chi.URLParam(r, "memberUID"))
+	if err != nil {
+		http.Error(w, err.Error(), workspaceErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleWorkspaceShortenURL shortens an URL into a workspace, editors and owners may do it
+// HTTP response codes:
+//
+//	201 - an URL was created
+//	400 - request contains wrong URL (unparsable, not an URL etc) or an unknown domain
+//	403 - the user is not an editor of the workspace
+//	409 - the URL is already shortened
+//	500 - something wrong on the app layer
+func (h *Handler) HandleWorkspaceShortenURL(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	obj := URL{}
+	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+		http.Error(w, "Error while parsing URL", bodyErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	domain, err := h.app.ResolveDomain(obj.Domain, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := h.app.SaveWorkspaceURL(ctx, uid, chi.URLParam(r, "workspaceID"), obj.URL, domain)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation:
+			metrics.LinksConflicts.Inc()
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+
+			_ = json.NewEncoder(w).Encode(ShortenResult{Result: h.app.ShortURL(domain, hash)})
+		case errors.Is(err, app.ErrForbidden):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Wrong URL passed", http.StatusBadRequest)
+		}
+
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err = json.NewEncoder(w).Encode(ShortenResult{Result: hash}); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleListWorkspaceURL returns URLs of a workspace, any member may list them
+// HTTP response codes:
+//
+//	200 - OK, urls are in the body
+//	204 - the workspace has no URLs
+//	403 - the user is not a member of the workspace
+//	500 - something wrong on the app layer
+func (h *Handler) HandleListWorkspaceURL(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	urls, err := h.app.GetWorkspaceURLs(ctx, uid, chi.URLParam(r, "workspaceID"))
+	if err != nil {
+		http.Error(w, err.Error(), workspaceErrorStatus(err))
+		return
+	}
+
+	if len(urls) == 0 {
+		http.Error(w, "No content", http.StatusNoContent)
+		return
+	}
+
+	h.writeJSON(w, urls)
+}
+
+// HandleDeleteWorkspaceURL stages a list of workspace URLs for deletion, editors and owners may do it
+// HTTP response codes:
+//
+//	202 - Accepted. The URLs from the list will be deleted (sometime)
+//	400 - no hashes passed
+//	403 - the user is not an editor of the workspace
+//	500 - something wrong on the app layer
+func (h *Handler) HandleDeleteWorkspaceURL(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	rawHashes := []string{}
+	if err := json.NewDecoder(r.Body).Decode(&rawHashes); err != nil {
+		http.Error(w, err.Error(), bodyErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+
+	if len(rawHashes) == 0 {
+		http.Error(w, "No hashes to delete", http.StatusBadRequest)
+		return
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+
+	if err := h.app.DeleteWorkspaceURLs(ctx, uid, chi.URLParam(r, "workspaceID"), rawHashes, h.app.DomainForHost(r.Host)); err != nil {
+		http.Error(w, err.Error(), workspaceErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// writeJSON writes obj as a JSON response with 200 status code
+func (h *Handler) writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("content-type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+}
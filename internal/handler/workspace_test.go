@@ -0,0 +1,104 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HandleWorkspaces(t *testing.T) {
+	cfg, _ := InitTestConfig()
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	hn := handler.InitHandler(a)
+
+	router := chi.NewRouter()
+	router.Post("/api/workspaces", hn.HandleCreateWorkspace)
+	router.Put("/api/workspaces/{workspaceID}/members", hn.HandleSetMember)
+	router.Delete("/api/workspaces/{workspaceID}/members/{memberUID}", hn.HandleRemoveMember)
+	router.Get("/api/workspaces/{workspaceID}/urls", hn.HandleListWorkspaceURL)
+	router.Post("/api/workspaces/{workspaceID}/urls", hn.HandleWorkspaceShortenURL)
+	router.Delete("/api/workspaces/{workspaceID}/urls", hn.HandleDeleteWorkspaceURL)
+
+	do := func(method, path, uid string, body interface{}) *httptest.ResponseRecorder {
+		buf := bytes.NewBuffer([]byte{})
+		_ = json.NewEncoder(buf).Encode(body)
+
+		request := httptest.NewRequest(method, path, buf)
+		request = request.WithContext(context.WithValue(request.Context(), auth.UIDKey{}, uid))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/workspaces", "owner", handler.WorkspaceRequest{Name: "campaigns"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	ws := storage.Workspace{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&ws))
+	assert.Equal(t, storage.RoleOwner, ws.Role)
+
+	base := "/api/workspaces/" + ws.ID
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		uid        string
+		body       interface{}
+		statusCode int
+	}{
+		{"stranger can't list", http.MethodGet, base + "/urls", "stranger", nil, http.StatusForbidden},
+		{"owner adds an editor", http.MethodPut, base + "/members", "owner", handler.MemberRequest{UID: "editor", Role: storage.RoleEditor}, http.StatusOK},
+		{"owner adds a viewer", http.MethodPut, base + "/members", "owner", handler.MemberRequest{UID: "viewer", Role: storage.RoleViewer}, http.StatusOK},
+		{"unknown role", http.MethodPut, base + "/members", "owner", handler.MemberRequest{UID: "viewer", Role: "admin"}, http.StatusBadRequest},
+		{"editor can't manage members", http.MethodPut, base + "/members", "editor", handler.MemberRequest{UID: "stranger", Role: storage.RoleViewer}, http.StatusForbidden},
+		{"editor shortens", http.MethodPost, base + "/urls", "editor", handler.URL{URL: "https://campaign.example"}, http.StatusCreated},
+		{"viewer can't shorten", http.MethodPost, base + "/urls", "viewer", handler.URL{URL: "https://viewer.example"}, http.StatusForbidden},
+		{"viewer lists", http.MethodGet, base + "/urls", "viewer", nil, http.StatusOK},
+		{"viewer can't delete", http.MethodDelete, base + "/urls", "viewer", []string{"deadbeef"}, http.StatusForbidden},
+		{"last owner can't leave", http.MethodDelete, base + "/members/owner", "owner", nil, http.StatusConflict},
+		{"viewer leaves", http.MethodDelete, base + "/members/viewer", "viewer", nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.uid, tt.body)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+
+	t.Run("deletion is authorized by membership", func(t *testing.T) {
+		urls, err := a.GetWorkspaceURLs(context.Background(), "owner", ws.ID)
+		assert.NoError(t, err)
+		assert.Len(t, urls, 1)
+
+		hash := urls[0].ShortURL[len(cfg.BaseURL)+1:]
+
+		deleted, err := st.DeleteURLs(context.Background(), []storage.DeletionEntry{{UID: "viewer", Hash: hash}})
+		assert.NoError(t, err)
+		assert.Empty(t, deleted, "viewers can't delete workspace urls")
+		u, _ := st.GetURL(context.Background(), "", hash)
+		assert.False(t, u.IsDeleted)
+
+		deleted, err = st.DeleteURLs(context.Background(), []storage.DeletionEntry{{UID: "owner", Hash: hash}})
+		assert.NoError(t, err)
+		assert.Len(t, deleted, 1)
+		u, _ = st.GetURL(context.Background(), "", hash)
+		assert.True(t, u.IsDeleted)
+	})
+}
@@ -0,0 +1,190 @@
+// Package health reports liveness and per-component readiness of the service
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// Component and report statuses. Only StatusFail and StatusDraining make the service not ready.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+// checkTimeout bounds every single check
+const checkTimeout = 2 * time.Second
+
+// Component is the result of a single check
+type Component struct {
+	Name    string         `json:"name"`
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Report is the readiness response
+type Report struct {
+	Status     string      `json:"status"`
+	Components []Component `json:"components"`
+}
+
+// Check inspects a component, Name is filled in by the Checker
+type Check func(ctx context.Context) Component
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs registered checks and tracks draining during shutdown
+type Checker struct {
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+// New creates a checker without checks
+func New() *Checker {
+	return &Checker{}
+}
+
+// Add registers a check under name, it must be called before serving
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name, check})
+}
+
+// SetDraining makes the service not ready, so that load balancers stop sending new requests
+func (c *Checker) SetDraining() {
+	c.draining.Store(true)
+}
+
+// Ready runs all checks concurrently and combines their statuses
+func (c *Checker) Ready(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Components: make([]Component, len(c.checks))}
+
+	wg := sync.WaitGroup{}
+
+	for i, nc := range c.checks {
+		wg.Add(1)
+
+		go func(i int, nc namedCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			component := nc.check(ctx)
+			component.Name = nc.name
+			report.Components[i] = component
+		}(i, nc)
+	}
+
+	wg.Wait()
+
+	for _, component := range report.Components {
+		switch {
+		case component.Status == StatusFail:
+			report.Status = StatusFail
+		case component.Status == StatusDegraded && report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+
+	if c.draining.Load() {
+		report.Status = StatusDraining
+	}
+
+	return report
+}
+
+// HandleHealthz reports that the process is alive and serving, it stays 200 while draining
+func (c *Checker) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(Report{Status: StatusOK, Components: []Component{}})
+}
+
+// HandleReadyz runs the checks
+// HTTP response codes:
+//
+//	200 - ready, components may be degraded
+//	503 - a component failed or the server is draining
+func (c *Checker) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := c.Ready(r.Context())
+
+	status := http.StatusOK
+	if report.Status == StatusFail || report.Status == StatusDraining {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// result turns an error into a component status
+func result(err error) Component {
+	if err != nil {
+		return Component{Status: StatusFail, Error: err.Error()}
+	}
+
+	return Component{Status: StatusOK}
+}
+
+// StorageRead checks that st is reachable and readable
+func StorageRead(st storage.Storage) Check {
+	return func(ctx context.Context) Component {
+		_, err := st.IsAlive(ctx)
+		return result(err)
+	}
+}
+
+// StorageWrite checks that st accepts writes
+func StorageWrite(st storage.Storage) Check {
+	return func(ctx context.Context) Component {
+		_, err := st.IsWritable(ctx)
+		return result(err)
+	}
+}
+
+// Migrations checks that the storage schema is up to date
+func Migrations(st storage.Storage) Check {
+	return func(ctx context.Context) Component {
+		return result(st.CheckSchema(ctx))
+	}
+}
+
+// Lag is reported by a background pipeline
+type Lag struct {
+	Outstanding int64         // items waiting to be processed
+	Age         time.Duration // age of the oldest waiting item
+	LastError   string        // error of the last processing attempt, empty if it succeeded
+}
+
+// PipelineLag reports a pipeline as degraded if its oldest item waits longer than maxAge
+// or its last processing attempt failed. A lagging pipeline doesn't make the service not ready.
+func PipelineLag(lag func() Lag, maxAge time.Duration) Check {
+	return func(ctx context.Context) Component {
+		l := lag()
+
+		component := Component{Status: StatusOK, Error: l.LastError, Details: map[string]any{
+			"outstanding": l.Outstanding,
+			"lag_seconds": l.Age.Seconds(),
+		}}
+
+		if l.Age > maxAge || l.LastError != "" {
+			component.Status = StatusDegraded
+		}
+
+		return component
+	}
+}
@@ -0,0 +1,94 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/health"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Readyz(t *testing.T) {
+	writable := storage.InitFileStorage(nil, &config.Config{FileStoragePath: filepath.Join(t.TempDir(), "urls.log")})
+	unwritable := storage.InitFileStorage(nil, &config.Config{FileStoragePath: filepath.Join(t.TempDir(), "missing", "urls.log")})
+
+	tests := []struct {
+		name       string
+		st         storage.Storage
+		lag        health.Lag
+		draining   bool
+		wantStatus int
+		wantReport string
+		wantFailed string
+	}{
+		{name: "ready", st: writable, wantStatus: http.StatusOK, wantReport: health.StatusOK},
+		{name: "unwritable file", st: unwritable, wantStatus: http.StatusServiceUnavailable, wantReport: health.StatusFail, wantFailed: "storage.write"},
+		{
+			name:       "deletions lag behind",
+			st:         writable,
+			lag:        health.Lag{Outstanding: 3, Age: 2 * time.Minute},
+			wantStatus: http.StatusOK,
+			wantReport: health.StatusDegraded,
+		},
+		{
+			name:       "deletions fail",
+			st:         writable,
+			lag:        health.Lag{Outstanding: 1, LastError: "connection refused"},
+			wantStatus: http.StatusOK,
+			wantReport: health.StatusDegraded,
+		},
+		{name: "draining", st: writable, draining: true, wantStatus: http.StatusServiceUnavailable, wantReport: health.StatusDraining},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.New()
+			checker.Add("storage.read", health.StorageRead(tt.st))
+			checker.Add("storage.write", health.StorageWrite(tt.st))
+			checker.Add("migrations", health.Migrations(tt.st))
+			checker.Add("deletion", health.PipelineLag(func() health.Lag { return tt.lag }, time.Minute))
+
+			if tt.draining {
+				checker.SetDraining()
+			}
+
+			w := httptest.NewRecorder()
+			checker.HandleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			report := health.Report{}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantReport, report.Status)
+			assert.Len(t, report.Components, 4)
+
+			for _, c := range report.Components {
+				if c.Name == tt.wantFailed {
+					assert.Equal(t, health.StatusFail, c.Status)
+					assert.NotEmpty(t, c.Error)
+				}
+			}
+		})
+	}
+}
+
+func Test_Healthz(t *testing.T) {
+	checker := health.New()
+	checker.Add("always failing", func(ctx context.Context) health.Component {
+		return health.Component{Status: health.StatusFail}
+	})
+	checker.SetDraining()
+
+	w := httptest.NewRecorder()
+	checker.HandleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,589 @@
+// Package imports runs bulk URL imports in the background. Jobs, their uploads and results are kept in
+// a directory, so jobs interrupted by a restart resume from their last saved chunk.
+package imports
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Upload formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// chunkSize is the number of items saved together, progress is recorded after every chunk
+const chunkSize = 1000
+
+// expireInterval is how often Run removes expired jobs
+const expireInterval = time.Hour
+
+var (
+	// ErrUnknownFormat is returned for formats other than csv and ndjson
+	ErrUnknownFormat = errors.New("unknown format, use csv or ndjson")
+	// ErrNotFound is returned for jobs that don't exist or belong to another user
+	ErrNotFound = errors.New("import job not found")
+)
+
+// Job is an import of an upload
+type Job struct {
+	ID         string    `json:"id"`
+	UID        string    `json:"-"`
+	Domain     string    `json:"domain,omitempty"` // default domain of the imported URLs, see app.ResolveDomain
+	Format     string    `json:"format"`
+	Status     string    `json:"status"`
+	Size       int64     `json:"size"`            // upload size in bytes
+	Progress   float64   `json:"progress"`        // share of the upload processed, from 0 to 1
+	Processed  int       `json:"processed"`       // items processed, imported or failed
+	Imported   int       `json:"imported"`        // items saved
+	Failed     int       `json:"failed"`          // items skipped, their errors are in the result
+	Error      string    `json:"error,omitempty"` // why the job failed
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	ResultSize int64     `json:"result_size"` // bytes of the result written by finished chunks
+}
+
+// jobFile is how a job is persisted, unlike Job it keeps the owner uid
+type jobFile struct {
+	Job
+	UID string `json:"uid"`
+}
+
+// Result is a line of the result file, items are numbered from 1 in upload order
+type Result struct {
+	Item int `json:"item"`
+	app.BatchResult
+}
+
+// Manager keeps import jobs in a directory and runs them one by one. Uploads are removed once their
+// jobs finish, finished jobs and their results are removed Retention after that.
+type Manager struct {
+	Retention time.Duration // how long finished jobs are kept, forever if 0
+
+	dir string
+	app *app.App
+
+	mu      sync.Mutex      // guards jobs and pending
+	jobs    map[string]*Job // all jobs by id
+	pending []string        // ids of jobs to run in order
+	wake    chan struct{}   // signals Run that a job was queued
+}
+
+// New creates a manager keeping jobs in dir and saving URLs through a. Jobs that were queued or
+// running when the service stopped are queued again, call Run to process them.
+func New(dir string, a *app.App) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{dir: dir, app: a, jobs: map[string]*Job{}, wake: make(chan struct{}, 1)}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	resumed := []*Job{}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		f := jobFile{}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		job := f.Job
+		job.UID = f.UID
+		m.jobs[job.ID] = &job
+
+		if job.Status == StatusQueued || job.Status == StatusRunning {
+			resumed = append(resumed, &job)
+		} else {
+			m.removeFiles(job.ID, ".upload")
+		}
+	}
+
+	// Glob sorts by id, jobs resume in the order they were created
+	sort.Slice(resumed, func(i, j int) bool { return resumed[i].CreatedAt.Before(resumed[j].CreatedAt) })
+
+	for _, job := range resumed {
+		m.pending = append(m.pending, job.ID)
+	}
+
+	return m, nil
+}
+
+func (m *Manager) path(id, ext string) string {
+	return filepath.Join(m.dir, id+ext)
+}
+
+// removeFiles removes files of the job with id with extensions exts, failures are logged
+func (m *Manager) removeFiles(id string, exts ...string) {
+	for _, ext := range exts {
+		if err := os.Remove(m.path(id, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("removing import file", "job", id, "error", err)
+		}
+	}
+}
+
+// Expire removes jobs which finished more than Retention before now with their results and returns
+// their number
+func (m *Manager) Expire(now time.Time) int {
+	if m.Retention <= 0 {
+		return 0
+	}
+
+	m.mu.Lock()
+
+	expired := []string{}
+
+	for id, job := range m.jobs {
+		finished := job.Status == StatusDone || job.Status == StatusFailed
+		if finished && now.Sub(job.UpdatedAt) > m.Retention {
+			expired = append(expired, id)
+			delete(m.jobs, id)
+		}
+	}
+
+	m.mu.Unlock()
+
+	for _, id := range expired {
+		m.removeFiles(id, ".result", ".upload", ".json")
+	}
+
+	return len(expired)
+}
+
+// Create stores an upload read from body and queues a job importing it for uid, domain is the default
+// domain of its URLs
+func (m *Manager) Create(uid, domain, format string, body io.Reader) (Job, error) {
+	if format != FormatCSV && format != FormatNDJSON {
+		return Job{}, ErrUnknownFormat
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Job{}, err
+	}
+
+	now := time.Now().UTC()
+	job := Job{ID: hex.EncodeToString(id), UID: uid, Domain: domain, Format: format, Status: StatusQueued, CreatedAt: now, UpdatedAt: now}
+
+	size, err := writeFile(m.path(job.ID, ".upload"), func(f *os.File) error {
+		_, err := io.Copy(f, body)
+		return err
+	})
+	if err != nil {
+		return Job{}, err
+	}
+
+	job.Size = size
+
+	if err := m.save(&job); err != nil {
+		os.Remove(m.path(job.ID, ".upload"))
+		return Job{}, err
+	}
+
+	queued := job
+
+	m.mu.Lock()
+	m.jobs[job.ID] = &queued
+	m.pending = append(m.pending, job.ID)
+	m.mu.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// Get returns the job with id if it belongs to uid
+func (m *Manager) Get(uid, id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok || job.UID != uid {
+		return Job{}, ErrNotFound
+	}
+
+	return *job, nil
+}
+
+// ResultReader reads the part of a job result written by finished chunks: NDJSON Result lines
+type ResultReader struct {
+	*io.SectionReader
+	file *os.File // nil if nothing was written yet
+}
+
+// Close closes the result file
+func (r *ResultReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
+
+// OpenResult returns the result of the job with id written so far and the job if it belongs to uid
+func (m *Manager) OpenResult(uid, id string) (*ResultReader, Job, error) {
+	job, err := m.Get(uid, id)
+	if err != nil {
+		return nil, Job{}, err
+	}
+
+	f, err := os.Open(m.path(id, ".result"))
+	if errors.Is(err, os.ErrNotExist) {
+		return &ResultReader{SectionReader: io.NewSectionReader(strings.NewReader(""), 0, 0)}, job, nil
+	}
+
+	if err != nil {
+		return nil, Job{}, err
+	}
+
+	return &ResultReader{SectionReader: io.NewSectionReader(f, 0, job.ResultSize), file: f}, job, nil
+}
+
+// Run processes queued jobs and removes expired ones until ctx is done. A job interrupted by ctx
+// stays running and resumes when the manager is created again.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	m.Expire(time.Now())
+
+	for {
+		m.mu.Lock()
+
+		var job *Job
+		if len(m.pending) > 0 {
+			job = m.jobs[m.pending[0]]
+			m.pending = m.pending[1:]
+		}
+
+		m.mu.Unlock()
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.wake:
+			case now := <-ticker.C:
+				m.Expire(now)
+			}
+
+			continue
+		}
+
+		err := m.process(ctx, job)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// a finished job doesn't read its upload again
+		m.removeFiles(job.ID, ".upload")
+
+		m.update(job, func(job *Job) {
+			job.Status = StatusDone
+
+			if err != nil {
+				job.Status, job.Error = StatusFailed, err.Error()
+			}
+		})
+
+		slog.Info("import finished", "job", job.ID, "status", job.Status, "imported", job.Imported, "failed", job.Failed)
+	}
+}
+
+// update changes a copy of job, persists it and publishes it. Persisting errors are logged: the job
+// would repeat the work since its last saved state after a restart.
+func (m *Manager) update(job *Job, change func(job *Job)) {
+	m.mu.Lock()
+	next := *job
+	m.mu.Unlock()
+
+	change(&next)
+	next.UpdatedAt = time.Now().UTC()
+
+	if err := m.save(&next); err != nil {
+		slog.Error("saving import job", "job", job.ID, "error", err)
+	}
+
+	m.mu.Lock()
+	*job = next
+	m.mu.Unlock()
+}
+
+// save writes job to its file atomically
+func (m *Manager) save(job *Job) error {
+	data, err := json.Marshal(jobFile{Job: *job, UID: job.UID})
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFile(m.path(job.ID, ".json"), func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+
+	return err
+}
+
+// writeFile writes path through a synced temporary file renamed over it and returns its size
+func writeFile(path string, write func(f *os.File) error) (int64, error) {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	size, _ := f.Seek(0, io.SeekCurrent)
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// countingReader counts bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// process imports the upload of job from the item after the last processed one
+func (m *Manager) process(ctx context.Context, job *Job) error {
+	m.update(job, func(job *Job) { job.Status = StatusRunning })
+
+	upload, err := os.Open(m.path(job.ID, ".upload"))
+	if err != nil {
+		return err
+	}
+
+	defer upload.Close()
+
+	result, err := os.OpenFile(m.path(job.ID, ".result"), os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	defer result.Close()
+
+	// drop results of a chunk that was being written when the job was interrupted
+	if err := result.Truncate(job.ResultSize); err != nil {
+		return err
+	}
+
+	if _, err := result.Seek(job.ResultSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	counter := &countingReader{r: upload}
+
+	items, err := newItemReader(counter, job.Format)
+	if err != nil {
+		return err
+	}
+
+	// items saved before the interruption are skipped
+	item, chunk := 0, make([]storage.BatchURL, 0, chunkSize)
+	malformed := map[int]string{}
+
+	for {
+		raw, err := items.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil && !errors.Is(err, errMalformed) {
+			return err
+		}
+
+		item++
+		if item <= job.Processed {
+			continue
+		}
+
+		if err != nil {
+			malformed[len(chunk)] = err.Error()
+		}
+
+		chunk = append(chunk, raw)
+
+		if len(chunk) < chunkSize {
+			continue
+		}
+
+		if err := m.saveChunk(ctx, job, chunk, malformed, result, counter.n); err != nil {
+			return err
+		}
+
+		chunk, malformed = chunk[:0], map[int]string{}
+	}
+
+	if err := m.saveChunk(ctx, job, chunk, malformed, result, counter.n); err != nil {
+		return err
+	}
+
+	m.update(job, func(job *Job) { job.Progress = 1 })
+
+	return nil
+}
+
+// saveChunk saves chunk, appends its results and records the progress. Items at the indexes of malformed
+// are reported with their errors. If the chunk can't be saved at once, its items are saved one by one,
+// so an URL that already exists only fails itself.
+func (m *Manager) saveChunk(ctx context.Context, job *Job, chunk []storage.BatchURL, malformed map[int]string, result *os.File, read int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	results := make([]Result, len(chunk))
+	valid := make([]storage.BatchURL, 0, len(chunk))
+	index := make([]int, 0, len(chunk)) // position of every valid item in chunk
+
+	for i, raw := range chunk {
+		results[i].Item = job.Processed + i + 1
+
+		if msg, ok := malformed[i]; ok {
+			results[i].Error = msg
+			continue
+		}
+
+		if raw.CorrelationID == "" {
+			raw.CorrelationID = app.Hash(raw.OriginalURL)
+		}
+
+		valid = append(valid, raw)
+		index = append(index, i)
+	}
+
+	saved, err := m.app.BatchSaveChunk(ctx, valid, job.UID, job.Domain)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		saved = make([]app.BatchResult, 0, len(valid))
+
+		for _, raw := range valid {
+			one, err := m.app.BatchSaveChunk(ctx, []storage.BatchURL{raw}, job.UID, job.Domain)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if err != nil {
+				one = []app.BatchResult{{CorrelationID: raw.CorrelationID, Error: saveError(err)}}
+			}
+
+			saved = append(saved, one[0])
+		}
+	}
+
+	imported, failed := 0, len(chunk)-len(valid)
+
+	for i, res := range saved {
+		results[index[i]].BatchResult = res
+
+		if res.Error != "" {
+			failed++
+		} else {
+			imported++
+		}
+	}
+
+	buf := strings.Builder{}
+	enc := json.NewEncoder(&buf)
+
+	for i := range results {
+		if err := enc.Encode(&results[i]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := result.WriteString(buf.String()); err != nil {
+		return err
+	}
+
+	if err := result.Sync(); err != nil {
+		return err
+	}
+
+	m.update(job, func(job *Job) {
+		job.Processed += len(chunk)
+		job.Imported += imported
+		job.Failed += failed
+		job.ResultSize += int64(buf.Len())
+
+		if job.Size > 0 {
+			job.Progress = min(float64(read)/float64(job.Size), 1)
+		}
+	})
+
+	return nil
+}
+
+// saveError describes why an URL wasn't saved
+func saveError(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return "short url already exists"
+	}
+
+	return err.Error()
+}
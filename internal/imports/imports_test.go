@@ -0,0 +1,361 @@
+package imports_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/imports"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/caarlos0/env/v6"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func InitTestConfig() (*config.Config, error) {
+	cfg := &config.Config{}
+	err := env.Parse(cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// batchStorage counts saved URLs, fails batches with an URL containing "fail" and calls saved after every batch
+type batchStorage struct {
+	storage.Storage
+	mu    sync.Mutex
+	urls  int
+	saved func()
+}
+
+func (s *batchStorage) BatchSaveURL(ctx context.Context, urls []storage.URL) error {
+	for _, u := range urls {
+		if strings.Contains(u.URL, "fail") {
+			return errors.New("storage refused " + u.URL)
+		}
+	}
+
+	if err := s.Storage.BatchSaveURL(ctx, urls); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.urls += len(urls)
+	s.mu.Unlock()
+
+	if s.saved != nil {
+		s.saved()
+	}
+
+	return nil
+}
+
+func (s *batchStorage) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.urls
+}
+
+func newApp(t *testing.T) (*app.App, *batchStorage) {
+	cfg, err := InitTestConfig()
+	require.NoError(t, err)
+
+	st := &batchStorage{Storage: storage.InitStorage(map[string]storage.URL{}, cfg)}
+	a := app.NewApp(st, cfg)
+	a.Init()
+
+	return a, st
+}
+
+// waitDone runs m until the job with id is finished
+func waitDone(t *testing.T, m *imports.Manager, uid, id string) imports.Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go m.Run(ctx)
+
+	var job imports.Job
+
+	require.Eventually(t, func() bool {
+		job, _ = m.Get(uid, id)
+		return job.Status == imports.StatusDone || job.Status == imports.StatusFailed
+	}, 5*time.Second, 5*time.Millisecond)
+
+	return job
+}
+
+func readResults(t *testing.T, m *imports.Manager, uid, id string) []imports.Result {
+	r, _, err := m.OpenResult(uid, id)
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	results := []imports.Result{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		res := imports.Result{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &res))
+		results = append(results, res)
+	}
+
+	return results
+}
+
+func Test_Import(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		body     string
+		imported int
+		errors   map[int]string // item -> error prefix
+	}{
+		{
+			name:     "ndjson",
+			format:   imports.FormatNDJSON,
+			body:     `{"correlation_id":"n1","original_url":"https://example.com/1"}` + "\n\n" + `{"original_url":"https://example.com/2"}` + "\n",
+			imported: 2,
+		},
+		{
+			name:     "ndjson with malformed items",
+			format:   imports.FormatNDJSON,
+			body:     `{"original_url":"https://example.com/3"}` + "\n{broken\n" + `{"original_url":"not an url"}` + "\n" + `{"original_url":"https://example.com/4"}`,
+			imported: 2,
+			errors:   map[int]string{2: "malformed item", 3: app.ErrInvalidURL.Error()},
+		},
+		{
+			name:     "csv",
+			format:   imports.FormatCSV,
+			body:     "title,original_url,correlation_id\nfirst,https://example.com/5,c5\nsecond,https://example.com/6,\n",
+			imported: 2,
+		},
+		{
+			name:     "csv with an item failing in the storage",
+			format:   imports.FormatCSV,
+			body:     "original_url\nhttps://example.com/7\nhttps://example.com/fail\nhttps://example.com/8\n",
+			imported: 2,
+			errors:   map[int]string{2: "storage refused"},
+		},
+		{
+			name:   "csv without original_url",
+			format: imports.FormatCSV,
+			body:   "url\nhttps://example.com/9\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, st := newApp(t)
+
+			m, err := imports.New(t.TempDir(), a)
+			require.NoError(t, err)
+
+			job, err := m.Create("user", "", tt.format, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			assert.Equal(t, imports.StatusQueued, job.Status)
+			assert.Equal(t, int64(len(tt.body)), job.Size)
+
+			job = waitDone(t, m, "user", job.ID)
+
+			if tt.imported == 0 && tt.errors == nil {
+				assert.Equal(t, imports.StatusFailed, job.Status)
+				assert.NotEmpty(t, job.Error)
+
+				return
+			}
+
+			assert.Equal(t, imports.StatusDone, job.Status)
+			assert.Equal(t, tt.imported, job.Imported)
+			assert.Equal(t, len(tt.errors), job.Failed)
+			assert.Equal(t, tt.imported+len(tt.errors), job.Processed)
+			assert.Equal(t, float64(1), job.Progress)
+			assert.Equal(t, tt.imported, st.count())
+
+			results := readResults(t, m, "user", job.ID)
+			assert.Len(t, results, job.Processed)
+
+			for i, res := range results {
+				assert.Equal(t, i+1, res.Item)
+
+				if prefix, ok := tt.errors[res.Item]; ok {
+					assert.True(t, strings.HasPrefix(res.Error, prefix), res.Error)
+					assert.Empty(t, res.ShortURL)
+
+					continue
+				}
+
+				assert.Empty(t, res.Error)
+				assert.NotEmpty(t, res.ShortURL)
+			}
+		})
+	}
+}
+
+func Test_ImportUnknownFormat(t *testing.T) {
+	a, _ := newApp(t)
+
+	m, err := imports.New(t.TempDir(), a)
+	require.NoError(t, err)
+
+	_, err = m.Create("user", "", "xml", strings.NewReader("<urls/>"))
+	assert.ErrorIs(t, err, imports.ErrUnknownFormat)
+}
+
+func Test_ImportOwner(t *testing.T) {
+	a, _ := newApp(t)
+
+	m, err := imports.New(t.TempDir(), a)
+	require.NoError(t, err)
+
+	job, err := m.Create("user", "", imports.FormatNDJSON, strings.NewReader(""))
+	require.NoError(t, err)
+
+	_, err = m.Get("stranger", job.ID)
+	assert.ErrorIs(t, err, imports.ErrNotFound)
+
+	_, _, err = m.OpenResult("stranger", job.ID)
+	assert.ErrorIs(t, err, imports.ErrNotFound)
+
+	_, err = m.Get("user", "missing")
+	assert.ErrorIs(t, err, imports.ErrNotFound)
+}
+
+func Test_ImportResumes(t *testing.T) {
+	const items = 2500
+
+	body := strings.Builder{}
+	for i := 0; i < items; i++ {
+		fmt.Fprintf(&body, "{\"original_url\":\"https://example.com/%d\"}\n", i)
+	}
+
+	dir := t.TempDir()
+	a, st := newApp(t)
+
+	m, err := imports.New(dir, a)
+	require.NoError(t, err)
+
+	job, err := m.Create("user", "", imports.FormatNDJSON, strings.NewReader(body.String()))
+	require.NoError(t, err)
+
+	// the service stops while the first chunk is saved
+	ctx, cancel := context.WithCancel(context.Background())
+	st.saved = cancel
+
+	m.Run(ctx)
+
+	st.saved = nil
+	interrupted, err := m.Get("user", job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, imports.StatusRunning, interrupted.Status)
+	assert.Equal(t, 1000, interrupted.Processed)
+	assert.Greater(t, interrupted.Progress, float64(0))
+	assert.Less(t, interrupted.Progress, float64(1))
+
+	restarted, err := imports.New(dir, a)
+	require.NoError(t, err)
+
+	done := waitDone(t, restarted, "user", job.ID)
+	assert.Equal(t, imports.StatusDone, done.Status)
+	assert.Equal(t, items, done.Imported)
+	assert.Equal(t, items, st.count())
+
+	results := readResults(t, restarted, "user", job.ID)
+	require.Len(t, results, items)
+
+	for i, res := range results {
+		assert.Equal(t, i+1, res.Item)
+	}
+}
+
+func Test_ImportResultOfRunningJob(t *testing.T) {
+	a, _ := newApp(t)
+
+	m, err := imports.New(t.TempDir(), a)
+	require.NoError(t, err)
+
+	job, err := m.Create("user", "", imports.FormatNDJSON, strings.NewReader(`{"original_url":"https://example.com"}`))
+	require.NoError(t, err)
+
+	r, _, err := m.OpenResult("user", job.ID)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+	assert.NoError(t, r.Close())
+}
+
+// jobFiles returns the names of files kept in dir
+func jobFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	return names
+}
+
+func Test_ImportCleanup(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := newApp(t)
+
+	m, err := imports.New(dir, a)
+	require.NoError(t, err)
+
+	m.Retention = time.Hour
+
+	done, err := m.Create("user", "", imports.FormatNDJSON, strings.NewReader(`{"original_url":"https://example.com/done"}`))
+	require.NoError(t, err)
+
+	failed, err := m.Create("user", "", imports.FormatCSV, strings.NewReader("no,header\n"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go m.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		job, _ := m.Get("user", failed.ID)
+		return job.Status == imports.StatusFailed
+	}, 5*time.Second, 5*time.Millisecond)
+
+	t.Run("uploads are removed once jobs finish", func(t *testing.T) {
+		assert.ElementsMatch(t, []string{
+			done.ID + ".json", done.ID + ".result", failed.ID + ".json", failed.ID + ".result",
+		}, jobFiles(t, dir))
+	})
+
+	t.Run("jobs are kept for the retention period", func(t *testing.T) {
+		assert.Equal(t, 0, m.Expire(time.Now().Add(time.Minute)))
+
+		_, err := m.Get("user", done.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("expired jobs and results are removed", func(t *testing.T) {
+		assert.Equal(t, 2, m.Expire(time.Now().Add(2*time.Hour)))
+
+		_, err := m.Get("user", done.ID)
+		assert.ErrorIs(t, err, imports.ErrNotFound)
+		assert.Empty(t, jobFiles(t, dir))
+	})
+}
@@ -0,0 +1,124 @@
+package imports
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// errMalformed marks items that can't be parsed, they are reported in the result and the job goes on
+var errMalformed = errors.New("malformed item")
+
+// itemReader returns upload items one by one and io.EOF after the last one
+type itemReader interface {
+	Next() (storage.BatchURL, error)
+}
+
+// newItemReader returns a reader of an upload in format
+func newItemReader(r io.Reader, format string) (itemReader, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonReader{r: bufio.NewReader(r)}, nil
+	case FormatCSV:
+		return newCSVReader(r)
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// ndjsonReader reads a storage.BatchURL per line, blank lines are skipped
+type ndjsonReader struct {
+	r *bufio.Reader
+}
+
+func (nr *ndjsonReader) Next() (storage.BatchURL, error) {
+	for {
+		line, err := nr.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return storage.BatchURL{}, err
+			}
+
+			continue
+		}
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			return storage.BatchURL{}, err
+		}
+
+		item := storage.BatchURL{}
+		if err := json.Unmarshal(line, &item); err != nil {
+			return storage.BatchURL{}, fmt.Errorf("%w: %v", errMalformed, err)
+		}
+
+		return item, nil
+	}
+}
+
+// csvReader reads CSV with a header naming its columns: original_url is required,
+// correlation_id and domain are optional, other columns are ignored
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return &csvReader{r: cr}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+
+	if _, ok := columns["original_url"]; !ok {
+		return nil, errors.New("csv header has no original_url column")
+	}
+
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+func (cr *csvReader) field(record []string, name string) string {
+	i, ok := cr.columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+
+	return strings.TrimSpace(record[i])
+}
+
+func (cr *csvReader) Next() (storage.BatchURL, error) {
+	record, err := cr.r.Read()
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return storage.BatchURL{}, fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	if err != nil {
+		return storage.BatchURL{}, err
+	}
+
+	return storage.BatchURL{
+		OriginalURL:   cr.field(record, "original_url"),
+		CorrelationID: cr.field(record, "correlation_id"),
+		Domain:        cr.field(record, "domain"),
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Package logger creates structured loggers and keeps a request-scoped logger in a context
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+)
+
+// Level is shared by all loggers created with New, changing it changes their level at runtime
+var Level = new(slog.LevelVar)
+
+type loggerKey struct{}
+
+type annotationsKey struct{}
+
+// annotations collects attributes added by inner MWs and handlers for the access log line of a request
+type annotations struct {
+	mu    sync.Mutex
+	attrs []any
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return l, fmt.Errorf("unknown log level %q", s)
+	}
+
+	return l, nil
+}
+
+// New creates a logger writing to w in cfg.LogFormat (json or text) and sets Level to cfg.LogLevel
+func New(cfg *config.Config, w io.Writer) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	Level.Set(level)
+
+	opts := &slog.HandlerOptions{Level: Level}
+
+	switch strings.ToLower(cfg.LogFormat) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.LogFormat)
+	}
+}
+
+// WithContext returns a context carrying l
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the request-scoped logger or the default one if ctx carries none
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+
+	return slog.Default()
+}
+
+// WithAnnotations returns a context collecting attributes for the access log line, see Annotate
+func WithAnnotations(ctx context.Context) context.Context {
+	return context.WithValue(ctx, annotationsKey{}, &annotations{})
+}
+
+// Annotate adds key-value pairs to the request-scoped logger and to the access log line of the request
+func Annotate(ctx context.Context, args ...any) context.Context {
+	if a, ok := ctx.Value(annotationsKey{}).(*annotations); ok {
+		a.mu.Lock()
+		a.attrs = append(a.attrs, args...)
+		a.mu.Unlock()
+	}
+
+	return WithContext(ctx, FromContext(ctx).With(args...))
+}
+
+// Annotations returns the key-value pairs added with Annotate
+func Annotations(ctx context.Context) []any {
+	a, ok := ctx.Value(annotationsKey{}).(*annotations)
+	if !ok {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]any(nil), a.attrs...)
+}
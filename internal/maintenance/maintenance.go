@@ -0,0 +1,377 @@
+// Package maintenance implements offline data management used by the shortener admin command:
+// export, import, purging, reassignment, counts and copying between storages
+package maintenance
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// Formats of exported and imported records
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// ErrUnknownFormat is returned for formats other than ndjson and csv
+var ErrUnknownFormat = errors.New("unknown format, use ndjson or csv")
+
+// chunkSize is how many URLs are saved per BatchSaveURL call
+const chunkSize = 500
+
+// csvHeader is the first line of CSV exports, imports require it or legacyCSVHeader
+var csvHeader = []string{"uid", "short_url", "original_url", "is_deleted", "workspace_id", "domain", "created_at"}
+
+// legacyCSVHeader starts exports made before creation times were recorded
+var legacyCSVHeader = csvHeader[:6]
+
+// Record is an exported URL. Unlike storage.URL it keeps the owner uid.
+type Record struct {
+	UID         string    `json:"uid"`
+	ShortURL    string    `json:"short_url"`
+	URL         string    `json:"original_url"`
+	IsDeleted   bool      `json:"is_deleted"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	Domain      string    `json:"domain,omitempty"`
+	CreatedAt   time.Time `json:"created_at"` // zero if unknown
+}
+
+// Open opens the storage configured by cfg. Unlike storage.InitStorage it doesn't fall back
+// to the file storage when the database is unreachable.
+func Open(cfg *config.Config) (storage.Storage, error) {
+	if cfg.DatabaseDSN != "" {
+		return storage.InitDBStorage(cfg)
+	}
+
+	if cfg.FileStoragePath == "" {
+		return nil, errors.New("neither a database dsn nor a file storage path is configured")
+	}
+
+	return storage.InitFileStorage(nil, cfg), nil
+}
+
+// Export writes all URLs of st including deleted ones to w and returns their number
+func Export(ctx context.Context, st storage.Storage, w io.Writer, format string) (int, error) {
+	var write func(Record) error
+
+	flush := func() error { return nil }
+
+	switch format {
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		write = func(r Record) error { return enc.Encode(r) }
+		flush = bw.Flush
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+
+		write = func(r Record) error {
+			return cw.Write([]string{r.UID, r.ShortURL, r.URL, strconv.FormatBool(r.IsDeleted), r.WorkspaceID, r.Domain, formatTime(r.CreatedAt)})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return 0, ErrUnknownFormat
+	}
+
+	n := 0
+
+	err := st.ForEachURL(ctx, func(u storage.URL) error {
+		n++
+		return write(Record(u))
+	})
+	if err != nil {
+		return n, err
+	}
+
+	return n, flush()
+}
+
+// ImportResult describes an import
+type ImportResult struct {
+	Imported int `json:"imported"` // saved records
+	Existing int `json:"existing"` // records skipped since their short url is taken
+	Deleted  int `json:"deleted"`  // deleted records, saved and marked deleted only with includeDeleted
+}
+
+// Import reads records from r and saves those whose short url isn't taken yet on their domain.
+// Deleted records are skipped unless includeDeleted is set. Deleted workspace URLs are marked deleted
+// only if their uid may still delete them in st, see Storage.DeleteURLs.
+func Import(ctx context.Context, st storage.Storage, r io.Reader, format string, includeDeleted bool) (ImportResult, error) {
+	res := ImportResult{}
+
+	next, err := newReader(r, format)
+	if err != nil {
+		return res, err
+	}
+
+	existing := map[string]struct{}{}
+
+	err = st.ForEachURL(ctx, func(u storage.URL) error {
+		existing[key(u.Domain, u.ShortURL)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	chunk := []storage.URL{}
+	deletions := []storage.DeletionEntry{}
+
+	save := func() error {
+		if len(chunk) > 0 {
+			if err := st.BatchSaveURL(ctx, chunk); err != nil {
+				return err
+			}
+		}
+
+		if len(deletions) > 0 {
+			if _, err := st.DeleteURLs(ctx, deletions); err != nil {
+				return err
+			}
+		}
+
+		res.Imported += len(chunk)
+		chunk, deletions = chunk[:0], deletions[:0]
+
+		return nil
+	}
+
+	for line := 1; ; line++ {
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return res, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		if err := validate(rec); err != nil {
+			return res, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		if _, ok := existing[key(rec.Domain, rec.ShortURL)]; ok {
+			res.Existing++
+			continue
+		}
+
+		if rec.IsDeleted {
+			res.Deleted++
+
+			if !includeDeleted {
+				continue
+			}
+
+			deletions = append(deletions, storage.DeletionEntry{UID: rec.UID, Domain: rec.Domain, Hash: rec.ShortURL})
+		}
+
+		existing[key(rec.Domain, rec.ShortURL)] = struct{}{}
+		rec.IsDeleted = false
+		chunk = append(chunk, storage.URL(rec))
+
+		if len(chunk) == chunkSize {
+			if err := save(); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	return res, save()
+}
+
+// newReader returns a function reading the next record from r, it returns io.EOF at the end
+func newReader(r io.Reader, format string) (func() (Record, error), error) {
+	switch format {
+	case FormatNDJSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+
+		return func() (Record, error) {
+			rec := Record{}
+			err := dec.Decode(&rec)
+
+			return rec, err
+		}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+
+		header, headerErr := cr.Read()
+		if headerErr != nil && !errors.Is(headerErr, io.EOF) {
+			return nil, headerErr
+		}
+
+		if headerErr == nil && !equal(header, csvHeader) && !equal(header, legacyCSVHeader) {
+			return nil, fmt.Errorf("unexpected csv header %v, want %v", header, csvHeader)
+		}
+
+		return func() (Record, error) {
+			if headerErr != nil {
+				return Record{}, io.EOF
+			}
+
+			fields, err := cr.Read()
+			if err != nil {
+				return Record{}, err
+			}
+
+			isDeleted, err := strconv.ParseBool(fields[3])
+			if err != nil {
+				return Record{}, fmt.Errorf("is_deleted: %w", err)
+			}
+
+			rec := Record{UID: fields[0], ShortURL: fields[1], URL: fields[2], IsDeleted: isDeleted, WorkspaceID: fields[4], Domain: fields[5]}
+
+			if len(fields) > 6 && fields[6] != "" {
+				if rec.CreatedAt, err = time.Parse(time.RFC3339Nano, fields[6]); err != nil {
+					return Record{}, fmt.Errorf("created_at: %w", err)
+				}
+			}
+
+			return rec, nil
+		}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// formatTime formats t for CSV, a zero time is empty
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// key identifies an URL, hashes are unique per domain
+func key(domain, hash string) string {
+	return domain + "/" + hash
+}
+
+func validate(rec Record) error {
+	if rec.ShortURL == "" {
+		return errors.New("empty short_url")
+	}
+
+	if _, err := url.ParseRequestURI(rec.URL); err != nil {
+		return fmt.Errorf("invalid original_url: %w", err)
+	}
+
+	return nil
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// UserCount is the number of URLs of a user
+type UserCount struct {
+	UID     string `json:"uid"`
+	Active  int    `json:"active"`
+	Deleted int    `json:"deleted"`
+}
+
+// Counts returns URL counts per owner uid ordered by uid
+func Counts(ctx context.Context, st storage.Storage) ([]UserCount, error) {
+	counts := map[string]*UserCount{}
+
+	err := st.ForEachURL(ctx, func(u storage.URL) error {
+		c, ok := counts[u.UID]
+		if !ok {
+			c = &UserCount{UID: u.UID}
+			counts[u.UID] = c
+		}
+
+		if u.IsDeleted {
+			c.Deleted++
+		} else {
+			c.Active++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]UserCount, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, *c)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].UID < result[j].UID })
+
+	return result, nil
+}
+
+// CopyResult describes a copy between storages
+type CopyResult struct {
+	URLs         ImportResult `json:"urls"`
+	Users        int          `json:"users"`         // copied users
+	ExistingUser int          `json:"existing_user"` // users skipped since their login or uid is taken
+}
+
+// Copy copies URLs including deleted ones and users from src to dst, records already in dst are kept.
+// Workspaces and memberships are not copied.
+func Copy(ctx context.Context, src, dst storage.Storage) (CopyResult, error) {
+	res := CopyResult{}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		_, err := Export(ctx, src, pw, FormatNDJSON)
+		pw.CloseWithError(err)
+	}()
+
+	urls, err := Import(ctx, dst, pr, FormatNDJSON, true)
+	pr.CloseWithError(err)
+
+	res.URLs = urls
+	if err != nil {
+		return res, err
+	}
+
+	err = src.ForEachUser(ctx, func(u storage.User) error {
+		err := dst.SaveUser(ctx, u)
+		if errors.Is(err, storage.ErrUserExists) {
+			res.ExistingUser++
+			return nil
+		}
+
+		if err == nil {
+			res.Users++
+		}
+
+		return err
+	})
+
+	return res, err
+}
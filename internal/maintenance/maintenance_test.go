@@ -0,0 +1,205 @@
+package maintenance_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/maintenance"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var createdAt = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+func newStorage(t *testing.T) (*storage.FileStorage, string) {
+	path := filepath.Join(t.TempDir(), "urls.log")
+	return storage.InitFileStorage(nil, &config.Config{FileStoragePath: path}), path
+}
+
+func seed(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, st.BatchSaveURL(ctx, []storage.URL{
+		{UID: "u1", ShortURL: "a", URL: "http://a.com"},
+		{UID: "u2", ShortURL: "b", URL: "http://b.com"},
+		{UID: "u2", ShortURL: "c", URL: "http://c.com", CreatedAt: createdAt},
+	}))
+	deleted, err := st.DeleteURLs(ctx, []storage.DeletionEntry{{UID: "u2", Hash: "b"}})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	require.NoError(t, st.SaveUser(ctx, storage.User{UID: "u1", Login: "alice", PasswordHash: "hash"}))
+}
+
+func Test_ExportImport(t *testing.T) {
+	for _, format := range []string{maintenance.FormatNDJSON, maintenance.FormatCSV} {
+		for _, includeDeleted := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s include deleted %t", format, includeDeleted), func(t *testing.T) {
+				ctx := context.Background()
+				src, _ := newStorage(t)
+				seed(t, src)
+
+				buf := &bytes.Buffer{}
+				n, err := maintenance.Export(ctx, src, buf, format)
+				require.NoError(t, err)
+				assert.Equal(t, 3, n)
+
+				dst, _ := newStorage(t)
+				require.NoError(t, dst.SaveURL(ctx, storage.URL{UID: "u3", ShortURL: "a", URL: "http://other.com"}))
+
+				res, err := maintenance.Import(ctx, dst, buf, format, includeDeleted)
+				require.NoError(t, err)
+				assert.Equal(t, 1, res.Existing)
+				assert.Equal(t, 1, res.Deleted)
+
+				kept, err := dst.GetURL(ctx, "", "a")
+				require.NoError(t, err)
+				assert.Equal(t, "u3", kept.UID)
+
+				imported, err := dst.GetURL(ctx, "", "c")
+				require.NoError(t, err)
+				assert.True(t, createdAt.Equal(imported.CreatedAt))
+
+				deleted, err := dst.GetURL(ctx, "", "b")
+				if includeDeleted {
+					assert.Equal(t, 2, res.Imported)
+					require.NoError(t, err)
+					assert.True(t, deleted.IsDeleted)
+				} else {
+					assert.Equal(t, 1, res.Imported)
+					assert.Error(t, err)
+				}
+			})
+		}
+	}
+}
+
+func Test_ImportErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{name: "unknown format", format: "xml", input: ""},
+		{name: "bad json", format: maintenance.FormatNDJSON, input: "{"},
+		{name: "unknown field", format: maintenance.FormatNDJSON, input: `{"hash":"a"}`},
+		{name: "invalid url", format: maintenance.FormatNDJSON, input: `{"short_url":"a","original_url":"nope"}`},
+		{name: "bad csv header", format: maintenance.FormatCSV, input: "a,b,c,d,e\n"},
+		{name: "bad created_at", format: maintenance.FormatCSV, input: "uid,short_url,original_url,is_deleted,workspace_id,domain,created_at\nu,a,http://a.com,false,,,yesterday\n"},
+		{name: "bad is_deleted", format: maintenance.FormatCSV, input: "uid,short_url,original_url,is_deleted,workspace_id\nu,a,http://a.com,maybe,\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, _ := newStorage(t)
+
+			_, err := maintenance.Import(context.Background(), st, strings.NewReader(tt.input), tt.format, false)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_ImportLegacyCSV(t *testing.T) {
+	st, _ := newStorage(t)
+	input := "uid,short_url,original_url,is_deleted,workspace_id,domain\nu,a,http://a.com,false,,\n"
+
+	res, err := maintenance.Import(context.Background(), st, strings.NewReader(input), maintenance.FormatCSV, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Imported)
+
+	u, err := st.GetURL(context.Background(), "", "a")
+	require.NoError(t, err)
+	assert.True(t, u.CreatedAt.IsZero())
+}
+
+func Test_Counts(t *testing.T) {
+	st, _ := newStorage(t)
+	seed(t, st)
+
+	counts, err := maintenance.Counts(context.Background(), st)
+	require.NoError(t, err)
+	assert.Equal(t, []maintenance.UserCount{
+		{UID: "u1", Active: 1},
+		{UID: "u2", Active: 1, Deleted: 1},
+	}, counts)
+}
+
+func Test_Copy(t *testing.T) {
+	ctx := context.Background()
+	src, _ := newStorage(t)
+	seed(t, src)
+
+	dst, path := newStorage(t)
+	require.NoError(t, dst.SaveUser(ctx, storage.User{UID: "u1", Login: "alice", PasswordHash: "other"}))
+
+	res, err := maintenance.Copy(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.URLs.Imported)
+	assert.Equal(t, 1, res.ExistingUser)
+
+	reopened := storage.InitFileStorage(nil, &config.Config{FileStoragePath: path})
+	counts, err := maintenance.Counts(ctx, reopened)
+	require.NoError(t, err)
+	assert.Equal(t, []maintenance.UserCount{
+		{UID: "u1", Active: 1},
+		{UID: "u2", Active: 1, Deleted: 1},
+	}, counts)
+}
+
+func Test_PurgeDeleted(t *testing.T) {
+	ctx := context.Background()
+	st, path := newStorage(t)
+	seed(t, st)
+
+	n, err := st.PurgeDeleted(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	reports, err := storage.VerifyFiles(path)
+	require.NoError(t, err)
+
+	for _, r := range reports {
+		assert.True(t, r.OK(), r.Path)
+	}
+
+	assert.Equal(t, 2, reports[0].Records)
+	assert.Equal(t, 0, reports[0].Superseded)
+}
+
+func Test_VerifyFiles(t *testing.T) {
+	st, path := newStorage(t)
+	seed(t, st)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString("{broken\n" + `{"short_url":"d","original_url":"not a url"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reports, err := storage.VerifyFiles(path)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+
+	urls := reports[0]
+	assert.False(t, urls.OK())
+	assert.Equal(t, 3, urls.Records)
+	assert.Equal(t, 1, urls.Superseded)
+	require.Len(t, urls.Problems, 2)
+	assert.Equal(t, 5, urls.Problems[0].Line)
+	assert.Equal(t, 6, urls.Problems[1].Line)
+
+	assert.True(t, reports[1].OK())
+	assert.Equal(t, path+".users", reports[1].Path)
+}
@@ -0,0 +1,187 @@
+// Package metrics holds Prometheus collectors of the service and the MW and storage decorator feeding them
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+// Registry holds all collectors of the service, it is served by Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequestDuration observes served requests by method, chi route pattern and status code
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of served HTTP requests by route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// LinksCreated counts shortened URLs
+	LinksCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_created_total",
+		Help:      "Number of shortened URLs.",
+	})
+
+	// LinksRedirected counts redirects to original URLs
+	LinksRedirected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_redirected_total",
+		Help:      "Number of redirects to original URLs.",
+	})
+
+	// LinksConflicts counts attempts to shorten an already shortened URL
+	LinksConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_conflicts_total",
+		Help:      "Number of attempts to shorten an already shortened URL.",
+	})
+
+	// LinksDeleted counts deletion entries flushed to the storage
+	LinksDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_deleted_total",
+		Help:      "Number of deletion entries flushed to the storage.",
+	})
+
+	// StorageOperationDuration observes storage calls by operation and result
+	StorageOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Latency of storage operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+
+	// DeletionBuffered is the number of entries buffered by the deletion consumer
+	DeletionBuffered = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deletion_buffered_entries",
+		Help:      "Deletion entries buffered by the deletion consumer.",
+	})
+
+	// DeletionPending is the number of staged entries not yet taken by the deletion consumer
+	DeletionPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deletion_pending_entries",
+		Help:      "Deletion entries staged but not yet taken by the deletion consumer.",
+	})
+
+	// BatchSize observes the number of URLs in batch shortening requests
+	BatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_size",
+		Help:      "Number of URLs in batch shortening requests.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	// CacheLookups counts redirect cache lookups by result (hit, negative_hit or miss)
+	CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Number of redirect cache lookups by result.",
+	}, []string{"result"})
+
+	// CacheEntries is the number of codes held by the redirect cache, found or not
+	CacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_entries",
+		Help:      "Codes held by the redirect cache.",
+	})
+
+	// BloomLookups counts redirect lookups checked by the bloom filter by result: rejected without a storage
+	// lookup, passed or false_positive when the storage didn't know the code either
+	BloomLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bloom_lookups_total",
+		Help:      "Number of redirect lookups checked by the bloom filter by result.",
+	}, []string{"result"})
+
+	// BloomCodes is the number of stored codes found by the last bloom filter rebuild
+	BloomCodes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bloom_codes",
+		Help:      "Stored codes found by the last bloom filter rebuild.",
+	})
+
+	// ConfigReloads counts config reload attempts by result (ok, rejected or error)
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of config reload attempts by result.",
+	}, []string{"result"})
+
+	// ConfigLastReloadSuccess is 1 if the last config reload was applied and 0 otherwise
+	ConfigLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last config reload was applied.",
+	})
+
+	// ConfigLastReloadTimestamp is the unix time of the last config reload attempt
+	ConfigLastReloadTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_timestamp_seconds",
+		Help:      "Time of the last config reload attempt.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		LinksCreated,
+		LinksRedirected,
+		LinksConflicts,
+		LinksDeleted,
+		StorageOperationDuration,
+		DeletionBuffered,
+		DeletionPending,
+		BatchSize,
+		CacheLookups,
+		CacheEntries,
+		BloomLookups,
+		BloomCodes,
+		ConfigReloads,
+		ConfigLastReloadSuccess,
+		ConfigLastReloadTimestamp,
+	)
+}
+
+// Handler serves Registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware observes request latencies labelled by the chi route pattern, so path params don't blow up cardinality
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		HTTPRequestDuration.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,54 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/metrics"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Metrics(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(metrics.Middleware)
+	router.Get("/{urlHash}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	router.Handle("/metrics", metrics.Handler())
+
+	t.Run("requests are labelled by route pattern", func(t *testing.T) {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/e62e2446", nil))
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/16358727", nil))
+
+		count := testutil.CollectAndCount(metrics.HTTPRequestDuration, "shortener_http_request_duration_seconds")
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("storage operations are observed", func(t *testing.T) {
+		st := metrics.InstrumentStorage(storage.InitFileStorage(nil, &config.Config{}))
+
+		_, err := st.GetUserByLogin(context.Background(), "nobody")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+
+		assert.NoError(t, st.SaveURL(context.Background(), storage.URL{ShortURL: "e62e2446", URL: "https://youtube.com"}))
+	})
+
+	t.Run("metrics are served in the text format", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		body := w.Body.String()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.Contains(body, `shortener_http_request_duration_seconds_count{method="GET",route="/{urlHash}",status="307"} 2`))
+		assert.True(t, strings.Contains(body, `shortener_storage_operation_duration_seconds_count{operation="GetUserByLogin",result="not_found"} 1`))
+		assert.True(t, strings.Contains(body, `shortener_storage_operation_duration_seconds_count{operation="SaveURL",result="ok"} 1`))
+	})
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// instrumentedStorage observes the latency and result of every storage call
+type instrumentedStorage struct {
+	storage.Storage
+}
+
+// InstrumentStorage wraps st so that its operations are observed by StorageOperationDuration
+func InstrumentStorage(st storage.Storage) storage.Storage {
+	return &instrumentedStorage{st}
+}
+
+func observe(op string, start time.Time, err error) {
+	result := "ok"
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	}
+
+	StorageOperationDuration.WithLabelValues(op, result).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStorage) SaveURL(ctx context.Context, u storage.URL) (err error) {
+	defer func(start time.Time) { observe("SaveURL", start, err) }(time.Now())
+	return s.Storage.SaveURL(ctx, u)
+}
+
+func (s *instrumentedStorage) GetURL(ctx context.Context, domain, hash string) (u storage.URL, err error) {
+	defer func(start time.Time) { observe("GetURL", start, err) }(time.Now())
+	return s.Storage.GetURL(ctx, domain, hash)
+}
+
+func (s *instrumentedStorage) GetUrlsByUID(ctx context.Context, uid string) (urls []storage.URL, err error) {
+	defer func(start time.Time) { observe("GetUrlsByUID", start, err) }(time.Now())
+	return s.Storage.GetUrlsByUID(ctx, uid)
+}
+
+func (s *instrumentedStorage) QueryURLs(ctx context.Context, q storage.URLQuery) (urls []storage.URL, err error) {
+	defer func(start time.Time) { observe("QueryURLs", start, err) }(time.Now())
+	return s.Storage.QueryURLs(ctx, q)
+}
+
+func (s *instrumentedStorage) IsAlive(ctx context.Context) (ok bool, err error) {
+	defer func(start time.Time) { observe("IsAlive", start, err) }(time.Now())
+	return s.Storage.IsAlive(ctx)
+}
+
+func (s *instrumentedStorage) IsWritable(ctx context.Context) (ok bool, err error) {
+	defer func(start time.Time) { observe("IsWritable", start, err) }(time.Now())
+	return s.Storage.IsWritable(ctx)
+}
+
+func (s *instrumentedStorage) CheckSchema(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("CheckSchema", start, err) }(time.Now())
+	return s.Storage.CheckSchema(ctx)
+}
+
+func (s *instrumentedStorage) BatchSaveURL(ctx context.Context, urls []storage.URL) (err error) {
+	defer func(start time.Time) { observe("BatchSaveURL", start, err) }(time.Now())
+	return s.Storage.BatchSaveURL(ctx, urls)
+}
+
+func (s *instrumentedStorage) DeleteURLs(ctx context.Context, entries []storage.DeletionEntry) (deleted []storage.URL, err error) {
+	defer func(start time.Time) { observe("DeleteURLs", start, err) }(time.Now())
+	return s.Storage.DeleteURLs(ctx, entries)
+}
+
+func (s *instrumentedStorage) SaveUser(ctx context.Context, u storage.User) (err error) {
+	defer func(start time.Time) { observe("SaveUser", start, err) }(time.Now())
+	return s.Storage.SaveUser(ctx, u)
+}
+
+func (s *instrumentedStorage) GetUserByLogin(ctx context.Context, login string) (u storage.User, err error) {
+	defer func(start time.Time) { observe("GetUserByLogin", start, err) }(time.Now())
+	return s.Storage.GetUserByLogin(ctx, login)
+}
+
+func (s *instrumentedStorage) GetUserByUID(ctx context.Context, uid string) (u storage.User, err error) {
+	defer func(start time.Time) { observe("GetUserByUID", start, err) }(time.Now())
+	return s.Storage.GetUserByUID(ctx, uid)
+}
+
+func (s *instrumentedStorage) UpdateUser(ctx context.Context, u storage.User) (err error) {
+	defer func(start time.Time) { observe("UpdateUser", start, err) }(time.Now())
+	return s.Storage.UpdateUser(ctx, u)
+}
+
+func (s *instrumentedStorage) ReassignURLs(ctx context.Context, fromUID, toUID string) (n int, err error) {
+	defer func(start time.Time) { observe("ReassignURLs", start, err) }(time.Now())
+	return s.Storage.ReassignURLs(ctx, fromUID, toUID)
+}
+
+func (s *instrumentedStorage) CreateWorkspace(ctx context.Context, ws storage.Workspace, ownerUID string) (err error) {
+	defer func(start time.Time) { observe("CreateWorkspace", start, err) }(time.Now())
+	return s.Storage.CreateWorkspace(ctx, ws, ownerUID)
+}
+
+func (s *instrumentedStorage) GetWorkspacesByUID(ctx context.Context, uid string) (ws []storage.Workspace, err error) {
+	defer func(start time.Time) { observe("GetWorkspacesByUID", start, err) }(time.Now())
+	return s.Storage.GetWorkspacesByUID(ctx, uid)
+}
+
+func (s *instrumentedStorage) GetMembers(ctx context.Context, workspaceID string) (m []storage.Member, err error) {
+	defer func(start time.Time) { observe("GetMembers", start, err) }(time.Now())
+	return s.Storage.GetMembers(ctx, workspaceID)
+}
+
+func (s *instrumentedStorage) GetRole(ctx context.Context, workspaceID, uid string) (role string, err error) {
+	defer func(start time.Time) { observe("GetRole", start, err) }(time.Now())
+	return s.Storage.GetRole(ctx, workspaceID, uid)
+}
+
+func (s *instrumentedStorage) SetMember(ctx context.Context, m storage.Member) (err error) {
+	defer func(start time.Time) { observe("SetMember", start, err) }(time.Now())
+	return s.Storage.SetMember(ctx, m)
+}
+
+func (s *instrumentedStorage) RemoveMember(ctx context.Context, workspaceID, uid string) (err error) {
+	defer func(start time.Time) { observe("RemoveMember", start, err) }(time.Now())
+	return s.Storage.RemoveMember(ctx, workspaceID, uid)
+}
+
+func (s *instrumentedStorage) GetUrlsByWorkspace(ctx context.Context, workspaceID string) (urls []storage.URL, err error) {
+	defer func(start time.Time) { observe("GetUrlsByWorkspace", start, err) }(time.Now())
+	return s.Storage.GetUrlsByWorkspace(ctx, workspaceID)
+}
+
+func (s *instrumentedStorage) ForEachURL(ctx context.Context, fn func(storage.URL) error) (err error) {
+	defer func(start time.Time) { observe("ForEachURL", start, err) }(time.Now())
+	return s.Storage.ForEachURL(ctx, fn)
+}
+
+func (s *instrumentedStorage) ForEachUserURL(ctx context.Context, uid string, fn func(storage.URL) error) (err error) {
+	defer func(start time.Time) { observe("ForEachUserURL", start, err) }(time.Now())
+	return s.Storage.ForEachUserURL(ctx, uid, fn)
+}
+
+func (s *instrumentedStorage) ForEachUser(ctx context.Context, fn func(storage.User) error) (err error) {
+	defer func(start time.Time) { observe("ForEachUser", start, err) }(time.Now())
+	return s.Storage.ForEachUser(ctx, fn)
+}
+
+func (s *instrumentedStorage) PurgeDeleted(ctx context.Context) (n int, err error) {
+	defer func(start time.Time) { observe("PurgeDeleted", start, err) }(time.Now())
+	return s.Storage.PurgeDeleted(ctx)
+}
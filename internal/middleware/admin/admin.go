@@ -0,0 +1,103 @@
+// Package admin contains a middleware restricting access to administrative endpoints
+package admin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+)
+
+// IsAdmin reports whether the request comes from an admin: either its uid is listed in cfg.AdminUIDs
+// or it carries cfg.AdminToken as a bearer token
+func IsAdmin(r *http.Request, cfg *config.Config) bool {
+	if cfg.AdminToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1 {
+			return true
+		}
+	}
+
+	uid, _ := r.Context().Value(auth.UIDKey{}).(string)
+	if uid == "" {
+		return false
+	}
+
+	for _, adminUID := range cfg.AdminUIDs {
+		if uid == adminUID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InitAdmin creates a MW that responds with 403 to everyone but admins
+func InitAdmin(cfg config.Provider) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IsAdmin(r, cfg.Get()) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// InitTrusted creates a MW letting through admins (see IsAdmin) and clients connecting from cfg.TrustedSubnet,
+// everyone else gets 403. Only the connection address is checked, forwarding headers are not trusted.
+func InitTrusted(cfg config.Provider) (func(next http.Handler) http.Handler, error) {
+	if _, err := trustedSubnet(cfg.Get()); err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := cfg.Get()
+			subnet, _ := trustedSubnet(c)
+
+			if !inSubnet(r, subnet) && !IsAdmin(r, c) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// trustedSubnet parses cfg.TrustedSubnet, nil means no subnet is trusted
+func trustedSubnet(cfg *config.Config) (*net.IPNet, error) {
+	if cfg.TrustedSubnet == "" {
+		return nil, nil
+	}
+
+	_, subnet, err := net.ParseCIDR(cfg.TrustedSubnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted subnet: %w", err)
+	}
+
+	return subnet, nil
+}
+
+// inSubnet reports whether the request connection comes from subnet, a nil subnet contains nothing
+func inSubnet(r *http.Request, subnet *net.IPNet) bool {
+	if subnet == nil {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && subnet.Contains(ip)
+}
@@ -0,0 +1,54 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/middleware/admin"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_InitTrusted(t *testing.T) {
+	cfg := &config.Config{TrustedSubnet: "10.0.0.0/8", AdminToken: "token"}
+
+	trusted, err := admin.InitTrusted(cfg)
+	assert.NoError(t, err)
+
+	handler := trusted(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		wantStatus int
+	}{
+		{name: "trusted subnet", remoteAddr: "10.1.2.3:5555", wantStatus: http.StatusOK},
+		{name: "outside subnet", remoteAddr: "192.168.0.1:5555", wantStatus: http.StatusForbidden},
+		{name: "forwarding headers are ignored", remoteAddr: "192.168.0.1:5555", header: map[string]string{"X-Real-IP": "10.1.2.3"}, wantStatus: http.StatusForbidden},
+		{name: "admin token", remoteAddr: "192.168.0.1:5555", header: map[string]string{"Authorization": "Bearer token"}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = tt.remoteAddr
+
+			for k, v := range tt.header {
+				request.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+
+	t.Run("invalid subnet", func(t *testing.T) {
+		_, err := admin.InitTrusted(&config.Config{TrustedSubnet: "10.0.0.0"})
+		assert.Error(t, err)
+	})
+}
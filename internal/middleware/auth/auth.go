@@ -6,9 +6,13 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/logger"
 )
 
 // UIDKey ensures a user UID will be safe in the user context and won't be re-written by other layers
@@ -24,18 +28,67 @@ func generateRandom(size int) ([]byte, error) {
 	return b, nil
 }
 
-func isValidCookie(c *http.Cookie, key string) (bool, error) {
+// CookieName is the name of the cookie carrying a signed user uid
+const CookieName = "auth_token"
+
+// uidOffset is where the uid starts in the hex cookie value
+const uidOffset = 32
+
+// sessionSeparator separates the uid, the session version and its signature in an account session token
+const sessionSeparator = "."
+
+// sessionContext separates session signatures from other signatures made with the same key
+const sessionContext = "session"
+
+// noSession is the version of a token issued without a session
+const noSession = -1
+
+// Sessions tells accounts apart from anonymous uids and returns the current session version of an account.
+// Tokens of an account are only accepted with its current session version, so bumping it revokes them.
+type Sessions interface {
+	SessionVersion(ctx context.Context, uid string) (version int, isAccount bool, err error)
+}
+
+// ErrInvalidToken is returned when a token is malformed or its signature doesn't match
+var ErrInvalidToken = errors.New("invalid auth token")
+
+// isValidCookie reports whether c is signed with any of keys
+func isValidCookie(c *http.Cookie, keys ...string) (bool, error) {
 	value, err := hex.DecodeString(c.Value)
 	if err != nil {
 		return false, err
 	}
-	signature := value[:32]
 
+	if len(value) <= sha256.Size {
+		return false, nil
+	}
+
+	signature := value[:sha256.Size]
+
+	for _, key := range keys {
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write(value[sha256.Size:])
+
+		if hmac.Equal(h.Sum(nil), signature) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func signCookie(payload []byte, key string) *http.Cookie {
 	h := hmac.New(sha256.New, []byte(key))
-	h.Write(value[32:])
-	dst := h.Sum(nil)
+	h.Write(payload)
+	signature := h.Sum(nil)
 
-	return hmac.Equal(dst, signature), nil
+	cookieVal := append(signature, payload...)
+
+	return &http.Cookie{
+		Name:  CookieName,
+		Value: hex.EncodeToString(cookieVal),
+		Path:  "/",
+	}
 }
 
 func generateCookie(key string) (c *http.Cookie, err error) {
@@ -44,65 +97,153 @@ func generateCookie(key string) (c *http.Cookie, err error) {
 		return nil, err
 	}
 
+	return signCookie(uid, key), nil
+}
+
+// NewCookie generates a new anonymous uid and returns a signed cookie carrying it together with the uid
+func NewCookie(key string) (*http.Cookie, string, error) {
+	c, err := generateCookie(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return c, c.Value[uidOffset:], nil
+}
+
+// CookieForUID re-creates the signed cookie for a uid previously issued by this middleware under one of keys.
+// Since the uid includes part of the signature, the cookie is signed with the key it was issued under.
+func CookieForUID(uid string, keys ...string) (*http.Cookie, error) {
+	if len(uid) <= uidOffset {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := hex.DecodeString(uid[uidOffset:])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	for _, key := range keys {
+		if c := signCookie(payload, key); c.Value[uidOffset:] == uid {
+			return c, nil
+		}
+	}
+
+	return nil, ErrInvalidToken
+}
+
+// signSession signs a uid together with a session version
+func signSession(uid string, version int, key string) string {
 	h := hmac.New(sha256.New, []byte(key))
-	h.Write(uid)
-	signature := h.Sum(nil)
+	h.Write([]byte(sessionContext + sessionSeparator + uid + sessionSeparator + strconv.Itoa(version)))
 
-	cookieVal := append(signature, uid...)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SessionCookie returns the cookie of an account session: the uid, the session version and their signature
+// made with the first of keys. It doesn't carry the uid cookie, so a leaked session can't be turned into one.
+func SessionCookie(uid string, version int, keys ...string) (*http.Cookie, error) {
+	if _, err := CookieForUID(uid, keys...); err != nil {
+		return nil, err
+	}
 
 	return &http.Cookie{
-		Name:  "auth_token",
-		Value: hex.EncodeToString(cookieVal),
+		Name:  CookieName,
+		Value: uid + sessionSeparator + strconv.Itoa(version) + sessionSeparator + signSession(uid, version, keys[0]),
 		Path:  "/",
 	}, nil
+}
+
+// parseToken checks the signature of a raw auth token against keys and returns the uid it carries
+// and its session version, noSession if it's a uid cookie rather than a session
+func parseToken(token string, keys ...string) (uid string, version int, isValid bool, err error) {
+	uid, session, isSession := strings.Cut(token, sessionSeparator)
+	if !isSession {
+		isValid, err = isValidCookie(&http.Cookie{Name: CookieName, Value: token}, keys...)
+		if err != nil || !isValid {
+			return "", noSession, false, err
+		}
+
+		return token[uidOffset:], noSession, true, nil
+	}
+
+	rawVersion, signature, _ := strings.Cut(session, sessionSeparator)
+
+	version, err = strconv.Atoi(rawVersion)
+	if err != nil || version < 0 {
+		return "", noSession, false, nil
+	}
+
+	for _, key := range keys {
+		if hmac.Equal([]byte(signSession(uid, version, key)), []byte(signature)) {
+			return uid, version, true, nil
+		}
+	}
+
+	return "", noSession, false, nil
+}
+
+// UIDFromToken checks the signature of a raw auth token (a cookie value) against keys and returns the uid it carries.
+// The session version of an account token isn't checked.
+func UIDFromToken(token string, keys ...string) (string, error) {
+	uid, _, isValid, err := parseToken(token, keys...)
+	if err != nil || !isValid {
+		return "", ErrInvalidToken
+	}
 
+	return uid, nil
 }
 
-// InitAuth creates a MW that parses an incoming request's cookie and tries to extract UID stored in the extracted data.
+// InitAuth creates the auth MW without sessions, see New. Account tokens can't be revoked by it.
+func InitAuth(cfg config.Provider) func(next http.Handler) http.Handler {
+	return New(cfg, nil)
+}
+
+// New creates a MW that parses an incoming request's cookie and tries to extract UID stored in the extracted data.
 // In case there is no cookie available or it is available but invalid, the auth mw generates a new UID and cookie and sets it to the
-// Context.
-func InitAuth(cfg *config.Config) func(next http.Handler) http.Handler {
+// Context. New cookies are signed with the current secret key, cookies signed with previous keys stay valid.
+// If sessions are given, a session cookie is only valid with the current session version of its account,
+// otherwise it is treated as an invalid one. Uid cookies aren't looked up, so the anonymous cookie an account
+// was registered with keeps its uid; logout and password changes only revoke sessions.
+func New(cfg config.Provider, sessions Sessions) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("auth_token")
-			if err != nil {
-				newCookie, err := generateCookie(cfg.SecretKey)
+			cfg := cfg.Get()
+
+			uid, version, isValid := "", noSession, false
+
+			if cookie, err := r.Cookie(CookieName); err == nil {
+				uid, version, isValid, err = parseToken(cookie.Value, cfg.SigningKeys()...)
 				if err != nil {
-					http.Error(w, "Something went wrong", http.StatusBadRequest)
+					http.Error(w, "Can't validate the token", http.StatusInternalServerError)
 					return
 				}
+			}
 
-				uid := newCookie.Value[32:]
-				ctx := context.WithValue(r.Context(), UIDKey{}, uid)
+			if isValid && version != noSession && sessions != nil {
+				current, isAccount, err := sessions.SessionVersion(r.Context(), uid)
+				if err != nil {
+					logger.FromContext(r.Context()).Error("checking the session", "error", err)
+					http.Error(w, "Can't validate the token", http.StatusInternalServerError)
 
-				http.SetCookie(w, newCookie)
-				next.ServeHTTP(w, r.WithContext(ctx))
-				return
-			}
+					return
+				}
 
-			isValid, err := isValidCookie(cookie, cfg.SecretKey)
-			if err != nil {
-				http.Error(w, "Can't validate the token", http.StatusInternalServerError)
-				return
+				isValid = isAccount && version == current
 			}
 
-			var ctx context.Context
-			if isValid {
-				hexValue := cookie.Value
-				ctx = context.WithValue(r.Context(), UIDKey{}, hexValue[32:])
-			} else {
+			if !isValid {
 				newCookie, err := generateCookie(cfg.SecretKey)
 				if err != nil {
 					http.Error(w, "Something went wrong", http.StatusBadRequest)
 					return
 				}
 
-				uid := newCookie.Value[32:]
-				ctx = context.WithValue(r.Context(), UIDKey{}, uid)
+				uid = newCookie.Value[uidOffset:]
 
 				http.SetCookie(w, newCookie)
 			}
 
+			ctx := context.WithValue(logger.Annotate(r.Context(), "uid", uid), UIDKey{}, uid)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -2,11 +2,14 @@ package auth_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/T-V-N/gourlshortener/internal/app"
@@ -17,6 +20,7 @@ import (
 	"github.com/caarlos0/env/v6"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func InitTestConfig() (*config.Config, error) {
@@ -96,3 +100,176 @@ func Test_AuthHandler(t *testing.T) {
 		assert.Equal(t, http.StatusNoContent, w.Code)
 	})
 }
+
+func Test_KeyRotation(t *testing.T) {
+	cfg := &config.Config{SecretKey: "old-secret"}
+	live := config.NewLive(cfg, nil)
+
+	var gotUID string
+
+	h := auth.InitAuth(live)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUID = r.Context().Value(auth.UIDKey{}).(string)
+	}))
+
+	oldCookie, oldUID, err := auth.NewCookie("old-secret")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		cfg        *config.Config
+		wantSameID bool
+	}{
+		{name: "current key", cfg: &config.Config{SecretKey: "old-secret"}, wantSameID: true},
+		{name: "rotated with previous key kept", cfg: &config.Config{SecretKey: "new-secret", PreviousSecretKeys: []string{"old-secret"}}, wantSameID: true},
+		{name: "previous key dropped", cfg: &config.Config{SecretKey: "new-secret"}, wantSameID: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*cfg = *tt.cfg
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.AddCookie(oldCookie)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.wantSameID, gotUID == oldUID)
+
+			c, err := auth.CookieForUID(oldUID, tt.cfg.SigningKeys()...)
+			if tt.wantSameID {
+				assert.NoError(t, err)
+				assert.Equal(t, oldCookie.Value, c.Value)
+			} else {
+				assert.ErrorIs(t, err, auth.ErrInvalidToken)
+			}
+		})
+	}
+}
+
+// countingSessions counts session lookups
+type countingSessions struct {
+	auth.Sessions
+	lookups atomic.Int32
+}
+
+func (s *countingSessions) SessionVersion(ctx context.Context, uid string) (int, bool, error) {
+	s.lookups.Add(1)
+	return s.Sessions.SessionVersion(ctx, uid)
+}
+
+func Test_Sessions(t *testing.T) {
+	cfg := &config.Config{SecretKey: "secret"}
+	st := storage.InitStorage(map[string]storage.URL{}, cfg)
+	a := app.NewApp(st, cfg)
+	a.Init()
+	hn := handler.InitHandler(a)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", hn.HandleRegister)
+	mux.HandleFunc("/login", hn.HandleLogin)
+	mux.HandleFunc("/logout", hn.HandleLogout)
+	mux.HandleFunc("/password", hn.HandleChangePassword)
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Context().Value(auth.UIDKey{}).(string)))
+	})
+
+	sessions := &countingSessions{Sessions: a}
+	h := auth.New(cfg, sessions)(mux)
+
+	do := func(path string, cookie *http.Cookie, body interface{}) *httptest.ResponseRecorder {
+		buf := bytes.NewBuffer([]byte{})
+		_ = json.NewEncoder(buf).Encode(body)
+
+		request := httptest.NewRequest(http.MethodPost, path, buf)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+
+		return w
+	}
+
+	// the handler's cookie overrides the one issued by the middleware
+	lastCookie := func(w *httptest.ResponseRecorder) *http.Cookie {
+		cookies := w.Result().Cookies()
+		require.NotEmpty(t, cookies)
+
+		return cookies[len(cookies)-1]
+	}
+
+	whoami := func(cookie *http.Cookie) string {
+		return do("/whoami", cookie, nil).Body.String()
+	}
+
+	login := func(password string) *http.Cookie {
+		w := do("/login", nil, handler.Credentials{Login: "alice", Password: password})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		return lastCookie(w)
+	}
+
+	anonCookie, uid, err := auth.NewCookie(cfg.SecretKey)
+	require.NoError(t, err)
+	assert.Equal(t, uid, whoami(anonCookie))
+
+	t.Run("register keeps the caller signed in", func(t *testing.T) {
+		w := do("/register", anonCookie, handler.Credentials{Login: "alice", Password: "long enough"})
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		session := lastCookie(w)
+		assert.NotEqual(t, anonCookie.Value, session.Value)
+		assert.Equal(t, uid, whoami(session))
+	})
+
+	t.Run("only sessions are looked up", func(t *testing.T) {
+		before := sessions.lookups.Load()
+		assert.Equal(t, uid, whoami(anonCookie))
+		assert.Equal(t, before, sessions.lookups.Load())
+
+		assert.Equal(t, uid, whoami(login("long enough")))
+		assert.Equal(t, before+1, sessions.lookups.Load())
+	})
+
+	t.Run("logout revokes every session", func(t *testing.T) {
+		first, second := login("long enough"), login("long enough")
+		assert.Equal(t, uid, whoami(first))
+		assert.Equal(t, uid, whoami(second))
+
+		w := do("/logout", first, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, uid, whoami(lastCookie(w)))
+
+		assert.NotEqual(t, uid, whoami(first))
+		assert.NotEqual(t, uid, whoami(second))
+		assert.Equal(t, uid, whoami(login("long enough")))
+	})
+
+	t.Run("password change revokes other sessions", func(t *testing.T) {
+		session := login("long enough")
+
+		w := do("/password", session, handler.PasswordChange{OldPassword: "long enough", NewPassword: "even longer"})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		assert.NotEqual(t, uid, whoami(session))
+		assert.Equal(t, uid, whoami(lastCookie(w)))
+	})
+
+	t.Run("forged session version", func(t *testing.T) {
+		session := login("even longer")
+
+		parts := strings.Split(session.Value, ".")
+		require.Len(t, parts, 3)
+
+		forged := &http.Cookie{Name: auth.CookieName, Value: parts[0] + ".0." + parts[2]}
+		assert.NotEqual(t, uid, whoami(forged))
+	})
+
+	t.Run("uid of a session token", func(t *testing.T) {
+		got, err := auth.UIDFromToken(login("even longer").Value, cfg.SecretKey)
+		assert.NoError(t, err)
+		assert.Equal(t, uid, got)
+	})
+}
@@ -0,0 +1,66 @@
+// Package chain assembles the MWs every route of the service is served through
+package chain
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/T-V-N/gourlshortener/internal/audit"
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/handler"
+	"github.com/T-V-N/gourlshortener/internal/metrics"
+	"github.com/T-V-N/gourlshortener/internal/middleware/gzip"
+	"github.com/T-V-N/gourlshortener/internal/middleware/logging"
+	"github.com/T-V-N/gourlshortener/internal/tlsutil"
+	"github.com/T-V-N/gourlshortener/internal/tracing"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// compressLevel is the compression level of responses
+const compressLevel = 5
+
+// Options are the parts of the chain created elsewhere
+type Options struct {
+	Logger *slog.Logger                         // base of request-scoped loggers
+	Config *config.Config                       // config the service was started with
+	Auth   func(next http.Handler) http.Handler // auth MW, see auth.New
+}
+
+// New returns the MWs in the order they are applied to a request
+func New(opts Options) []func(next http.Handler) http.Handler {
+	return []func(next http.Handler) http.Handler{
+		logging.RequestLogger(opts.Logger),
+		tracing.Middleware,
+		tlsutil.HSTS(opts.Config.HSTSMaxAge),
+		metrics.Middleware,
+		gzip.New(gzip.Options{
+			MaxCompressedSize:   opts.Config.MaxBodySize,
+			MaxDecompressedSize: opts.Config.MaxDecompressedBodySize,
+			MaxStreamSize:       opts.Config.MaxStreamBodySize,
+			IsStream:            handler.IsStream,
+		}),
+		opts.Auth,
+		audit.Middleware,
+		Compress(compressLevel, handler.IsStream),
+	}
+}
+
+// Compress compresses responses like middleware.Compress, except for requests skip reports.
+// Its writer can't be unwrapped, so a handler behind it can't enable full duplex and stops
+// reading an HTTP/1 body once its response is flushed; streamed requests must skip it.
+func Compress(level int, skip func(r *http.Request) bool) func(next http.Handler) http.Handler {
+	compress := middleware.Compress(level)
+
+	return func(next http.Handler) http.Handler {
+		compressed := compress(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			compressed.ServeHTTP(w, r)
+		})
+	}
+}
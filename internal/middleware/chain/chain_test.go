@@ -0,0 +1,40 @@
+package chain_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/middleware/chain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Compress(t *testing.T) {
+	h := chain.Compress(5, func(r *http.Request) bool { return r.URL.Path == "/stream" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(strings.Repeat("compressible ", 100)))
+	}))
+
+	tests := []struct {
+		name         string
+		path         string
+		wantEncoding string
+	}{
+		{name: "compressed", path: "/", wantEncoding: "gzip"},
+		{name: "skipped", path: "/stream", wantEncoding: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			request.Header.Set("Accept-Encoding", "gzip")
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.wantEncoding, w.Header().Get("Content-Encoding"))
+		})
+	}
+}
@@ -1,53 +1,198 @@
+// Package gzip contains a MW decoding compressed request bodies (gzip, deflate and zstd)
 package gzip
 
 import (
 	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
 )
 
-// Gzip struct is designed to comply with the mighty devs' requirement to close underlying Reader
-// Gzip.reader doesn't close anything by default ;(
-type Gzip struct {
-	GzipReader gzip.Reader
-	Body       io.ReadCloser
+// SupportedEncodings lists request content encodings the MW can decode
+const SupportedEncodings = "gzip, deflate, zstd"
+
+// zstdMaxMemory bounds the memory a single zstd stream may allocate for its window
+const zstdMaxMemory = 64 << 20
+
+// Options limit request bodies. Zero limits mean unlimited.
+type Options struct {
+	MaxCompressedSize   int64                      // limit of the request body as it was sent
+	MaxDecompressedSize int64                      // limit of the decoded request body
+	MaxStreamSize       int64                      // limit of a streamed body as sent and decoded, replaces the limits above
+	IsStream            func(r *http.Request) bool // reports requests whose bodies are streamed, none if nil
+}
+
+// limits returns the compressed and decompressed body limits of r
+func (opts Options) limits(r *http.Request) (compressed, decompressed int64) {
+	if opts.IsStream != nil && opts.IsStream(r) {
+		return opts.MaxStreamSize, opts.MaxStreamSize
+	}
+
+	return opts.MaxCompressedSize, opts.MaxDecompressedSize
 }
 
-// Close allows to close both gzip reader and the underlying reader
-func (g *Gzip) Close() error {
-	err := g.GzipReader.Close()
+var (
+	gzipPool sync.Pool
+	zlibPool sync.Pool
+	zstdPool sync.Pool
+)
+
+// decoder is a pooled decompressing reader which closes the underlying request body as well
+type decoder struct {
+	io.Reader
+	body    io.ReadCloser
+	release func()
+}
 
-	if err != nil {
-		return err
+// Close returns the decompressor to its pool and closes the request body
+func (d *decoder) Close() error {
+	if d.release != nil {
+		d.release()
+		d.release = nil
 	}
 
-	err = g.Body.Close()
+	return d.body.Close()
+}
 
-	return err
+// limitedReader fails with *http.MaxBytesError once more than limit bytes are read, so handlers can
+// tell an oversized decoded body from a malformed one the same way they do for http.MaxBytesReader
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
 }
 
-// Read just allows one to read info from the reader
-func (g *Gzip) Read(p []byte) (int, error) {
-	return g.GzipReader.Read(p)
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, &http.MaxBytesError{Limit: l.limit}
+	}
+
+	if int64(len(p)) > l.limit-l.read+1 {
+		p = p[:l.limit-l.read+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	if l.read > l.limit {
+		return n - int(l.read-l.limit), &http.MaxBytesError{Limit: l.limit}
+	}
+
+	return n, err
 }
 
-// GzipHandle returns a MW that reads the request encoding and if it is set to gzip, replaces a regular request body with a gzip-supporting implementation
-func GzipHandle(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
-			gzipReader, err := gzip.NewReader(r.Body)
+// newDecoder returns a decoder for the encoding reading from body. Unknown encodings return ok == false.
+func newDecoder(encoding string, body io.ReadCloser) (d *decoder, ok bool, err error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, _ := gzipPool.Get().(*gzip.Reader)
+		if gz == nil {
+			gz, err = gzip.NewReader(body)
+		} else {
+			err = gz.Reset(body)
+		}
+
+		if err != nil {
+			return nil, true, err
+		}
+
+		return &decoder{gz, body, func() { gzipPool.Put(gz) }}, true, nil
+	case "deflate":
+		zr, _ := zlibPool.Get().(io.ReadCloser)
+		if zr == nil {
+			zr, err = zlib.NewReader(body)
+		} else {
+			err = zr.(zlib.Resetter).Reset(body, nil)
+		}
+
+		if err != nil {
+			return nil, true, err
+		}
+
+		return &decoder{zr, body, func() { zlibPool.Put(zr) }}, true, nil
+	case "zstd":
+		zr, _ := zstdPool.Get().(*zstd.Decoder)
+		if zr == nil {
+			zr, err = zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(zstdMaxMemory))
+		} else {
+			err = zr.Reset(body)
+		}
+
+		if err != nil {
+			return nil, true, err
+		}
+
+		return &decoder{zr, body, func() {
+			_ = zr.Reset(nil)
+			zstdPool.Put(zr)
+		}}, true, nil
+	}
+
+	return nil, false, nil
+}
 
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+// New returns a MW that limits request bodies and, if the request content encoding is gzip, deflate or zstd,
+// replaces a regular request body with a decoding one.
+// HTTP response codes:
+//
+//	400 - the compressed body header is corrupt
+//	413 - the body is larger than allowed
+//	415 - the content encoding is not supported
+func New(opts Options) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			maxCompressed, maxDecompressed := opts.limits(r)
+
+			if maxCompressed > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, maxCompressed)
+			}
+
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" {
+				next.ServeHTTP(w, r)
 				return
 			}
-			gz := Gzip{*gzipReader, r.Body}
-			defer gz.Close()
 
-			r.Body = &gz
-		}
+			d, ok, err := newDecoder(encoding, r.Body)
+			if !ok {
+				w.Header().Set("Accept-Encoding", SupportedEncodings)
+				http.Error(w, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			var maxBytesErr *http.MaxBytesError
+
+			switch {
+			case errors.As(err, &maxBytesErr):
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			case err != nil:
+				http.Error(w, "Malformed compressed body", http.StatusBadRequest)
+				return
+			}
+
+			defer d.Close()
+
+			if maxDecompressed > 0 {
+				d.Reader = &limitedReader{r: d.Reader, limit: maxDecompressed}
+			}
+
+			r.Body = d
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GzipHandle is the MW without body limits, see New
+func GzipHandle(next http.Handler) http.Handler {
+	return New(Options{})(next)
 }
@@ -3,7 +3,10 @@ package gzip_test
 import (
 	"bytes"
 	gzip "compress/gzip"
+	"compress/zlib"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -14,6 +17,7 @@ import (
 	gzipMw "github.com/T-V-N/gourlshortener/internal/middleware/gzip"
 	"github.com/T-V-N/gourlshortener/internal/storage"
 	"github.com/caarlos0/env/v6"
+	"github.com/klauspost/compress/zstd"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -81,5 +85,134 @@ func Test_GzipHandle(t *testing.T) {
 		assert.Equal(t, string(resBody), "http://localhost:8080/99999ebc")
 		assert.Equal(t, http.StatusCreated, w.Code)
 	})
+}
+
+func gzipBytes(data []byte) []byte {
+	buf := bytes.Buffer{}
+	wr := gzip.NewWriter(&buf)
+	wr.Write(data)
+	wr.Close()
+
+	return buf.Bytes()
+}
+
+func Test_DecodingLimits(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "broken", http.StatusBadRequest)
+			return
+		}
+
+		w.Write(body)
+	})
+
+	deflated := bytes.Buffer{}
+	zw := zlib.NewWriter(&deflated)
+	zw.Write([]byte("https://deflate.example"))
+	zw.Close()
+
+	zstdEncoder, _ := zstd.NewWriter(nil)
+	zstded := zstdEncoder.EncodeAll([]byte("https://zstd.example"), nil)
+
+	bomb := gzipBytes(bytes.Repeat([]byte{'a'}, 1<<20))
+	corrupt := gzipBytes([]byte("https://corrupt.example"))
+	corrupt[0] = 0
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		statusCode int
+		response   string
+	}{
+		{"gzip", "gzip", gzipBytes([]byte("https://gzip.example")), http.StatusOK, "https://gzip.example"},
+		{"deflate", "deflate", deflated.Bytes(), http.StatusOK, "https://deflate.example"},
+		{"zstd", "zstd", zstded, http.StatusOK, "https://zstd.example"},
+		{"identity", "identity", []byte("https://plain.example"), http.StatusOK, "https://plain.example"},
+		{"gzip bomb", "gzip", bomb, http.StatusRequestEntityTooLarge, ""},
+		{"body too large as sent", "", bytes.Repeat([]byte{'a'}, 8<<10), http.StatusRequestEntityTooLarge, ""},
+		{"corrupt header", "gzip", corrupt, http.StatusBadRequest, ""},
+		{"truncated stream", "gzip", gzipBytes([]byte("https://truncated.example"))[:20], http.StatusBadRequest, ""},
+		{"unsupported encoding", "br", []byte("whatever"), http.StatusUnsupportedMediaType, ""},
+	}
+
+	mw := gzipMw.New(gzipMw.Options{MaxCompressedSize: 4 << 10, MaxDecompressedSize: 64 << 10})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(tt.body))
+			request.Header.Set("Content-Encoding", tt.encoding)
+
+			w := httptest.NewRecorder()
+			mw(echo).ServeHTTP(w, request)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+
+			if tt.response != "" {
+				assert.Equal(t, tt.response, w.Body.String())
+			}
+		})
+	}
+	streamMw := gzipMw.New(gzipMw.Options{MaxCompressedSize: 4 << 10, MaxDecompressedSize: 64 << 10, MaxStreamSize: 16 << 10, IsStream: func(r *http.Request) bool {
+		return r.URL.Path == "/stream"
+	}})
+
+	streams := []struct {
+		name       string
+		body       []byte
+		statusCode int
+	}{
+		{"stream over the body limit", bytes.Repeat([]byte{'a'}, 8<<10), http.StatusOK},
+		{"stream over the stream limit", bytes.Repeat([]byte{'a'}, 32<<10), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range streams {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/stream", bytes.NewBuffer(tt.body))
+
+			w := httptest.NewRecorder()
+			streamMw(echo).ServeHTTP(w, request)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func benchmarkDecode(b *testing.B, encoding string, body []byte) {
+	mw := gzipMw.New(gzipMw.Options{MaxCompressedSize: 1 << 20, MaxDecompressedSize: 1 << 22})
+	next := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		request.Header.Set("Content-Encoding", encoding)
+		next.ServeHTTP(httptest.NewRecorder(), request)
+	}
+}
+
+var benchBody = bytes.Repeat([]byte(`{"correlation_id":"abc","original_url":"https://example.com/path"},`), 1000)
+
+func BenchmarkGzipHandle_Plain(b *testing.B) {
+	benchmarkDecode(b, "", benchBody)
+}
+
+func BenchmarkGzipHandle_Gzip(b *testing.B) {
+	benchmarkDecode(b, "gzip", gzipBytes(benchBody))
+}
 
+func BenchmarkGzipHandle_Zstd(b *testing.B) {
+	enc, _ := zstd.NewWriter(nil)
+	benchmarkDecode(b, "zstd", enc.EncodeAll(benchBody, nil))
 }
@@ -0,0 +1,90 @@
+// Package logging contains the access log MW assigning request IDs
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestIDHeader carries the request ID both in requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// validRequestID accepts non-empty printable ASCII IDs of a sane length
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// RequestLogger returns a MW that propagates X-Request-ID (or generates one), puts a request-scoped logger
+// into the request context and writes an access log line when the request is served
+func RequestLogger(base *slog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+
+			l := base.With("request_id", id)
+			ctx := logger.WithContext(logger.WithAnnotations(r.Context()), l)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			pattern := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				pattern = rctx.RoutePattern()
+			}
+
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			args := append([]any{
+				"method", r.Method,
+				"route", pattern,
+				"status", status,
+				"latency", time.Since(start),
+				"bytes", ww.BytesWritten(),
+			}, logger.Annotations(ctx)...)
+
+			l.Log(ctx, level, "request served", args...)
+		})
+	}
+}
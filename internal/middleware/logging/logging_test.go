@@ -0,0 +1,79 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/middleware/auth"
+	"github.com/T-V-N/gourlshortener/internal/middleware/logging"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RequestLogger(t *testing.T) {
+	buf := bytes.Buffer{}
+	l, err := logger.New(&config.Config{LogLevel: "debug", LogFormat: "json"}, &buf)
+	assert.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(logging.RequestLogger(l))
+	router.Use(auth.InitAuth(&config.Config{SecretKey: "secret"}))
+	router.Get("/{urlHash}", func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Debug("inside handler")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+
+	t.Run("propagates the incoming request id", func(t *testing.T) {
+		buf.Reset()
+
+		request := httptest.NewRequest(http.MethodGet, "/e62e2446", nil)
+		request.Header.Set(logging.RequestIDHeader, "req-42")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+
+		assert.Equal(t, "req-42", w.Header().Get(logging.RequestIDHeader))
+
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte{'\n'})
+		assert.Len(t, lines, 2)
+
+		inner, access := map[string]interface{}{}, map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(lines[0], &inner))
+		assert.NoError(t, json.Unmarshal(lines[1], &access))
+
+		assert.Equal(t, "req-42", inner["request_id"])
+		assert.NotEmpty(t, inner["uid"])
+		assert.Equal(t, "req-42", access["request_id"])
+		assert.Equal(t, "/{urlHash}", access["route"])
+		assert.Equal(t, float64(http.StatusTeapot), access["status"])
+		assert.Equal(t, float64(len("short and stout")), access["bytes"])
+		assert.Equal(t, inner["uid"], access["uid"])
+	})
+
+	t.Run("generates a request id", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/e62e2446", nil)
+		request.Header.Set(logging.RequestIDHeader, "bad id\n")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+
+		assert.Len(t, w.Header().Get(logging.RequestIDHeader), 16)
+	})
+
+	t.Run("level filters lines", func(t *testing.T) {
+		buf.Reset()
+		logger.Level.Set(slog.LevelInfo)
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/e62e2446", nil))
+
+		assert.Len(t, bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte{'\n'}), 1)
+	})
+}
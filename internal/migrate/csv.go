@@ -0,0 +1,187 @@
+package migrate
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// newReader returns a function reading the next link from r, it returns io.EOF at the end
+func newReader(r io.Reader, format string) (func() (Link, error), error) {
+	switch format {
+	case FormatBitly:
+		return newCSVReader(r, bitlyColumns)
+	case FormatYOURLSCSV:
+		return newCSVReader(r, yourlsColumns)
+	case FormatYOURLSSQL:
+		return newSQLReader(r), nil
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// csvColumns names the header columns of a CSV export, names are compared by their letters and digits
+// ignoring case. The first column of a list set in a record is used.
+type csvColumns struct {
+	url     []string // long URL, required
+	code    []string // short codes
+	link    []string // short links, the code is their last path segment
+	created []string // creation times
+}
+
+// bitlyColumns covers the API field names and the headers of exports from the web app
+var bitlyColumns = csvColumns{
+	url:     []string{"long_url", "long url", "destination url", "destination"},
+	code:    []string{"custom back-half", "custom_back_half"},
+	link:    []string{"custom_bitlinks", "custom bitlinks", "link", "bitlink", "short link", "short_url"},
+	created: []string{"created_at", "created", "date created", "creation date"},
+}
+
+// yourlsColumns are the columns of the YOURLS url table, exports keep them
+var yourlsColumns = csvColumns{
+	url:     []string{"url", "long url", "long_url"},
+	code:    []string{"keyword"},
+	link:    []string{"shorturl", "short url"},
+	created: []string{"timestamp", "date"},
+}
+
+// columnKey normalizes a column name
+func columnKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+
+		return -1
+	}, name)
+}
+
+// present returns the indexes of names present in header in the order of names
+func present(header map[string]int, names []string) []int {
+	cols := []int{}
+
+	for _, name := range names {
+		if i, ok := header[columnKey(name)]; ok {
+			cols = append(cols, i)
+		}
+	}
+
+	return cols
+}
+
+func newCSVReader(r io.Reader, columns csvColumns) (func() (Link, error), error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	names, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return func() (Link, error) { return Link{}, io.EOF }, nil
+	}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := map[string]int{}
+
+	for i, name := range names {
+		// exports written by spreadsheets may start with a byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		if _, ok := header[columnKey(name)]; !ok {
+			header[columnKey(name)] = i
+		}
+	}
+
+	urlCols, codeCols, linkCols, createdCols := present(header, columns.url), present(header, columns.code), present(header, columns.link), present(header, columns.created)
+	if len(urlCols) == 0 {
+		return nil, fmt.Errorf("%w: no %s column in the header", ErrMalformed, columns.url[0])
+	}
+
+	// field returns the first value of cols set in record
+	field := func(record []string, cols []int) string {
+		for _, i := range cols {
+			if i < len(record) && strings.TrimSpace(record[i]) != "" {
+				return strings.TrimSpace(record[i])
+			}
+		}
+
+		return ""
+	}
+
+	return func() (Link, error) {
+		record, err := cr.Read()
+		if errors.As(err, &parseErr) {
+			return Link{}, fmt.Errorf("%w: %v", errInvalidLink, err)
+		}
+
+		if err != nil {
+			return Link{}, err
+		}
+
+		link := Link{URL: field(record, urlCols), Code: field(record, codeCols), CreatedAt: parseTime(field(record, createdCols))}
+		if link.Code == "" {
+			link.Code = linkCode(field(record, linkCols))
+		}
+
+		return link, nil
+	}, nil
+}
+
+// linkCode returns the code of a short link: its last path segment. A column may list several links,
+// the first one is used.
+func linkCode(link string) string {
+	links := strings.FieldsFunc(link, func(r rune) bool { return r == ',' || r == ';' || unicode.IsSpace(r) })
+	if len(links) == 0 {
+		return ""
+	}
+
+	link = links[0]
+
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	path := strings.Trim(u.Path, "/")
+
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// timeLayouts are tried in order by parseTime
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02", "01/02/2006 15:04", "01/02/2006"}
+
+// parseTime parses a creation time of an export: a date and time in one of timeLayouts or unix seconds.
+// Times without a zone are UTC, unknown formats give a zero time.
+func parseTime(raw string) time.Time {
+	if raw == "" {
+		return time.Time{}
+	}
+
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC()
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.UTC()
+		}
+	}
+
+	return time.Time{}
+}
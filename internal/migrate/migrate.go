@@ -0,0 +1,315 @@
+// Package migrate imports links exported by other shorteners: Bitly CSV exports and YOURLS SQL dumps or
+// CSV exports. Links keep their original short codes where the codes are free on the target domain.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+)
+
+// Export formats
+const (
+	FormatBitly     = "bitly"
+	FormatYOURLSSQL = "yourls-sql"
+	FormatYOURLSCSV = "yourls-csv"
+)
+
+// Outcomes of imported links
+const (
+	OutcomeKept     = "kept"     // saved under its original code
+	OutcomeRenamed  = "renamed"  // its code is taken or unusable, saved under the code POST / would give it
+	OutcomeExisting = "existing" // its code already points to the same URL, nothing was saved
+	OutcomeConflict = "conflict" // neither its code nor a generated one is free, not saved
+	OutcomeInvalid  = "invalid"  // the link can't be read or its URL is invalid, not saved
+)
+
+// chunkSize is how many URLs are saved per BatchSaveURL call
+const chunkSize = 500
+
+// maxReports bounds the reports a result keeps, the rest are only counted
+const maxReports = 1000
+
+var (
+	// ErrUnknownFormat is returned for formats other than bitly, yourls-sql and yourls-csv
+	ErrUnknownFormat = errors.New("unknown format, use bitly, yourls-sql or yourls-csv")
+	// ErrMalformed wraps errors of exports that can't be read at all
+	ErrMalformed = errors.New("malformed export")
+
+	// errInvalidLink marks links that can't be read, they are reported and the import goes on
+	errInvalidLink = errors.New("invalid link")
+)
+
+// codePattern matches codes that can be served as a single path segment
+var codePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedCodes are top level paths the service serves itself
+var reservedCodes = map[string]bool{"api": true, "debug": true, "healthz": true, "metrics": true, "ping": true, "readyz": true}
+
+// Link is a link read from an export
+type Link struct {
+	Code      string    // original short code, empty if the export has none
+	URL       string    // long URL
+	CreatedAt time.Time // zero if unknown
+}
+
+// Report describes a link that didn't keep its original code
+type Report struct {
+	Item    int    `json:"item"` // position of the link in the export from 1
+	Code    string `json:"code,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Outcome string `json:"outcome"`
+	NewCode string `json:"new_code,omitempty"` // the code a renamed link got
+	Reason  string `json:"reason"`
+}
+
+// Result describes an import
+type Result struct {
+	Kept      int      `json:"kept"`
+	Renamed   int      `json:"renamed"`
+	Existing  int      `json:"existing"`
+	Conflicts int      `json:"conflicts"`
+	Invalid   int      `json:"invalid"`
+	Reports   []Report `json:"reports,omitempty"`         // links that didn't keep their codes ordered by item
+	Omitted   int      `json:"omitted_reports,omitempty"` // reports left out past the first 1000
+}
+
+func (res *Result) report(r Report) {
+	switch r.Outcome {
+	case OutcomeRenamed:
+		res.Renamed++
+	case OutcomeExisting:
+		res.Existing++
+	case OutcomeConflict:
+		res.Conflicts++
+	case OutcomeInvalid:
+		res.Invalid++
+	}
+
+	if len(res.Reports) == maxReports {
+		res.Omitted++
+		return
+	}
+
+	res.Reports = append(res.Reports, r)
+}
+
+// Options of an import
+type Options struct {
+	Format string                                        // export format
+	UID    string                                        // owner of the imported URLs
+	Domain string                                        // domain the URLs are bound to, empty for the default one
+	Saved  func(ctx context.Context, urls []storage.URL) // called after every saved chunk if set
+}
+
+// Import reads links in opts.Format from r and saves them to st. A link keeps its code unless the code is
+// taken on the domain or can't be served, then it gets the code POST / would give it. Links are checked
+// against st and each other, links whose code is taken by the same URL aren't saved again.
+func Import(ctx context.Context, st storage.Storage, r io.Reader, opts Options) (Result, error) {
+	res := Result{}
+
+	next, err := newReader(r, opts.Format)
+	if err != nil {
+		return res, err
+	}
+
+	im := &importer{st: st, opts: opts, claimed: map[string]string{}}
+
+	for item := 1; ; item++ {
+		link, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if errors.Is(err, errInvalidLink) {
+			res.report(Report{Item: item, Outcome: OutcomeInvalid, Reason: err.Error()})
+			continue
+		}
+
+		if err != nil {
+			return res, err
+		}
+
+		if err := im.add(ctx, &res, item, link); err != nil {
+			return res, err
+		}
+
+		if len(im.chunk) == chunkSize {
+			if err := im.save(ctx, &res); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	err = im.save(ctx, &res)
+
+	// renamed links are reported when their chunk is saved
+	sort.SliceStable(res.Reports, func(i, j int) bool { return res.Reports[i].Item < res.Reports[j].Item })
+
+	return res, err
+}
+
+// importer keeps the state of an import
+type importer struct {
+	st      storage.Storage
+	opts    Options
+	claimed map[string]string // URLs of the codes taken by earlier links of the import
+	chunk   []storage.URL
+	items   []int     // export positions of the chunk URLs
+	codes   []string  // original codes of the chunk URLs
+	renames []*Report // reports of the chunk URLs saved under generated codes, nil for kept codes
+}
+
+// add picks the code of link and queues it for saving
+func (im *importer) add(ctx context.Context, res *Result, item int, link Link) error {
+	u, err := url.ParseRequestURI(link.URL)
+	if err != nil {
+		res.report(Report{Item: item, Code: link.Code, URL: link.URL, Outcome: OutcomeInvalid, Reason: app.ErrInvalidURL.Error()})
+		return nil
+	}
+
+	longURL := u.String()
+
+	var reason string
+
+	switch {
+	case link.Code == "":
+		reason = "export has no code"
+	case !codePattern.MatchString(link.Code) || reservedCodes[link.Code]:
+		reason = "code can't be served"
+	default:
+		owner, err := im.owner(ctx, link.Code)
+		if err != nil {
+			return err
+		}
+
+		switch owner {
+		case "":
+			im.queue(item, link, link.Code, longURL, nil)
+			return nil
+		case longURL:
+			res.report(Report{Item: item, Code: link.Code, URL: longURL, Outcome: OutcomeExisting, Reason: "code already points to the url"})
+			return nil
+		}
+
+		reason = "code is taken by " + owner
+	}
+
+	code := app.Hash(longURL)
+
+	owner, err := im.owner(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	r := Report{Item: item, Code: link.Code, URL: longURL, NewCode: code, Reason: reason}
+
+	switch owner {
+	case "":
+		r.Outcome = OutcomeRenamed
+		im.queue(item, link, code, longURL, &r)
+	case longURL:
+		r.Outcome, r.Reason = OutcomeExisting, reason+", the url already has a short link"
+		res.report(r)
+	default:
+		r.Outcome, r.Reason = OutcomeConflict, reason+", generated code is taken by "+owner
+		res.report(r)
+	}
+
+	return nil
+}
+
+// owner returns the URL code points to on the domain, empty if the code is free
+func (im *importer) owner(ctx context.Context, code string) (string, error) {
+	if owner, ok := im.claimed[code]; ok {
+		return owner, nil
+	}
+
+	u, err := im.st.GetURL(ctx, im.opts.Domain, code)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return u.URL, nil
+}
+
+// queue queues longURL to be saved under code, rename is its report if code isn't the original one
+func (im *importer) queue(item int, link Link, code, longURL string, rename *Report) {
+	im.claimed[code] = longURL
+	im.chunk = append(im.chunk, storage.URL{UID: im.opts.UID, ShortURL: code, URL: longURL, Domain: im.opts.Domain, CreatedAt: link.CreatedAt})
+	im.items = append(im.items, item)
+	im.codes = append(im.codes, link.Code)
+	im.renames = append(im.renames, rename)
+}
+
+// save saves the queued URLs. If they can't be saved at once, e.g. since another writer took a code
+// meanwhile, they are saved one by one and those failing are reported as conflicts.
+func (im *importer) save(ctx context.Context, res *Result) error {
+	defer func() {
+		im.chunk, im.items, im.codes, im.renames = im.chunk[:0], im.items[:0], im.codes[:0], im.renames[:0]
+	}()
+
+	if len(im.chunk) == 0 {
+		return nil
+	}
+
+	if err := im.st.BatchSaveURL(ctx, im.chunk); err == nil {
+		for i := range im.chunk {
+			im.saved(res, i)
+		}
+
+		im.notify(ctx, im.chunk)
+
+		return nil
+	}
+
+	for i, u := range im.chunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := im.st.BatchSaveURL(ctx, []storage.URL{u}); err != nil {
+			r := Report{Item: im.items[i], Code: im.codes[i], URL: u.URL, Outcome: OutcomeConflict, Reason: fmt.Sprintf("saving failed: %v", err)}
+			if im.renames[i] != nil {
+				r.NewCode = u.ShortURL
+			}
+
+			res.report(r)
+
+			continue
+		}
+
+		im.saved(res, i)
+		im.notify(ctx, im.chunk[i:i+1])
+	}
+
+	return nil
+}
+
+// saved counts the i-th queued URL as saved
+func (im *importer) saved(res *Result, i int) {
+	if im.renames[i] == nil {
+		res.Kept++
+		return
+	}
+
+	res.report(*im.renames[i])
+}
+
+func (im *importer) notify(ctx context.Context, urls []storage.URL) {
+	if im.opts.Saved != nil {
+		im.opts.Saved(ctx, urls)
+	}
+}
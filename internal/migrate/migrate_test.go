@@ -0,0 +1,219 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/app"
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/migrate"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStorage fails batches with more than one URL if failBatches is set
+type failingStorage struct {
+	storage.Storage
+	failBatches bool
+	taken       string // code another writer takes while the import runs
+}
+
+func (s *failingStorage) BatchSaveURL(ctx context.Context, urls []storage.URL) error {
+	if s.failBatches && len(urls) > 1 {
+		return errors.New("unique violation")
+	}
+
+	if len(urls) == 1 && urls[0].ShortURL == s.taken {
+		return errors.New("unique violation")
+	}
+
+	return s.Storage.BatchSaveURL(ctx, urls)
+}
+
+func newStorage(t *testing.T) storage.Storage {
+	st := storage.InitFileStorage(nil, &config.Config{})
+
+	require.NoError(t, st.SaveURL(context.Background(), storage.URL{UID: "other", ShortURL: "taken", URL: "https://other.example"}))
+	require.NoError(t, st.SaveURL(context.Background(), storage.URL{UID: "other", ShortURL: "same", URL: "https://same.example"}))
+
+	return st
+}
+
+const bitlyExport = "\ufeffid,link,custom_bitlinks,long_url,title,created_at\n" +
+	"bit.ly/3abcXYZ,https://bit.ly/3abcXYZ,,https://one.example,One,2023-04-05T06:07:08+0000\n" +
+	"bit.ly/4defUVW,https://bit.ly/4defUVW,https://bit.ly/launch,https://two.example,Two,2023-04-05 10:00:00\n" +
+	"bit.ly/taken,https://bit.ly/taken,,https://three.example,Three,\n" +
+	"bit.ly/same,https://bit.ly/same,,https://same.example,Same,\n" +
+	"bit.ly/ping,https://bit.ly/ping,,https://four.example,Four,\n" +
+	"bit.ly/x1,https://bit.ly/x1,,not a url,Broken,\n" +
+	"bit.ly/3abcXYZ,https://bit.ly/3abcXYZ,,https://five.example,Duplicate,\n"
+
+const yourlsDump = `-- MySQL dump 10.13
+/*!40101 SET NAMES utf8mb4 */;
+DROP TABLE IF EXISTS ` + "`yourls_url`" + `;
+CREATE TABLE ` + "`yourls_url`" + ` (
+  ` + "`keyword`" + ` varchar(200) NOT NULL,
+  ` + "`url`" + ` text NOT NULL,
+  PRIMARY KEY (` + "`keyword`" + `)
+) ENGINE=InnoDB;
+INSERT INTO ` + "`yourls_options`" + ` VALUES (1,'version','1.9.2');
+# rows
+INSERT INTO ` + "`yourls_url`" + ` VALUES ('ozh','https://ozh.example/?a=1&b=\'2\'','It\'s \"quoted\"','2016-02-01 12:00:00','127.0.0.1',10),('nul','https://nul.example',NULL,'2016-02-02 12:00:00','::1',0);
+INSERT IGNORE INTO ` + "`shortener`.`yourls_url`" + ` (` + "`url`, `keyword`, `timestamp`" + `) VALUES
+('https://cols.example','cols','2017-01-01 00:00:00'),
+('https://taken.example','taken','2017-01-01 00:00:00')
+ON DUPLICATE KEY UPDATE clicks = clicks;
+INSERT INTO ` + "`yourls_log`" + ` VALUES (1,'2016-02-01 12:00:00','ozh','referrer;with;semicolons','ua','1.2.3.4','US');
+`
+
+func Test_Import(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		result  migrate.Result
+		reports []string // outcome of every report in order
+		codes   map[string]string
+	}{
+		{
+			name:    "bitly",
+			format:  migrate.FormatBitly,
+			input:   bitlyExport,
+			result:  migrate.Result{Kept: 2, Renamed: 3, Existing: 1, Invalid: 1},
+			reports: []string{migrate.OutcomeRenamed, migrate.OutcomeExisting, migrate.OutcomeRenamed, migrate.OutcomeInvalid, migrate.OutcomeRenamed},
+			codes: map[string]string{
+				"3abcXYZ":                         "https://one.example",
+				"launch":                          "https://two.example",
+				app.Hash("https://three.example"): "https://three.example",
+				app.Hash("https://four.example"):  "https://four.example",
+				app.Hash("https://five.example"):  "https://five.example",
+			},
+		},
+		{
+			name:    "yourls sql",
+			format:  migrate.FormatYOURLSSQL,
+			input:   yourlsDump,
+			result:  migrate.Result{Kept: 3, Renamed: 1},
+			reports: []string{migrate.OutcomeRenamed},
+			codes: map[string]string{
+				"ozh":                             "https://ozh.example/?a=1&b='2'",
+				"nul":                             "https://nul.example",
+				"cols":                            "https://cols.example",
+				app.Hash("https://taken.example"): "https://taken.example",
+			},
+		},
+		{
+			name:   "yourls csv",
+			format: migrate.FormatYOURLSCSV,
+			input:  "keyword,url,title,timestamp,ip,clicks\nyc1,https://yc.example/1,One,2018-03-04 05:06:07,::1,3\n\"yc2\",\"https://yc.example/2\",\"Two, \"\"quoted\"\"\",1520139967,::1,0\n",
+			result: migrate.Result{Kept: 2},
+			codes:  map[string]string{"yc1": "https://yc.example/1", "yc2": "https://yc.example/2"},
+		},
+		{
+			name:   "empty",
+			format: migrate.FormatBitly,
+			input:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := newStorage(t)
+			saved := 0
+
+			res, err := migrate.Import(ctx, st, strings.NewReader(tt.input), migrate.Options{
+				Format: tt.format,
+				UID:    "user",
+				Saved:  func(_ context.Context, urls []storage.URL) { saved += len(urls) },
+			})
+			require.NoError(t, err)
+
+			outcomes := []string{}
+			for _, r := range res.Reports {
+				outcomes = append(outcomes, r.Outcome)
+			}
+
+			if tt.reports == nil {
+				tt.reports = []string{}
+			}
+
+			assert.Equal(t, tt.reports, outcomes)
+
+			res.Reports = nil
+			assert.Equal(t, tt.result, res)
+			assert.Equal(t, len(tt.codes), saved)
+
+			for code, want := range tt.codes {
+				u, err := st.GetURL(ctx, "", code)
+				require.NoError(t, err, code)
+				assert.Equal(t, want, u.URL)
+				assert.Equal(t, "user", u.UID)
+			}
+
+			taken, err := st.GetURL(ctx, "", "taken")
+			require.NoError(t, err)
+			assert.Equal(t, "other", taken.UID)
+		})
+	}
+}
+
+func Test_ImportCreationTimes(t *testing.T) {
+	ctx := context.Background()
+	st := newStorage(t)
+
+	_, err := migrate.Import(ctx, st, strings.NewReader(yourlsDump), migrate.Options{Format: migrate.FormatYOURLSSQL, UID: "user"})
+	require.NoError(t, err)
+
+	u, err := st.GetURL(ctx, "", "ozh")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2016, 2, 1, 12, 0, 0, 0, time.UTC), u.CreatedAt)
+
+	_, err = migrate.Import(ctx, st, strings.NewReader(bitlyExport), migrate.Options{Format: migrate.FormatBitly, UID: "user"})
+	require.NoError(t, err)
+
+	u, err = st.GetURL(ctx, "", "3abcXYZ")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC), u.CreatedAt)
+}
+
+func Test_ImportFallsBackToSingleSaves(t *testing.T) {
+	ctx := context.Background()
+	st := &failingStorage{Storage: newStorage(t), failBatches: true, taken: "yc2"}
+
+	res, err := migrate.Import(ctx, st, strings.NewReader("keyword,url\nyc1,https://yc.example/1\nyc2,https://yc.example/2\n"),
+		migrate.Options{Format: migrate.FormatYOURLSCSV, UID: "user"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, res.Kept)
+	assert.Equal(t, 1, res.Conflicts)
+	require.Len(t, res.Reports, 1)
+	assert.Equal(t, 2, res.Reports[0].Item)
+	assert.Equal(t, "yc2", res.Reports[0].Code)
+}
+
+func Test_ImportErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		err    error
+	}{
+		{"unknown format", "rebrandly", "", migrate.ErrUnknownFormat},
+		{"csv without urls", migrate.FormatBitly, "link,title\nhttps://bit.ly/a,A\n", migrate.ErrMalformed},
+		{"unterminated string", migrate.FormatYOURLSSQL, "INSERT INTO yourls_url VALUES ('a','https://a.example", migrate.ErrMalformed},
+		{"unexpected values", migrate.FormatYOURLSSQL, "INSERT INTO yourls_url SET keyword = 'a';", migrate.ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := migrate.Import(context.Background(), newStorage(t), strings.NewReader(tt.input), migrate.Options{Format: tt.format, UID: "user"})
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// yourlsDefaultColumns are the columns of the YOURLS url table in order, used by inserts without a column list
+var yourlsDefaultColumns = []string{"keyword", "url", "title", "timestamp", "ip", "clicks"}
+
+// isURLTable reports whether table is the YOURLS url table: url with the installation prefix, yourls_ by default
+func isURLTable(table string) bool {
+	table = strings.ToLower(table)
+	return table == "url" || strings.HasSuffix(table, "_url")
+}
+
+// token kinds of a MySQL dump
+const (
+	tokenEOF    = iota
+	tokenWord   // keyword, unquoted identifier or number
+	tokenName   // `quoted identifier`
+	tokenString // 'string' or "string"
+	tokenPunct  // any other character
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+func (t token) is(kind int, text string) bool {
+	return t.kind == kind && strings.EqualFold(t.text, text)
+}
+
+// lexer splits a MySQL dump into tokens skipping comments
+type lexer struct {
+	r *bufio.Reader
+}
+
+func (l *lexer) peek() rune {
+	c, _, err := l.r.ReadRune()
+	if err != nil {
+		return -1
+	}
+
+	_ = l.r.UnreadRune()
+
+	return c
+}
+
+func (l *lexer) read() rune {
+	c, _, err := l.r.ReadRune()
+	if err != nil {
+		return -1
+	}
+
+	return c
+}
+
+func isWordChar(c rune) bool {
+	return c == '_' || c == '$' || c == '.' || c == '-' || c == '+' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c > 127
+}
+
+func (l *lexer) next() (token, error) {
+	for {
+		c := l.read()
+
+		switch {
+		case c == -1:
+			return token{kind: tokenEOF}, nil
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			continue
+		case c == '#' || (c == '-' && l.peek() == '-'):
+			l.skipLine()
+			continue
+		case c == '/' && l.peek() == '*':
+			l.read()
+
+			if err := l.skipComment(); err != nil {
+				return token{}, err
+			}
+
+			continue
+		case c == '\'' || c == '"':
+			text, err := l.quoted(c, true)
+			return token{tokenString, text}, err
+		case c == '`':
+			text, err := l.quoted(c, false)
+			return token{tokenName, text}, err
+		case isWordChar(c):
+			b := strings.Builder{}
+			b.WriteRune(c)
+
+			for isWordChar(l.peek()) {
+				b.WriteRune(l.read())
+			}
+
+			return token{tokenWord, b.String()}, nil
+		default:
+			return token{tokenPunct, string(c)}, nil
+		}
+	}
+}
+
+func (l *lexer) skipLine() {
+	for c := l.read(); c != '\n' && c != -1; c = l.read() {
+	}
+}
+
+func (l *lexer) skipComment() error {
+	for prev, c := rune(0), l.read(); ; prev, c = c, l.read() {
+		switch {
+		case c == -1:
+			return fmt.Errorf("%w: unterminated comment", ErrMalformed)
+		case prev == '*' && c == '/':
+			return nil
+		}
+	}
+}
+
+// quoted reads a literal up to its closing quote, a doubled quote is the quote itself.
+// Backslash escapes are decoded in strings like MySQL does.
+func (l *lexer) quoted(quote rune, escapes bool) (string, error) {
+	b := strings.Builder{}
+
+	for {
+		c := l.read()
+
+		switch {
+		case c == -1:
+			return "", fmt.Errorf("%w: unterminated literal", ErrMalformed)
+		case c == quote && l.peek() == quote:
+			b.WriteRune(l.read())
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && escapes:
+			switch e := l.read(); e {
+			case -1:
+				return "", fmt.Errorf("%w: unterminated literal", ErrMalformed)
+			case '0':
+				b.WriteByte(0)
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case 'Z':
+				b.WriteByte(26)
+			case '%', '_':
+				b.WriteRune('\\')
+				b.WriteRune(e)
+			default:
+				b.WriteRune(e)
+			}
+		default:
+			b.WriteRune(c)
+		}
+	}
+}
+
+// sqlReader reads rows inserted into the YOURLS url table by a MySQL dump, other statements are skipped
+type sqlReader struct {
+	lex     *lexer
+	columns []string // columns of the current insert into the url table, nil outside of one
+	rows    int      // rows of the current insert read so far
+}
+
+func newSQLReader(r io.Reader) func() (Link, error) {
+	sr := &sqlReader{lex: &lexer{r: bufio.NewReader(r)}}
+	return sr.next
+}
+
+func (sr *sqlReader) next() (Link, error) {
+	for {
+		if sr.columns != nil {
+			row, err := sr.row()
+			if err != nil || row != nil {
+				return rowLink(row), err
+			}
+
+			sr.columns = nil
+
+			continue
+		}
+
+		t, err := sr.lex.next()
+		if err != nil {
+			return Link{}, err
+		}
+
+		switch {
+		case t.kind == tokenEOF:
+			return Link{}, io.EOF
+		case t.is(tokenWord, "INSERT") || t.is(tokenWord, "REPLACE"):
+			if err := sr.insert(); err != nil {
+				return Link{}, err
+			}
+		case !t.is(tokenPunct, ";"):
+			if err := sr.skipStatement(); err != nil {
+				return Link{}, err
+			}
+		}
+	}
+}
+
+// skipStatement skips tokens up to the end of the statement
+func (sr *sqlReader) skipStatement() error {
+	for {
+		t, err := sr.lex.next()
+		if err != nil || t.kind == tokenEOF || t.is(tokenPunct, ";") {
+			return err
+		}
+	}
+}
+
+// insert reads an insert up to its values, inserts into other tables are skipped
+func (sr *sqlReader) insert() error {
+	t, err := sr.lex.next()
+
+	for err == nil && (t.is(tokenWord, "LOW_PRIORITY") || t.is(tokenWord, "DELAYED") || t.is(tokenWord, "HIGH_PRIORITY") || t.is(tokenWord, "IGNORE")) {
+		t, err = sr.lex.next()
+	}
+
+	if err == nil && t.is(tokenWord, "INTO") {
+		t, err = sr.lex.next()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	table := t.text
+
+	// a qualified name is the database and the table separated by a dot, the lexer reads the dot as a word
+	// or as the start of the next word if the table name isn't quoted
+	t, err = sr.lex.next()
+	for err == nil && t.kind == tokenWord && strings.HasPrefix(t.text, ".") {
+		if t.text != "." {
+			table = t.text[1:]
+		} else if t, err = sr.lex.next(); err == nil {
+			table = t.text
+		}
+
+		if err == nil {
+			t, err = sr.lex.next()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// unquoted qualified names are a single word
+	table = table[strings.LastIndex(table, ".")+1:]
+	if !isURLTable(table) {
+		return sr.skipStatement()
+	}
+
+	columns := yourlsDefaultColumns
+
+	if t.is(tokenPunct, "(") {
+		if columns, err = sr.list(); err != nil {
+			return err
+		}
+
+		if t, err = sr.lex.next(); err != nil {
+			return err
+		}
+	}
+
+	if !t.is(tokenWord, "VALUES") && !t.is(tokenWord, "VALUE") {
+		return fmt.Errorf("%w: unexpected %q in an insert into %s", ErrMalformed, t.text, table)
+	}
+
+	sr.columns, sr.rows = columns, 0
+
+	return nil
+}
+
+// list reads values up to the closing parenthesis, NULL is an empty string
+func (sr *sqlReader) list() ([]string, error) {
+	values := []string{}
+
+	for {
+		t, err := sr.lex.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case t.kind == tokenEOF:
+			return nil, fmt.Errorf("%w: unterminated value list", ErrMalformed)
+		case t.is(tokenPunct, ")"):
+			return values, nil
+		case t.is(tokenPunct, ","):
+			continue
+		case t.is(tokenWord, "NULL"):
+			values = append(values, "")
+		case t.kind == tokenWord && strings.HasPrefix(t.text, "_"):
+			// a character set introducer of the following string
+			continue
+		default:
+			values = append(values, t.text)
+		}
+	}
+}
+
+// row reads the next row of the current insert, nil after the last one
+func (sr *sqlReader) row() (map[string]string, error) {
+	t, err := sr.lex.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if sr.rows > 0 && t.is(tokenPunct, ",") {
+		if t, err = sr.lex.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case t.kind == tokenEOF || t.is(tokenPunct, ";"):
+		return nil, nil
+	case t.is(tokenWord, "ON"):
+		// ON DUPLICATE KEY UPDATE ...
+		return nil, sr.skipStatement()
+	case !t.is(tokenPunct, "("):
+		return nil, fmt.Errorf("%w: unexpected %q in values", ErrMalformed, t.text)
+	}
+
+	values, err := sr.list()
+	if err != nil {
+		return nil, err
+	}
+
+	sr.rows++
+
+	row := map[string]string{}
+
+	for i, column := range sr.columns {
+		if i < len(values) {
+			row[strings.ToLower(column)] = values[i]
+		}
+	}
+
+	return row, nil
+}
+
+// rowLink converts a row of the url table
+func rowLink(row map[string]string) Link {
+	return Link{Code: row["keyword"], URL: row["url"], CreatedAt: parseTime(row["timestamp"])}
+}
@@ -0,0 +1,272 @@
+// Package pages renders the pages shown to people following short links: errors like not found or gone
+// and interstitials like the password prompt. Pages are negotiated by Accept: browsers get HTML,
+// API clients JSON or plain text.
+package pages
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+)
+
+// Page names, a page is rendered from the template file <name>.html
+const (
+	NotFound = "not_found" // unknown link
+	Gone     = "gone"      // deleted link
+	Expired  = "expired"   // link past its expiry
+	Password = "password"  // password prompt of a protected link
+	Warning  = "warning"   // safety warning before redirecting to a suspicious URL
+)
+
+// Names lists all pages
+var Names = []string{NotFound, Gone, Expired, Password, Warning}
+
+// layoutFile defines the "layout" template pages wrap their "content" into
+const layoutFile = "layout.html"
+
+// Media types Render negotiates between
+const (
+	TypeHTML  = "text/html"
+	TypeJSON  = "application/json"
+	TypePlain = "text/plain"
+)
+
+//go:embed templates/*.html
+var embedded embed.FS
+
+// Data is passed to page templates
+type Data struct {
+	Status  int    `json:"status"`            // HTTP status code
+	Title   string `json:"title"`             // short title, the plain text body
+	Message string `json:"message,omitempty"` // explanation for people
+	URL     string `json:"url,omitempty"`     // destination URL of interstitials
+	Action  string `json:"-"`                 // form action of the password prompt
+	Error   string `json:"-"`                 // form error of the password prompt, e.g. a wrong password
+}
+
+// Pages holds parsed page templates
+type Pages struct {
+	templates map[string]*template.Template
+}
+
+// Default returns the embedded pages
+func Default() *Pages {
+	p, err := New("")
+	if err != nil {
+		panic(fmt.Sprintf("embedded pages: %v", err))
+	}
+
+	return p
+}
+
+// New parses the embedded pages, files of dir named like an embedded one (e.g. layout.html or gone.html)
+// override it. Unknown .html files in dir are rejected to catch typos.
+func New(dir string) (*Pages, error) {
+	files := map[string]string{}
+
+	embeddedFS, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range append([]string{layoutFile}, fileNames()...) {
+		data, err := fs.ReadFile(embeddedFS, name)
+		if err != nil {
+			return nil, err
+		}
+
+		files[name] = string(data)
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("reading pages dir: %w", err)
+		}
+
+		for _, e := range entries {
+			if e.IsDir() || filepath.Ext(e.Name()) != ".html" {
+				continue
+			}
+
+			if _, ok := files[e.Name()]; !ok {
+				return nil, fmt.Errorf("unknown page template %s, expected one of %s", e.Name(), strings.Join(knownFiles(files), ", "))
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			files[e.Name()] = string(data)
+		}
+	}
+
+	layout, err := template.New(layoutFile).Parse(files[layoutFile])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pages{templates: map[string]*template.Template{}}
+
+	for _, name := range Names {
+		t, err := layout.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		if t, err = t.New(name + ".html").Parse(files[name+".html"]); err != nil {
+			return nil, err
+		}
+
+		p.templates[name] = t
+	}
+
+	return p, nil
+}
+
+func fileNames() []string {
+	names := make([]string, 0, len(Names))
+	for _, name := range Names {
+		names = append(names, name+".html")
+	}
+
+	return names
+}
+
+func knownFiles(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Render writes page with data as HTML, JSON or plain text depending on the Accept header of r.
+// Empty Status, Title and Message get the page defaults.
+func (p *Pages) Render(w http.ResponseWriter, r *http.Request, page string, data Data) {
+	t, ok := p.templates[page]
+	if !ok {
+		http.Error(w, "unknown page "+page, http.StatusInternalServerError)
+		return
+	}
+
+	if data.Status == 0 {
+		data.Status = defaultStatus[page]
+	}
+
+	if data.Title == "" {
+		data.Title = http.StatusText(data.Status)
+	}
+
+	if data.Message == "" {
+		data.Message = defaultMessage[page]
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Vary", "Accept")
+
+	switch Negotiate(r.Header.Get("Accept"), TypePlain, TypeHTML, TypeJSON) {
+	case TypeHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(data.Status)
+
+		if err := t.Execute(w, data); err != nil {
+			logger.FromContext(r.Context()).Error("rendering page", "page", page, "error", err)
+		}
+	case TypeJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(data.Status)
+
+		_ = json.NewEncoder(w).Encode(data)
+	default:
+		http.Error(w, data.Title, data.Status)
+	}
+}
+
+// defaultStatus is the status code of pages rendered without one
+var defaultStatus = map[string]int{
+	NotFound: http.StatusNotFound,
+	Gone:     http.StatusGone,
+	Expired:  http.StatusGone,
+	Password: http.StatusUnauthorized,
+	Warning:  http.StatusOK,
+}
+
+// defaultMessage is the message of pages rendered without one
+var defaultMessage = map[string]string{
+	NotFound: "This short link doesn't exist.",
+	Gone:     "This short link was deleted by its owner.",
+	Expired:  "This short link is no longer active.",
+	Password: "This link is protected, enter its password to continue.",
+	Warning:  "This link leads to a site that may be unsafe.",
+}
+
+// Negotiate returns the offer the Accept header prefers, the first offer wins ties and is returned
+// when accept is empty or matches nothing
+func Negotiate(accept string, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+
+	best, bestQ, bestSpecificity := offers[0], 0.0, -1
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		for _, offer := range offers {
+			specificity := matches(mediaType, offer)
+			if specificity < 0 {
+				continue
+			}
+
+			if q > bestQ || (q == bestQ && specificity > bestSpecificity) {
+				best, bestQ, bestSpecificity = offer, q, specificity
+			}
+		}
+	}
+
+	if bestQ == 0 {
+		return offers[0]
+	}
+
+	return best
+}
+
+// matches returns how specifically mediaType (possibly a wildcard) matches offer, -1 if it doesn't
+func matches(mediaType, offer string) int {
+	switch {
+	case mediaType == offer:
+		return 2
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,145 @@
+package pages_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/pages"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Negotiate(t *testing.T) {
+	offers := []string{pages.TypePlain, pages.TypeHTML, pages.TypeJSON}
+
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "no accept", accept: "", want: pages.TypePlain},
+		{name: "anything", accept: "*/*", want: pages.TypePlain},
+		{name: "browser", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,*/*;q=0.8", want: pages.TypeHTML},
+		{name: "json client", accept: "application/json", want: pages.TypeJSON},
+		{name: "quality wins", accept: "text/html;q=0.5, application/json", want: pages.TypeJSON},
+		{name: "specific type wins over wildcard", accept: "*/*, application/json", want: pages.TypeJSON},
+		{name: "unsupported type", accept: "image/png", want: pages.TypePlain},
+		{name: "malformed", accept: ";;;", want: pages.TypePlain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, pages.Negotiate(tt.accept, offers...))
+		})
+	}
+}
+
+func Test_Render(t *testing.T) {
+	p := pages.Default()
+
+	tests := []struct {
+		name        string
+		page        string
+		accept      string
+		data        pages.Data
+		wantStatus  int
+		wantType    string
+		wantContain string
+	}{
+		{name: "plain text", page: pages.Gone, wantStatus: http.StatusGone, wantType: "text/plain; charset=utf-8", wantContain: "Gone\n"},
+		{name: "html", page: pages.NotFound, accept: "text/html", wantStatus: http.StatusNotFound, wantType: "text/html; charset=utf-8", wantContain: "<h1>Link not found</h1>"},
+		{name: "expired", page: pages.Expired, accept: "text/html", wantStatus: http.StatusGone, wantType: "text/html; charset=utf-8", wantContain: "Link expired"},
+		{
+			name:        "password prompt",
+			page:        pages.Password,
+			accept:      "text/html",
+			data:        pages.Data{Action: "/abc/unlock", Error: "Wrong password"},
+			wantStatus:  http.StatusUnauthorized,
+			wantType:    "text/html; charset=utf-8",
+			wantContain: `action="/abc/unlock"`,
+		},
+		{
+			name:        "warning escapes the url",
+			page:        pages.Warning,
+			accept:      "text/html",
+			data:        pages.Data{URL: "javascript:alert(1)"},
+			wantStatus:  http.StatusOK,
+			wantType:    "text/html; charset=utf-8",
+			wantContain: `href="#ZgotmplZ"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			r.Header.Set("Accept", tt.accept)
+
+			w := httptest.NewRecorder()
+			p.Render(w, r, tt.page, tt.data)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantType, w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Body.String(), tt.wantContain)
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		r.Header.Set("Accept", "application/json")
+
+		w := httptest.NewRecorder()
+		p.Render(w, r, pages.Gone, pages.Data{})
+
+		got := pages.Data{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+		assert.Equal(t, pages.Data{Status: http.StatusGone, Title: "Gone", Message: "This short link was deleted by its owner."}, got)
+	})
+}
+
+func Test_New(t *testing.T) {
+	t.Run("overrides", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "layout.html"), []byte(`{{define "layout"}}<brand>{{template "content" .}}</brand>{{end}}`), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "gone.html"), []byte(`{{define "content"}}bye{{end}}{{template "layout" .}}`), 0o600))
+
+		p, err := pages.New(dir)
+		require.NoError(t, err)
+
+		for page, want := range map[string]string{pages.Gone: "<brand>bye</brand>", pages.NotFound: "<h1>Link not found</h1>"} {
+			r := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			r.Header.Set("Accept", "text/html")
+
+			w := httptest.NewRecorder()
+			p.Render(w, r, page, pages.Data{})
+
+			assert.Contains(t, w.Body.String(), want)
+			assert.Contains(t, w.Body.String(), "<brand>", "the layout applies to every page")
+		}
+	})
+
+	t.Run("unknown template", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "notfound.html"), []byte(`x`), 0o600))
+
+		_, err := pages.New(dir)
+		assert.Error(t, err)
+	})
+
+	t.Run("broken template", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "gone.html"), []byte(`{{if}}`), 0o600))
+
+		_, err := pages.New(dir)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing dir", func(t *testing.T) {
+		_, err := pages.New(filepath.Join(t.TempDir(), "missing"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,180 @@
+// Package profiler serves net/http/pprof endpoints and captures CPU and heap profiles into a directory
+package profiler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Profile types accepted by the capture endpoint
+const (
+	TypeCPU  = "cpu"
+	TypeHeap = "heap"
+)
+
+const (
+	defaultCaptureSeconds = 30
+	maxCaptureSeconds     = 300
+)
+
+// ErrBusy is returned when a CPU profile is already being captured
+var ErrBusy = errors.New("a cpu profile is already being captured")
+
+// CaptureResult is returned by the capture endpoint
+type CaptureResult struct {
+	Type    string `json:"type"`
+	File    string `json:"file"`
+	Seconds int    `json:"seconds,omitempty"`
+}
+
+// Profiler captures profiles into Dir
+type Profiler struct {
+	Dir string     // directory the captured profiles are written to
+	mu  sync.Mutex // only one CPU profile can run per process
+}
+
+// New creates a profiler writing captured profiles to dir
+func New(dir string) *Profiler {
+	return &Profiler{Dir: dir}
+}
+
+// Handler returns a router serving the standard pprof endpoints and POST /capture?type=cpu|heap&seconds=N
+func (p *Profiler) Handler() http.Handler {
+	router := chi.NewRouter()
+	router.Post("/capture", p.HandleCapture)
+	router.Mount("/", middleware.Profiler())
+
+	return router
+}
+
+// HandleCapture writes a profile to Dir and responds with its file name.
+// A CPU profile is recorded for the given number of seconds (stopping early if the client goes away),
+// a heap profile is a snapshot taken after a GC and ignores seconds.
+func (p *Profiler) HandleCapture(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("type")
+	if kind == "" {
+		kind = TypeCPU
+	}
+
+	seconds := defaultCaptureSeconds
+
+	if raw := r.URL.Query().Get("seconds"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxCaptureSeconds {
+			http.Error(w, fmt.Sprintf("seconds must be between 1 and %d", maxCaptureSeconds), http.StatusBadRequest)
+			return
+		}
+
+		seconds = n
+	}
+
+	var (
+		file string
+		err  error
+	)
+
+	switch kind {
+	case TypeCPU:
+		file, err = p.CaptureCPU(r, time.Duration(seconds)*time.Second)
+	case TypeHeap:
+		seconds = 0
+		file, err = p.CaptureHeap()
+	default:
+		http.Error(w, "type must be cpu or heap", http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, ErrBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		logger.FromContext(r.Context()).Error("capturing profile", "type", kind, "error", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("profile captured", "type", kind, "file", file)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(CaptureResult{Type: kind, File: file, Seconds: seconds})
+}
+
+// CaptureCPU records a CPU profile for d or until the request context is done
+func (p *Profiler) CaptureCPU(r *http.Request, d time.Duration) (string, error) {
+	if !p.mu.TryLock() {
+		return "", ErrBusy
+	}
+	defer p.mu.Unlock()
+
+	f, path, err := p.create(TypeCPU)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := pprof.StartCPUProfile(f); err != nil {
+		return "", ErrBusy
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	pprof.StopCPUProfile()
+
+	return path, f.Sync()
+}
+
+// CaptureHeap writes a heap profile snapshot
+func (p *Profiler) CaptureHeap() (string, error) {
+	f, path, err := p.create(TypeHeap)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	runtime.GC()
+
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return "", err
+	}
+
+	return path, f.Sync()
+}
+
+// create opens a new profile file named after kind and the current time
+func (p *Profiler) create(kind string) (*os.File, string, error) {
+	if err := os.MkdirAll(p.Dir, 0o755); err != nil {
+		return nil, "", err
+	}
+
+	path := filepath.Join(p.Dir, fmt.Sprintf("%s-%s.pprof", kind, time.Now().UTC().Format("20060102T150405.000")))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return f, path, nil
+}
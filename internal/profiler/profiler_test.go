@@ -0,0 +1,63 @@
+package profiler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/profiler"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Profiler(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+	prof := profiler.New(dir)
+
+	router := chi.NewRouter()
+	router.Mount("/debug", prof.Handler())
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{name: "heap snapshot", target: "/debug/capture?type=heap", wantStatus: http.StatusCreated},
+		{name: "short cpu profile", target: "/debug/capture?type=cpu&seconds=1", wantStatus: http.StatusCreated},
+		{name: "unknown type", target: "/debug/capture?type=goroutine", wantStatus: http.StatusBadRequest},
+		{name: "too long", target: "/debug/capture?seconds=100000", wantStatus: http.StatusBadRequest},
+		{name: "not a number", target: "/debug/capture?seconds=ten", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.target, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			result := profiler.CaptureResult{}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+			assert.Equal(t, dir, filepath.Dir(result.File))
+
+			info, err := os.Stat(result.File)
+			assert.NoError(t, err)
+			assert.NotZero(t, info.Size())
+		})
+	}
+
+	t.Run("pprof index is served", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/jackc/pgx/v5"
+)
+
+// changesChannel is the channel notify_url_changes created in InitDBStorage publishes URL changes on.
+// A notification carries the kind of the change and domain/hash of changed URLs separated by newlines,
+// or flushPayload when a statement changed too many URLs to list them.
+const changesChannel = "url_changes"
+
+// flushPayload asks to drop all cached URLs
+const flushPayload = "*"
+
+// Kinds of URL changes
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Listen backoff bounds
+const (
+	minListenBackoff = time.Second
+	maxListenBackoff = 30 * time.Second
+)
+
+// Invalidator drops cached copies of URLs
+type Invalidator interface {
+	Evict(domain, hash string) // drops the URL of hash on domain
+	Flush()                    // drops all URLs
+}
+
+// ChangeFlusher is an Invalidator which handles statements changing many URLs depending on the kind
+// of the change. FlushChanges is called for them instead of Flush.
+type ChangeFlusher interface {
+	Invalidator
+	FlushChanges(kind string) // handles an unknown set of URLs changed by kind
+}
+
+// Subscription receives payloads of URL change notifications
+type Subscription interface {
+	Wait(ctx context.Context) (string, error) // returns the payload of the next notification
+	Close()                                   // ends the subscription
+}
+
+// Subscriber opens a subscription to URL changes
+type Subscriber func(ctx context.Context) (Subscription, error)
+
+// ListenChanges subscribes to URL changes made by any instance on a dedicated connection and passes
+// them to invs until ctx is done, see Listen
+func (db *DBStorage) ListenChanges(ctx context.Context, invs ...Invalidator) {
+	Listen(ctx, db.subscribe, invs...)
+}
+
+// Listen passes URL changes received by subscriptions of subscribe to invs until ctx is done.
+// Notifications sent while the subscription is down are lost, so invs are flushed whenever it drops
+// and again once it is restored. Subscribing is retried with a backoff.
+func Listen(ctx context.Context, subscribe Subscriber, invs ...Invalidator) {
+	inv, backoff := invalidators(invs), minListenBackoff
+
+	for {
+		subscribed, err := listen(ctx, subscribe, inv)
+		if ctx.Err() != nil {
+			return
+		}
+
+		inv.Flush()
+
+		if subscribed {
+			backoff = minListenBackoff
+		}
+
+		logger.FromContext(ctx).Warn("url change subscription dropped", "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, maxListenBackoff)
+	}
+}
+
+// listen subscribes and applies notifications until the subscription fails. subscribed
+// reports whether subscribing succeeded.
+func listen(ctx context.Context, subscribe Subscriber, inv invalidators) (subscribed bool, err error) {
+	sub, err := subscribe(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	defer sub.Close()
+
+	// URLs changed before subscribing may have been cached
+	inv.Flush()
+	logger.FromContext(ctx).Info("subscribed to url changes")
+
+	for {
+		payload, err := sub.Wait(ctx)
+		if err != nil {
+			return true, err
+		}
+
+		applyNotification(inv, payload)
+	}
+}
+
+// pgSubscription is a LISTEN on a dedicated connection
+type pgSubscription struct {
+	conn *pgx.Conn
+}
+
+func (s pgSubscription) Wait(ctx context.Context) (string, error) {
+	n, err := s.conn.WaitForNotification(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return n.Payload, nil
+}
+
+func (s pgSubscription) Close() {
+	s.conn.Close(context.Background())
+}
+
+// subscribe connects to the database and listens on changesChannel
+func (db *DBStorage) subscribe(ctx context.Context) (Subscription, error) {
+	conn, err := pgx.Connect(ctx, db.cfg.DatabaseDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = conn.Exec(ctx, "LISTEN "+changesChannel); err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+
+	return pgSubscription{conn}, nil
+}
+
+// invalidators passes calls to all of its elements
+type invalidators []Invalidator
+
+func (invs invalidators) Evict(domain, hash string) {
+	for _, inv := range invs {
+		inv.Evict(domain, hash)
+	}
+}
+
+func (invs invalidators) Flush() {
+	for _, inv := range invs {
+		inv.Flush()
+	}
+}
+
+func (invs invalidators) FlushChanges(kind string) {
+	for _, inv := range invs {
+		if f, ok := inv.(ChangeFlusher); ok {
+			f.FlushChanges(kind)
+		} else {
+			inv.Flush()
+		}
+	}
+}
+
+// applyNotification evicts the URLs named by payload or flushes inv. Payloads without a kind
+// come from instances predating it and carry a single URL.
+func applyNotification(inv invalidators, payload string) {
+	kind, codes, ok := strings.Cut(payload, " ")
+	if !ok || (kind != ChangeInsert && kind != ChangeUpdate && kind != ChangeDelete) {
+		kind, codes = "", payload
+	}
+
+	for _, code := range strings.Split(codes, "\n") {
+		domain, hash, ok := strings.Cut(code, "/")
+
+		switch {
+		case code == flushPayload && kind != "":
+			inv.FlushChanges(kind)
+		case code == flushPayload || !ok:
+			inv.Flush()
+		default:
+			inv.Evict(domain, hash)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder records invalidations
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, call)
+}
+
+func (r *recorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.calls...)
+}
+
+func (r *recorder) Evict(domain, hash string) {
+	r.record("evict " + domain + "/" + hash)
+}
+
+func (r *recorder) Flush() {
+	r.record("flush")
+}
+
+// changeRecorder also tells kinds of bulk changes apart
+type changeRecorder struct {
+	recorder
+}
+
+func (r *changeRecorder) FlushChanges(kind string) {
+	r.record("flush " + kind)
+}
+
+// syncBuffer collects logs written concurrently
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// fakeSubscription delivers payloads until the channel is closed, then it fails like a dropped connection
+type fakeSubscription struct {
+	payloads chan string
+	closed   atomic.Bool
+}
+
+func newFakeSubscription(payloads ...string) *fakeSubscription {
+	s := &fakeSubscription{payloads: make(chan string, len(payloads))}
+	for _, p := range payloads {
+		s.payloads <- p
+	}
+
+	return s
+}
+
+func (s *fakeSubscription) Wait(ctx context.Context) (string, error) {
+	select {
+	case p, ok := <-s.payloads:
+		if !ok {
+			return "", errors.New("connection lost")
+		}
+
+		return p, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (s *fakeSubscription) Close() {
+	s.closed.Store(true)
+}
+
+// subscriber hands out subs in order, later subscriptions fail
+func subscriber(subs ...*fakeSubscription) storage.Subscriber {
+	mu, next := sync.Mutex{}, 0
+
+	return func(ctx context.Context) (storage.Subscription, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if next == len(subs) {
+			return nil, errors.New("database is down")
+		}
+
+		next++
+
+		return subs[next-1], nil
+	}
+}
+
+func Test_ListenPayloads(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     string
+		wantPlain   []string
+		wantChanges []string
+	}{
+		{
+			name:        "single url",
+			payload:     "update brand.io/abc",
+			wantPlain:   []string{"evict brand.io/abc"},
+			wantChanges: []string{"evict brand.io/abc"},
+		},
+		{
+			name:        "inserted batch",
+			payload:     "insert brand.io/abc\n/def",
+			wantPlain:   []string{"evict brand.io/abc", "evict /def"},
+			wantChanges: []string{"evict brand.io/abc", "evict /def"},
+		},
+		{
+			name:        "bulk delete",
+			payload:     "delete *",
+			wantPlain:   []string{"flush"},
+			wantChanges: []string{"flush delete"},
+		},
+		{
+			name:        "bulk insert",
+			payload:     "insert *",
+			wantPlain:   []string{"flush"},
+			wantChanges: []string{"flush insert"},
+		},
+		{
+			name:        "url without a kind",
+			payload:     "brand.io/abc",
+			wantPlain:   []string{"evict brand.io/abc"},
+			wantChanges: []string{"evict brand.io/abc"},
+		},
+		{
+			name:        "flush without a kind",
+			payload:     "*",
+			wantPlain:   []string{"flush"},
+			wantChanges: []string{"flush"},
+		},
+		{
+			name:        "malformed",
+			payload:     "nonsense",
+			wantPlain:   []string{"flush"},
+			wantChanges: []string{"flush"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			plain, changes := &recorder{}, &changeRecorder{}
+			sub := newFakeSubscription(tt.payload)
+
+			done := make(chan struct{})
+
+			go func() {
+				storage.Listen(ctx, subscriber(sub), plain, changes)
+				close(done)
+			}()
+
+			// every invalidator is flushed once subscribed, then gets the payload
+			wantPlain := append([]string{"flush"}, tt.wantPlain...)
+			wantChanges := append([]string{"flush"}, tt.wantChanges...)
+
+			assert.Eventually(t, func() bool {
+				return len(plain.Calls()) == len(wantPlain) && len(changes.Calls()) == len(wantChanges)
+			}, time.Second, time.Millisecond)
+
+			cancel()
+			<-done
+
+			assert.Equal(t, wantPlain, plain.Calls())
+			assert.Equal(t, wantChanges, changes.Calls())
+			assert.True(t, sub.closed.Load())
+		})
+	}
+}
+
+func Test_ListenReconnect(t *testing.T) {
+	logs := &syncBuffer{}
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background(), slog.New(slog.NewTextHandler(logs, nil))))
+	defer cancel()
+
+	first, second := newFakeSubscription("insert /abc"), newFakeSubscription()
+	inv := &recorder{}
+
+	done := make(chan struct{})
+
+	go func() {
+		storage.Listen(ctx, subscriber(first, second), inv)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return len(inv.Calls()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"flush", "evict /abc"}, inv.Calls())
+
+	close(first.payloads)
+
+	require.Eventually(t, func() bool { return len(inv.Calls()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, "flush", inv.Calls()[2], "flushed when the subscription drops")
+	assert.True(t, first.closed.Load())
+
+	require.Eventually(t, func() bool { return len(inv.Calls()) == 4 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "flush", inv.Calls()[3], "flushed again once it is restored")
+
+	cancel()
+	<-done
+
+	assert.True(t, second.closed.Load())
+	assert.Contains(t, logs.String(), "url change subscription dropped", "logged with the logger of ctx")
+}
@@ -3,10 +3,18 @@ package storage
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -16,26 +24,130 @@ type DBStorage struct {
 	cfg  config.Config // config containing dsn link
 }
 
+// schemaLockID is the key of the advisory lock held while the schema is migrated
+const schemaLockID = 7460218346
+
+// schema creates or migrates the tables and replaces the change notification triggers
+const schema = `
+	CREATE TABLE IF NOT EXISTS 
+	URLS 
+	(user_uid varchar, url_hash varchar, original_url varchar, is_deleted bool default false);
+
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS workspace_id varchar;
+
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS domain varchar NOT NULL DEFAULT '';
+
+	-- NULL for urls saved before creation times were recorded
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at timestamptz;
+
+	-- user URLs are read in pages ordered by domain and hash, see ForEachUserURL
+	CREATE INDEX IF NOT EXISTS uid_domain_hash_index ON urls
+	(user_uid, domain, url_hash);
+
+	-- user URLs are listed in pages ordered by creation time, see QueryURLs
+	CREATE INDEX IF NOT EXISTS uid_created_index ON urls
+	(user_uid, COALESCE(created_at, '-infinity'::timestamptz), domain, url_hash);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS domain_hash_index ON urls
+	(domain, url_hash);
+
+	-- hashes used to be unique across domains
+	DROP INDEX IF EXISTS hash_index;
+
+	-- changes are published to instances caching URLs, see ListenChanges. Inserted codes are
+	-- sent in batches of up to 7000 bytes, so bulk inserts don't make other instances rebuild.
+	CREATE OR REPLACE FUNCTION notify_url_changes() RETURNS trigger AS $$
+	DECLARE
+		op text := lower(TG_OP);
+		changes bigint := (SELECT count(*) FROM changed);
+	BEGIN
+		IF op = 'insert' AND changes <= 10000 THEN
+			PERFORM pg_notify('url_changes', op || ' ' || string_agg(code, E'\n'))
+			FROM (
+				SELECT code, sum(length(code) + 1) OVER (ROWS UNBOUNDED PRECEDING) / 7000 AS batch
+				FROM (SELECT domain || '/' || url_hash AS code FROM changed) codes
+			) batches
+			GROUP BY batch;
+		ELSIF changes > 100 THEN
+			PERFORM pg_notify('url_changes', op || ' *');
+		ELSE
+			PERFORM pg_notify('url_changes', op || ' ' || domain || '/' || url_hash) FROM changed;
+		END IF;
+
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS urls_inserted ON urls;
+	CREATE TRIGGER urls_inserted AFTER INSERT ON urls REFERENCING NEW TABLE AS changed
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_url_changes();
+
+	DROP TRIGGER IF EXISTS urls_updated ON urls;
+	CREATE TRIGGER urls_updated AFTER UPDATE ON urls REFERENCING OLD TABLE AS changed
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_url_changes();
+
+	DROP TRIGGER IF EXISTS urls_deleted ON urls;
+	CREATE TRIGGER urls_deleted AFTER DELETE ON urls REFERENCING OLD TABLE AS changed
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_url_changes();
+
+	CREATE TABLE IF NOT EXISTS
+	workspaces
+	(id varchar PRIMARY KEY, name varchar NOT NULL);
+
+	CREATE TABLE IF NOT EXISTS
+	workspace_members
+	(workspace_id varchar REFERENCES workspaces(id) ON DELETE CASCADE, uid varchar, role varchar NOT NULL,
+	PRIMARY KEY (workspace_id, uid));
+
+	CREATE TABLE IF NOT EXISTS
+	users
+	(uid varchar PRIMARY KEY, login varchar UNIQUE NOT NULL, password_hash varchar NOT NULL);
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS session_version int NOT NULL DEFAULT 0;
+`
+
+// migrate applies schema in a transaction holding an advisory lock, so instances starting together
+// don't drop each other's triggers and other sessions never see the urls table without them
+func migrate(ctx context.Context, conn *pgxpool.Pool) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", schemaLockID); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(ctx, schema); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
 // InitDBStorage inits a DB storage using cfg config
 // Creates a URL schema if it doesn't exist
 func InitDBStorage(cfg *config.Config) (*DBStorage, error) {
-	conn, err := pgxpool.New(context.Background(), cfg.DatabaseDSN)
+	ctx := context.Background()
+
+	poolCfg, err := pgxpool.ParseConfig(cfg.DatabaseDSN)
 	if err != nil {
-		log.Printf("Unable to connect to database: %v\n", err.Error())
+		logger.FromContext(ctx).Error("unable to parse database dsn", "error", err)
 		return nil, err
 	}
 
-	_, err = conn.Exec(context.Background(), `
-	CREATE TABLE IF NOT EXISTS 
-	URLS 
-	(user_uid varchar, url_hash varchar, original_url varchar, is_deleted bool default false);
-
-	CREATE UNIQUE INDEX IF NOT EXISTS hash_index ON urls
-	(url_hash);
-	`)
+	poolCfg.ConnConfig.Tracer = queryTracer{}
 
+	conn, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
-		log.Printf("Unable to create db: %v\n", err.Error())
+		logger.FromContext(ctx).Error("unable to connect to database", "error", err)
+		return nil, err
+	}
+
+	if err = migrate(ctx, conn); err != nil {
+		logger.FromContext(ctx).Error("unable to create db", "error", err)
 		return nil, err
 	}
 
@@ -43,12 +155,12 @@ func InitDBStorage(cfg *config.Config) (*DBStorage, error) {
 }
 
 // SaveURL performs SQL request saving url with hash binding it to a user with certain uid
-func (db *DBStorage) SaveURL(ctx context.Context, url, uid, hash string) error {
+func (db *DBStorage) SaveURL(ctx context.Context, u URL) error {
 	sqlStatement := `
-	INSERT INTO urls (user_uid, url_hash, original_url)
-	VALUES ($1, $2, $3)`
+	INSERT INTO urls (user_uid, url_hash, original_url, workspace_id, domain, created_at)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)`
 
-	_, err := db.conn.Exec(ctx, sqlStatement, uid, hash, url)
+	_, err := db.conn.Exec(ctx, sqlStatement, u.UID, u.ShortURL, u.URL, u.WorkspaceID, u.Domain, nullTime(u.CreatedAt))
 
 	if err != nil {
 		return err
@@ -57,12 +169,14 @@ func (db *DBStorage) SaveURL(ctx context.Context, url, uid, hash string) error {
 	return nil
 }
 
-// GetURL returns an URL bound to a hash passed
-func (db *DBStorage) GetURL(ctx context.Context, hash string) (URL, error) {
-	row := db.conn.QueryRow(ctx, "Select * from urls where url_hash = $1", hash)
+// GetURL returns an URL bound to a hash passed on domain or ErrNotFound
+func (db *DBStorage) GetURL(ctx context.Context, domain, hash string) (URL, error) {
+	row := db.conn.QueryRow(ctx, "SELECT "+urlColumns+" FROM urls WHERE domain = $1 AND url_hash = $2", domain, hash)
 
-	u := URL{}
-	err := row.Scan(&u.UID, &u.ShortURL, &u.URL, &u.IsDeleted)
+	u, err := scanURL(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return URL{}, ErrNotFound
+	}
 
 	if err != nil {
 		return URL{}, err
@@ -71,11 +185,124 @@ func (db *DBStorage) GetURL(ctx context.Context, hash string) (URL, error) {
 	return u, nil
 }
 
+// urlColumns are selected by every query returning URLs, see scanURL
+const urlColumns = "user_uid, url_hash, original_url, is_deleted, COALESCE(workspace_id, ''), domain, created_at"
+
+func scanURL(row pgx.Row) (URL, error) {
+	u := URL{}
+
+	var createdAt *time.Time
+
+	err := row.Scan(&u.UID, &u.ShortURL, &u.URL, &u.IsDeleted, &u.WorkspaceID, &u.Domain, &createdAt)
+	if createdAt != nil {
+		u.CreatedAt = *createdAt
+	}
+
+	return u, err
+}
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
+
 // GetUrlsByUID returns a list of URLs belonging to a given user
 func (db *DBStorage) GetUrlsByUID(ctx context.Context, uid string) ([]URL, error) {
+	return db.queryURLs(ctx, "SELECT "+urlColumns+" FROM urls WHERE user_uid = $1 AND NOT is_deleted", uid)
+}
+
+// createdKey orders URLs without a creation time first, it matches uid_created_index
+const createdKey = "COALESCE(created_at, '-infinity'::timestamptz)"
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// QueryURLs returns the URLs of q.UID matching q in its order. Pages are read with a keyset condition
+// on the ordering columns, so every page is an index range scan of the user URLs.
+func (db *DBStorage) QueryURLs(ctx context.Context, q URLQuery) ([]URL, error) {
+	args := []any{q.UID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{"user_uid = $1"}
+
+	switch q.Status {
+	case StatusDeleted:
+		where = append(where, "is_deleted")
+	case StatusAll:
+	default:
+		where = append(where, "NOT is_deleted")
+	}
+
+	if q.Domain != nil {
+		where = append(where, "domain = "+arg(*q.Domain))
+	}
+
+	if q.Search != "" {
+		pattern := arg("%" + likeEscaper.Replace(q.Search) + "%")
+		where = append(where, "(original_url ILIKE "+pattern+" OR url_hash ILIKE "+pattern+")")
+	}
+
+	if !q.CreatedFrom.IsZero() {
+		where = append(where, "created_at >= "+arg(q.CreatedFrom))
+	}
+
+	if !q.CreatedTo.IsZero() {
+		where = append(where, "created_at < "+arg(q.CreatedTo))
+	}
+
+	key := []string{"domain", "url_hash"}
+	if q.Sort == SortCreated {
+		key = append([]string{createdKey}, key...)
+	}
+
+	if q.After != nil {
+		after := []string{arg(q.After.Domain), arg(q.After.ShortURL)}
+		if q.Sort == SortCreated {
+			createdAt := pgtype.Timestamptz{Time: q.After.CreatedAt, Valid: true}
+			if q.After.CreatedAt.IsZero() {
+				createdAt = pgtype.Timestamptz{InfinityModifier: pgtype.NegativeInfinity, Valid: true}
+			}
+
+			after = append([]string{arg(createdAt)}, after...)
+		}
+
+		op := " > "
+		if q.Desc {
+			op = " < "
+		}
+
+		where = append(where, "("+strings.Join(key, ", ")+")"+op+"("+strings.Join(after, ", ")+")")
+	}
+
+	order := strings.Join(key, ", ")
+	if q.Desc {
+		order = strings.Join(key, " DESC, ") + " DESC"
+	}
+
+	query := "SELECT " + urlColumns + " FROM urls WHERE " + strings.Join(where, " AND ") + " ORDER BY " + order
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	return db.queryURLs(ctx, query, args...)
+}
+
+// GetUrlsByWorkspace returns all URLs of a workspace
+func (db *DBStorage) GetUrlsByWorkspace(ctx context.Context, workspaceID string) ([]URL, error) {
+	return db.queryURLs(ctx, "SELECT "+urlColumns+" FROM urls WHERE workspace_id = $1 AND NOT is_deleted", workspaceID)
+}
+
+func (db *DBStorage) queryURLs(ctx context.Context, query string, args ...any) ([]URL, error) {
 	urls := make([]URL, 0)
 
-	rows, err := db.conn.Query(ctx, "SELECT hash, original_url from urls where user_uid = $1", uid)
+	rows, err := db.conn.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -83,9 +310,7 @@ func (db *DBStorage) GetUrlsByUID(ctx context.Context, uid string) ([]URL, error
 	defer rows.Close()
 
 	for rows.Next() {
-		var u URL
-		err = rows.Scan(&u.ShortURL, &u.URL)
-
+		u, err := scanURL(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -112,8 +337,69 @@ func (db *DBStorage) IsAlive(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
-// BatchSaveURL saves a list of URLs to a db
+// IsWritable returns false if the database is a read-only replica
+func (db *DBStorage) IsWritable(ctx context.Context) (bool, error) {
+	var inRecovery bool
+	if err := db.conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		return false, err
+	}
+
+	if inRecovery {
+		return false, errors.New("database is in recovery")
+	}
+
+	return true, nil
+}
+
+// schemaTables are created by InitDBStorage
+var schemaTables = []string{"urls", "users", "workspaces", "workspace_members"}
+
+// schemaURLColumns are added to urls by InitDBStorage after the table was first created
+var schemaURLColumns = []string{"workspace_id", "domain", "created_at"}
+
+// CheckSchema checks that the tables and columns created by InitDBStorage exist
+func (db *DBStorage) CheckSchema(ctx context.Context) error {
+	rows, err := db.conn.Query(ctx, "SELECT t FROM unnest($1::text[]) AS t WHERE to_regclass(t) IS NULL", schemaTables)
+	if err != nil {
+		return err
+	}
+
+	missing, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+
+	rows, err = db.conn.Query(ctx, `SELECT c FROM unnest($1::text[]) AS c WHERE NOT EXISTS (
+	SELECT 1 FROM information_schema.columns WHERE table_name = 'urls' AND column_name = c)`, schemaURLColumns)
+	if err != nil {
+		return err
+	}
+
+	missing, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing columns: urls.%s", strings.Join(missing, ", urls."))
+	}
+
+	return nil
+}
+
+// copyMinRows is the smallest batch BatchSaveURL copies, COPY costs an extra round trip to set up
+const copyMinRows = 64
+
+// BatchSaveURL saves a list of URLs to a db, large lists are copied. Either all URLs are saved or none.
 func (db *DBStorage) BatchSaveURL(ctx context.Context, urls []URL) error {
+	if len(urls) >= copyMinRows {
+		return db.copyURLs(ctx, urls)
+	}
+
 	tx, err := db.conn.Begin(ctx)
 	if err != nil {
 		return err
@@ -121,13 +407,13 @@ func (db *DBStorage) BatchSaveURL(ctx context.Context, urls []URL) error {
 
 	defer tx.Rollback(ctx)
 
-	stmt, err := tx.Prepare(ctx, "batch insert", "INSERT INTO urls(user_uid, url_hash, original_url) VALUES($1,$2,$3)")
+	stmt, err := tx.Prepare(ctx, "batch insert", "INSERT INTO urls(user_uid, url_hash, original_url, workspace_id, domain, created_at) VALUES($1,$2,$3,NULLIF($4,''),$5,$6)")
 	if err != nil {
 		return err
 	}
 
 	for _, u := range urls {
-		if _, err = tx.Exec(ctx, stmt.Name, u.UID, u.ShortURL, u.URL); err != nil {
+		if _, err = tx.Exec(ctx, stmt.Name, u.UID, u.ShortURL, u.URL, u.WorkspaceID, u.Domain, nullTime(u.CreatedAt)); err != nil {
 			return err
 		}
 	}
@@ -135,25 +421,325 @@ func (db *DBStorage) BatchSaveURL(ctx context.Context, urls []URL) error {
 	return tx.Commit(ctx)
 }
 
+// copyURLs saves urls with COPY
+func (db *DBStorage) copyURLs(ctx context.Context, urls []URL) error {
+	_, err := db.conn.CopyFrom(ctx, pgx.Identifier{"urls"},
+		[]string{"user_uid", "url_hash", "original_url", "workspace_id", "domain", "created_at"},
+		pgx.CopyFromSlice(len(urls), func(i int) ([]any, error) {
+			u := urls[i]
+
+			var workspaceID any
+			if u.WorkspaceID != "" {
+				workspaceID = u.WorkspaceID
+			}
+
+			return []any{u.UID, u.ShortURL, u.URL, workspaceID, u.Domain, nullTime(u.CreatedAt)}, nil
+		}))
+
+	return err
+}
+
 // KillConn gracefully stops a db connection
 func (db *DBStorage) KillConn() error {
 	db.conn.Close()
 	return nil
 }
 
-// DeleteURLs deletes URLs from the DB (not actually removing them, but marking as deleted)
-func (db *DBStorage) DeleteURLs(ctx context.Context, entries []DeletionEntry) error {
+// DeleteURLs deletes URLs from the DB (not actually removing them, but marking as deleted).
+// It returns the deleted URLs, entries of missing, already deleted or foreign URLs are skipped.
+func (db *DBStorage) DeleteURLs(ctx context.Context, entries []DeletionEntry) ([]URL, error) {
 	b := pgx.Batch{}
 	for _, e := range entries {
-		b.Queue("UPDATE urls set is_deleted = true WHERE user_uid = $1 and url_hash = $2", e.UID, e.Hash)
+		b.Queue(`UPDATE urls SET is_deleted = true WHERE domain = $5 AND url_hash = $2 AND NOT is_deleted AND (
+			(workspace_id IS NULL AND user_uid = $1) OR
+			workspace_id IN (SELECT workspace_id FROM workspace_members WHERE uid = $1 AND role IN ($3, $4))
+		) RETURNING `+urlColumns, e.UID, e.Hash, RoleOwner, RoleEditor, e.Domain)
+	}
+
+	br := db.conn.SendBatch(ctx, &b)
+	defer br.Close()
+
+	deleted := []URL{}
+
+	for range entries {
+		u, err := scanURL(br.QueryRow())
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		deleted = append(deleted, u)
+	}
+
+	return deleted, br.Close()
+}
+
+// SaveUser inserts a new user, ErrUserExists is returned if the login or uid is taken
+func (db *DBStorage) SaveUser(ctx context.Context, u User) error {
+	_, err := db.conn.Exec(ctx, "INSERT INTO users (uid, login, password_hash, session_version) VALUES ($1, $2, $3, $4)",
+		u.UID, u.Login, u.PasswordHash, u.SessionVersion)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return ErrUserExists
+	}
+
+	return err
+}
+
+// GetUserByLogin returns a user with the login or ErrNotFound
+func (db *DBStorage) GetUserByLogin(ctx context.Context, login string) (User, error) {
+	return db.getUser(ctx, "SELECT uid, login, password_hash, session_version FROM users WHERE login = $1", login)
+}
+
+// GetUserByUID returns a user with the uid or ErrNotFound
+func (db *DBStorage) GetUserByUID(ctx context.Context, uid string) (User, error) {
+	return db.getUser(ctx, "SELECT uid, login, password_hash, session_version FROM users WHERE uid = $1", uid)
+}
+
+// UpdateUser updates the password hash and the session version of a user with the login or returns ErrNotFound
+func (db *DBStorage) UpdateUser(ctx context.Context, u User) error {
+	tag, err := db.conn.Exec(ctx, "UPDATE users SET password_hash = $2, session_version = $3 WHERE login = $1",
+		u.Login, u.PasswordHash, u.SessionVersion)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (db *DBStorage) getUser(ctx context.Context, query, arg string) (User, error) {
+	u := User{}
+
+	err := db.conn.QueryRow(ctx, query, arg).Scan(&u.UID, &u.Login, &u.PasswordHash, &u.SessionVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+// ReassignURLs binds all URLs of fromUID to toUID and returns the number of moved URLs
+func (db *DBStorage) ReassignURLs(ctx context.Context, fromUID, toUID string) (int, error) {
+	tag, err := db.conn.Exec(ctx, "UPDATE urls SET user_uid = $2 WHERE user_uid = $1", fromUID, toUID)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// CreateWorkspace creates a workspace and makes ownerUID its owner in one transaction
+func (db *DBStorage) CreateWorkspace(ctx context.Context, ws Workspace, ownerUID string) error {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, "INSERT INTO workspaces (id, name) VALUES ($1, $2)", ws.ID, ws.Name); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO workspace_members (workspace_id, uid, role) VALUES ($1, $2, $3)", ws.ID, ownerUID, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetWorkspacesByUID returns the workspaces the user is a member of with the user role filled in
+func (db *DBStorage) GetWorkspacesByUID(ctx context.Context, uid string) ([]Workspace, error) {
+	result := []Workspace{}
+
+	rows, err := db.conn.Query(ctx, `
+	SELECT w.id, w.name, m.role FROM workspaces w
+	JOIN workspace_members m ON m.workspace_id = w.id
+	WHERE m.uid = $1`, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		ws := Workspace{}
+		if err = rows.Scan(&ws.ID, &ws.Name, &ws.Role); err != nil {
+			return nil, err
+		}
+
+		result = append(result, ws)
+	}
+
+	return result, rows.Err()
+}
+
+// GetMembers returns members of a workspace
+func (db *DBStorage) GetMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	result := []Member{}
+
+	rows, err := db.conn.Query(ctx, "SELECT uid, role FROM workspace_members WHERE workspace_id = $1", workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		m := Member{WorkspaceID: workspaceID}
+		if err = rows.Scan(&m.UID, &m.Role); err != nil {
+			return nil, err
+		}
+
+		result = append(result, m)
 	}
 
-	br := db.conn.SendBatch(context.Background(), &b)
-	err := br.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return result, nil
+}
+
+// GetRole returns the role of a user in a workspace or ErrNotFound if the user isn't a member
+func (db *DBStorage) GetRole(ctx context.Context, workspaceID, uid string) (string, error) {
+	var role string
+
+	err := db.conn.QueryRow(ctx, "SELECT role FROM workspace_members WHERE workspace_id = $1 AND uid = $2", workspaceID, uid).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
 
+	return role, err
+}
+
+// SetMember adds a member to a workspace or changes the member role
+func (db *DBStorage) SetMember(ctx context.Context, m Member) error {
+	_, err := db.conn.Exec(ctx, `
+	INSERT INTO workspace_members (workspace_id, uid, role) VALUES ($1, $2, $3)
+	ON CONFLICT (workspace_id, uid) DO UPDATE SET role = EXCLUDED.role`, m.WorkspaceID, m.UID, m.Role)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// RemoveMember removes a member from a workspace
+func (db *DBStorage) RemoveMember(ctx context.Context, workspaceID, uid string) error {
+	tag, err := db.conn.Exec(ctx, "DELETE FROM workspace_members WHERE workspace_id = $1 AND uid = $2", workspaceID, uid)
 	if err != nil {
 		return err
 	}
 
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
 	return nil
 }
+
+// ForEachURL streams all URLs ordered by domain and hash to fn
+func (db *DBStorage) ForEachURL(ctx context.Context, fn func(URL) error) error {
+	rows, err := db.conn.Query(ctx, "SELECT "+urlColumns+" FROM urls ORDER BY domain, url_hash")
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanURL(rows)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// userURLsPage is how many URLs ForEachUserURL reads per query
+const userURLsPage = 1000
+
+// ForEachUserURL calls fn for the URLs of uid ordered by domain and hash. URLs are read in pages after
+// the last domain and hash seen, so no connection is held while fn runs.
+func (db *DBStorage) ForEachUserURL(ctx context.Context, uid string, fn func(URL) error) error {
+	domain, hash := "", "" // hashes aren't empty, so the first page starts before every URL
+
+	for {
+		urls, err := db.queryURLs(ctx, "SELECT "+urlColumns+` FROM urls
+		WHERE user_uid = $1 AND (domain, url_hash) > ($2, $3)
+		ORDER BY domain, url_hash LIMIT $4`, uid, domain, hash, userURLsPage)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range urls {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+
+		if len(urls) < userURLsPage {
+			return nil
+		}
+
+		domain, hash = urls[len(urls)-1].Domain, urls[len(urls)-1].ShortURL
+	}
+}
+
+// ForEachUser streams all users ordered by login to fn
+func (db *DBStorage) ForEachUser(ctx context.Context, fn func(User) error) error {
+	rows, err := db.conn.Query(ctx, "SELECT uid, login, password_hash, session_version FROM users ORDER BY login")
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		u := User{}
+		if err := rows.Scan(&u.UID, &u.Login, &u.PasswordHash, &u.SessionVersion); err != nil {
+			return err
+		}
+
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// PurgeDeleted removes soft-deleted URLs
+func (db *DBStorage) PurgeDeleted(ctx context.Context) (int, error) {
+	tag, err := db.conn.Exec(ctx, "DELETE FROM urls WHERE is_deleted")
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
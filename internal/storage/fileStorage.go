@@ -7,80 +7,252 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/T-V-N/gourlshortener/internal/config"
 )
 
 // FileStorage is for file storage
 type FileStorage struct {
-	db  map[string]URL // db here is a simple map hash to url string
-	cfg config.Config  // config
+	mu         sync.RWMutex                 // guards db, users and workspaces
+	db         map[string]URL               // db here is a simple map of urlKey to url
+	byUID      map[string]map[string]bool   // keys of db by owner uid, see put
+	users      map[string]User              // registered users by login
+	logins     map[string]string            // logins of registered users by uid
+	workspaces map[string]Workspace         // workspaces by id
+	members    map[string]map[string]string // workspace id to member uid to role
+	cfg        config.Config                // config
+	urls       *fileWriter                  // appends to the storage file, nil if the file storage is disabled
+}
+
+// fileRecord is a line of the storage file. Unlike URL it keeps the owner uid.
+type fileRecord struct {
+	UID         string `json:"uid,omitempty"`
+	ShortURL    string `json:"short_url"`
+	URL         string `json:"original_url"`
+	IsDeleted   bool
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	Domain      string    `json:"domain,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// put stores u under key keeping byUID in sync, the caller must hold st.mu
+func (st *FileStorage) put(key string, u URL) {
+	if old, ok := st.db[key]; ok && old.UID != u.UID {
+		st.unindex(key, old.UID)
+	}
+
+	st.db[key] = u
+
+	keys, ok := st.byUID[u.UID]
+	if !ok {
+		keys = map[string]bool{}
+		st.byUID[u.UID] = keys
+	}
+
+	keys[key] = true
+}
+
+// remove drops the URL stored under key, the caller must hold st.mu
+func (st *FileStorage) remove(key string) {
+	if old, ok := st.db[key]; ok {
+		st.unindex(key, old.UID)
+		delete(st.db, key)
+	}
+}
+
+func (st *FileStorage) unindex(key, uid string) {
+	delete(st.byUID[uid], key)
+
+	if len(st.byUID[uid]) == 0 {
+		delete(st.byUID, uid)
+	}
+}
+
+// urlKey is the key of an URL in FileStorage.db, URLs of the default domain are keyed by the hash alone
+func urlKey(domain, hash string) string {
+	if domain == "" {
+		return hash
+	}
+
+	return domain + "/" + hash
+}
+
+// workspaceRecord is a line of the workspaces file: either a new workspace or a membership change
+type workspaceRecord struct {
+	Workspace *Workspace `json:"workspace,omitempty"`
+	Member    *Member    `json:"member,omitempty"`
+	Removed   bool       `json:"removed,omitempty"`
+}
+
+func (st *FileStorage) usersPath() string {
+	return st.cfg.FileStoragePath + ".users"
+}
+
+func (st *FileStorage) workspacesPath() string {
+	return st.cfg.FileStoragePath + ".workspaces"
 }
 
 // InitFileStorage inits a file storage using cfg config
 // Creates a file and uses as a storage
 func InitFileStorage(data map[string]URL, cfg *config.Config) *FileStorage {
+	if data == nil {
+		data = make(map[string]URL)
+	}
+
+	st := &FileStorage{
+		db:         data,
+		byUID:      make(map[string]map[string]bool),
+		users:      make(map[string]User),
+		logins:     make(map[string]string),
+		workspaces: make(map[string]Workspace),
+		members:    make(map[string]map[string]string),
+		cfg:        *cfg,
+	}
+
+	for key, u := range data {
+		st.put(key, u)
+	}
+
 	if cfg.FileStoragePath == "" {
-		if data == nil {
-			return &FileStorage{make(map[string]URL), *cfg}
+		return st
+	}
+
+	st.urls = newFileWriter(cfg.FileStoragePath, cfg.FileDurability)
+
+	_ = readLines(cfg.FileStoragePath, func(line []byte) error {
+		r := fileRecord{}
+		if err := json.NewDecoder(bytes.NewBuffer(line)).Decode(&r); err != nil {
+			return err
 		}
 
-		return &FileStorage{data, *cfg}
-	}
+		st.put(urlKey(r.Domain, r.ShortURL), URL(r))
 
-	file, err := os.OpenFile(cfg.FileStoragePath, os.O_RDONLY, 0o777)
+		return nil
+	})
+
+	_ = readLines(st.usersPath(), func(line []byte) error {
+		u := User{}
+		if err := json.Unmarshal(line, &u); err != nil {
+			return err
+		}
+
+		st.users[u.Login] = u
+		st.logins[u.UID] = u.Login
+
+		return nil
+	})
+
+	_ = readLines(st.workspacesPath(), func(line []byte) error {
+		r := workspaceRecord{}
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+
+		st.applyWorkspaceRecord(r)
+
+		return nil
+	})
+
+	return st
+}
+
+// readLines calls fn for every line of the file at path and stops at the first error
+func readLines(path string, fn func(line []byte) error) error {
+	file, err := os.OpenFile(path, os.O_RDONLY, 0o777)
 	if err != nil {
-		return &FileStorage{data, *cfg}
+		return err
 	}
 
+	defer file.Close()
+
 	scanner := bufio.NewScanner(file)
 
 	for scanner.Scan() {
-		url := URL{}
-		if err := json.NewDecoder(bytes.NewBuffer(scanner.Bytes())).Decode(&url); err != nil {
-			break
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
 		}
-
-		data[url.ShortURL] = url
 	}
 
-	defer file.Close()
-
-	return &FileStorage{data, *cfg}
+	return scanner.Err()
 }
 
-// SaveURL saves  url with hash binding it to a user with certain uid
-func (st *FileStorage) SaveURL(ctx context.Context, url, uid, hash string) error {
-	st.db[hash] = URL{uid, hash, url, false}
+// appendLines marshals every value to JSON and appends it to the file at path, one value per line
+func appendLines[T any](path string, values ...T) error {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
 
-	if st.cfg.FileStoragePath != "" {
-		file, err := os.OpenFile(st.cfg.FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o777)
-		if err != nil {
+	for i := range values {
+		if err := enc.Encode(&values[i]); err != nil {
 			return err
 		}
+	}
 
-		data, err := json.Marshal(&URL{uid, hash, url, false})
-		if err != nil {
-			return err
-		}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o777)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
 
-		data = append(data, '\n')
+	_, err = file.Write(buf.Bytes())
 
-		_, err = file.Write(data)
+	return err
+}
 
-		defer file.Close()
+// written is a result of a write that needed no writing
+var written = func() chan error {
+	c := make(chan error)
+	close(c)
 
-		return err
+	return c
+}()
+
+// persist queues urls to be appended to the storage file if the file storage is enabled and returns
+// the channel the result is sent to. It's called with st.mu held, so the file gets changes in the order
+// they were applied; the result is waited for after st.mu is released, so concurrent changes are written together.
+func (st *FileStorage) persist(urls ...URL) <-chan error {
+	if st.urls == nil || len(urls) == 0 {
+		return written
 	}
 
-	return nil
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+
+	for _, u := range urls {
+		r := fileRecord(u)
+		if err := enc.Encode(&r); err != nil {
+			failed := make(chan error, 1)
+			failed <- err
+
+			return failed
+		}
+	}
+
+	return st.urls.write(buf.Bytes())
 }
 
-// GetURL returns an URL bound to a hash passed
-func (st *FileStorage) GetURL(ctx context.Context, hash string) (URL, error) {
-	url, exists := st.db[hash]
+// SaveURL saves  url with hash binding it to a user with certain uid
+func (st *FileStorage) SaveURL(ctx context.Context, u URL) error {
+	st.mu.Lock()
+	u.IsDeleted = false
+	st.put(urlKey(u.Domain, u.ShortURL), u)
+	result := st.persist(u)
+	st.mu.Unlock()
+
+	return <-result
+}
+
+// GetURL returns an URL bound to a hash passed on domain or ErrNotFound
+func (st *FileStorage) GetURL(ctx context.Context, domain, hash string) (URL, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	url, exists := st.db[urlKey(domain, hash)]
 	if !exists {
-		return url, errors.New("an URL with this hash doesn't exist")
+		return url, ErrNotFound
 	}
 
 	return url, nil
@@ -88,10 +260,13 @@ func (st *FileStorage) GetURL(ctx context.Context, hash string) (URL, error) {
 
 // GetUrlsByUID returns a list of URLs belonging to a given user
 func (st *FileStorage) GetUrlsByUID(ctx context.Context, uid string) ([]URL, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
 	result := []URL{}
 
-	for _, url := range st.db {
-		if url.UID == uid && !url.IsDeleted {
+	for key := range st.byUID[uid] {
+		if url := st.db[key]; !url.IsDeleted {
 			result = append(result, url)
 		}
 	}
@@ -99,53 +274,486 @@ func (st *FileStorage) GetUrlsByUID(ctx context.Context, uid string) ([]URL, err
 	return result, nil
 }
 
+// QueryURLs returns the URLs of q.UID matching q in its order. Only the URLs of the user are scanned.
+func (st *FileStorage) QueryURLs(ctx context.Context, q URLQuery) ([]URL, error) {
+	st.mu.RLock()
+
+	result := []URL{}
+
+	for key := range st.byUID[q.UID] {
+		url := st.db[key]
+		if q.Match(url) && (q.After == nil || q.Compare(*q.After, url) < 0) {
+			result = append(result, url)
+		}
+	}
+
+	st.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return q.Compare(result[i], result[j]) < 0
+	})
+
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+
+	return result, nil
+}
+
 // IsAlive checks whether if the file db is alive (always ok)
 func (st *FileStorage) IsAlive(context.Context) (bool, error) {
-	// file storage always alive
-	return true, nil
+	if st.cfg.FileStoragePath == "" {
+		return true, nil
+	}
+
+	f, err := os.Open(st.cfg.FileStoragePath)
+	if errors.Is(err, os.ErrNotExist) {
+		// nothing was saved yet, the file is created on the first write
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, f.Close()
+}
+
+// IsWritable checks that the storage file can be opened for appending
+func (st *FileStorage) IsWritable(context.Context) (bool, error) {
+	if st.cfg.FileStoragePath == "" {
+		return true, nil
+	}
+
+	f, err := os.OpenFile(st.cfg.FileStoragePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return false, err
+	}
+
+	return true, f.Close()
+}
+
+// CheckSchema always succeeds, the file format needs no migrations
+func (st *FileStorage) CheckSchema(context.Context) error {
+	return nil
 }
 
 // BatchSaveURL saves a list of URLs to a file
 func (st *FileStorage) BatchSaveURL(ctx context.Context, urls []URL) error {
+	st.mu.Lock()
+
+	saved := make([]URL, 0, len(urls))
+
 	for _, url := range urls {
-		st.db[url.ShortURL] = URL{url.UID, url.ShortURL, url.URL, false}
+		url.IsDeleted = false
+		st.put(urlKey(url.Domain, url.ShortURL), url)
+		saved = append(saved, url)
 	}
 
-	if st.cfg.FileStoragePath != "" {
-		file, err := os.OpenFile(st.cfg.FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o777)
-		if err != nil {
-			return err
+	result := st.persist(saved...)
+	st.mu.Unlock()
+
+	return <-result
+}
+
+// KillConn waits for queued writes to the storage file, later writes fail with ErrClosed
+func (st *FileStorage) KillConn() error {
+	if st.urls == nil {
+		return nil
+	}
+
+	return st.urls.close()
+}
+
+// DeleteURLs deletes URLs from the file (not actually removing them, but marking as deleted).
+// It returns the deleted URLs, entries of missing, already deleted or foreign URLs are skipped.
+func (st *FileStorage) DeleteURLs(ctx context.Context, entries []DeletionEntry) ([]URL, error) {
+	st.mu.Lock()
+
+	deleted := []URL{}
+
+	for _, entry := range entries {
+		key := urlKey(entry.Domain, entry.Hash)
+
+		url, exists := st.db[key]
+		if exists && !url.IsDeleted && st.canModify(url, entry.UID) {
+			url.IsDeleted = true
+			st.put(key, url)
+			deleted = append(deleted, url)
 		}
+	}
 
-		err = json.NewEncoder(file).Encode(urls)
-		if err != nil {
-			return err
+	result := st.persist(deleted...)
+	st.mu.Unlock()
+
+	if err := <-result; err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// SaveUser saves a new user, ErrUserExists is returned if the login or uid is taken
+func (st *FileStorage) SaveUser(ctx context.Context, u User) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	_, loginTaken := st.users[u.Login]
+	_, uidTaken := st.logins[u.UID]
+
+	if loginTaken || uidTaken {
+		return ErrUserExists
+	}
+
+	st.users[u.Login] = u
+	st.logins[u.UID] = u.Login
+
+	if st.cfg.FileStoragePath == "" {
+		return nil
+	}
+
+	return appendLines(st.usersPath(), u)
+}
+
+// GetUserByLogin returns a user with the login or ErrNotFound
+func (st *FileStorage) GetUserByLogin(ctx context.Context, login string) (User, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	u, exists := st.users[login]
+	if !exists {
+		return User{}, ErrNotFound
+	}
+
+	return u, nil
+}
+
+// GetUserByUID returns a user with the uid or ErrNotFound
+func (st *FileStorage) GetUserByUID(ctx context.Context, uid string) (User, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	login, exists := st.logins[uid]
+	if !exists {
+		return User{}, ErrNotFound
+	}
+
+	return st.users[login], nil
+}
+
+// UpdateUser updates the password hash and the session version of a user with the login or returns ErrNotFound.
+// The user is appended to the users file again, later lines override earlier ones on load.
+func (st *FileStorage) UpdateUser(ctx context.Context, u User) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	existing, exists := st.users[u.Login]
+	if !exists {
+		return ErrNotFound
+	}
+
+	existing.PasswordHash, existing.SessionVersion = u.PasswordHash, u.SessionVersion
+	st.users[u.Login] = existing
+
+	if st.cfg.FileStoragePath == "" {
+		return nil
+	}
+
+	return appendLines(st.usersPath(), existing)
+}
+
+// ReassignURLs binds all URLs of fromUID to toUID and returns the number of moved URLs
+func (st *FileStorage) ReassignURLs(ctx context.Context, fromUID, toUID string) (int, error) {
+	st.mu.Lock()
+
+	moved := []URL{}
+
+	for key, url := range st.db {
+		if url.UID == fromUID {
+			url.UID = toUID
+			st.put(key, url)
+			moved = append(moved, url)
 		}
+	}
 
-		_, err = file.Write([]byte{'\n'})
+	result := st.persist(moved...)
+	st.mu.Unlock()
 
-		defer file.Close()
+	return len(moved), <-result
+}
 
-		return err
+// canModify reports whether uid may change the url: either it is the url owner or
+// the url belongs to a workspace where uid is at least an editor. Must be called with st.mu held.
+func (st *FileStorage) canModify(url URL, uid string) bool {
+	if url.WorkspaceID == "" {
+		return url.UID == uid
 	}
 
-	return nil
+	return RoleAtLeast(st.members[url.WorkspaceID][uid], RoleEditor)
 }
 
-// KillConn is a dummy fn here to comply with the storage interface
-func (st *FileStorage) KillConn() error {
+// applyWorkspaceRecord applies a workspace file record to the in-memory maps. Must be called with st.mu held.
+func (st *FileStorage) applyWorkspaceRecord(r workspaceRecord) {
+	if r.Workspace != nil {
+		st.workspaces[r.Workspace.ID] = *r.Workspace
+		st.members[r.Workspace.ID] = make(map[string]string)
+	}
+
+	if r.Member == nil || st.members[r.Member.WorkspaceID] == nil {
+		return
+	}
+
+	if r.Removed {
+		delete(st.members[r.Member.WorkspaceID], r.Member.UID)
+		return
+	}
+
+	st.members[r.Member.WorkspaceID][r.Member.UID] = r.Member.Role
+}
+
+// persistWorkspaceRecord applies r and appends it to the workspaces file. Must be called with st.mu held.
+func (st *FileStorage) persistWorkspaceRecord(r workspaceRecord) error {
+	st.applyWorkspaceRecord(r)
+
+	if st.cfg.FileStoragePath == "" {
+		return nil
+	}
+
+	return appendLines(st.workspacesPath(), r)
+}
+
+// CreateWorkspace creates a workspace and makes ownerUID its owner
+func (st *FileStorage) CreateWorkspace(ctx context.Context, ws Workspace, ownerUID string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ws.Role = ""
+
+	return st.persistWorkspaceRecord(workspaceRecord{
+		Workspace: &ws,
+		Member:    &Member{WorkspaceID: ws.ID, UID: ownerUID, Role: RoleOwner},
+	})
+}
+
+// GetWorkspacesByUID returns the workspaces the user is a member of with the user role filled in
+func (st *FileStorage) GetWorkspacesByUID(ctx context.Context, uid string) ([]Workspace, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	result := []Workspace{}
+
+	for id, members := range st.members {
+		if role, ok := members[uid]; ok {
+			ws := st.workspaces[id]
+			ws.Role = role
+			result = append(result, ws)
+		}
+	}
+
+	return result, nil
+}
+
+// GetMembers returns members of a workspace
+func (st *FileStorage) GetMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	members, exists := st.members[workspaceID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	result := make([]Member, 0, len(members))
+	for uid, role := range members {
+		result = append(result, Member{WorkspaceID: workspaceID, UID: uid, Role: role})
+	}
+
+	return result, nil
+}
+
+// GetRole returns the role of a user in a workspace or ErrNotFound if the user isn't a member
+func (st *FileStorage) GetRole(ctx context.Context, workspaceID, uid string) (string, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	role, exists := st.members[workspaceID][uid]
+	if !exists {
+		return "", ErrNotFound
+	}
+
+	return role, nil
+}
+
+// SetMember adds a member to a workspace or changes the member role
+func (st *FileStorage) SetMember(ctx context.Context, m Member) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, exists := st.workspaces[m.WorkspaceID]; !exists {
+		return ErrNotFound
+	}
+
+	return st.persistWorkspaceRecord(workspaceRecord{Member: &m})
+}
+
+// RemoveMember removes a member from a workspace
+func (st *FileStorage) RemoveMember(ctx context.Context, workspaceID, uid string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, exists := st.members[workspaceID][uid]; !exists {
+		return ErrNotFound
+	}
+
+	return st.persistWorkspaceRecord(workspaceRecord{Member: &Member{WorkspaceID: workspaceID, UID: uid}, Removed: true})
+}
+
+// GetUrlsByWorkspace returns all URLs of a workspace
+func (st *FileStorage) GetUrlsByWorkspace(ctx context.Context, workspaceID string) ([]URL, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	result := []URL{}
+
+	for _, url := range st.db {
+		if url.WorkspaceID == workspaceID && !url.IsDeleted {
+			result = append(result, url)
+		}
+	}
+
+	return result, nil
+}
+
+// ForEachURL calls fn for a snapshot of all URLs ordered by domain and hash, fn may use the storage
+func (st *FileStorage) ForEachURL(ctx context.Context, fn func(URL) error) error {
+	st.mu.RLock()
+
+	urls := make([]URL, 0, len(st.db))
+	for _, u := range st.db {
+		urls = append(urls, u)
+	}
+
+	st.mu.RUnlock()
+
+	return forEach(ctx, urls, fn)
+}
+
+// ForEachUserURL calls fn for a snapshot of the URLs of uid ordered by domain and hash, fn may use the storage.
+// Only the URLs of the user are read.
+func (st *FileStorage) ForEachUserURL(ctx context.Context, uid string, fn func(URL) error) error {
+	st.mu.RLock()
+
+	urls := make([]URL, 0, len(st.byUID[uid]))
+	for key := range st.byUID[uid] {
+		urls = append(urls, st.db[key])
+	}
+
+	st.mu.RUnlock()
+
+	return forEach(ctx, urls, fn)
+}
+
+// forEach calls fn for urls ordered by domain and hash
+func forEach(ctx context.Context, urls []URL, fn func(URL) error) error {
+	sort.Slice(urls, func(i, j int) bool {
+		if urls[i].Domain != urls[j].Domain {
+			return urls[i].Domain < urls[j].Domain
+		}
+
+		return urls[i].ShortURL < urls[j].ShortURL
+	})
+
+	for _, u := range urls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// DeleteURLs deletes URLs from the file (not actually removing them, but marking as deleted)
-func (st *FileStorage) DeleteURLs(ctx context.Context, entries []DeletionEntry) error {
-	for _, entry := range entries {
-		url, exists := st.db[entry.Hash]
-		if exists && st.db[entry.Hash].UID == entry.UID {
-			url.IsDeleted = true
-			st.db[entry.Hash] = url
+// ForEachUser calls fn for a snapshot of all users ordered by login
+func (st *FileStorage) ForEachUser(ctx context.Context, fn func(User) error) error {
+	st.mu.RLock()
+
+	users := make([]User, 0, len(st.users))
+	for _, u := range st.users {
+		users = append(users, u)
+	}
+
+	st.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Login < users[j].Login })
+
+	for _, u := range users {
+		if err := fn(u); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// PurgeDeleted drops soft-deleted URLs and compacts the storage file: it is rewritten with a single line
+// per remaining URL into a temporary file which then replaces the original
+func (st *FileStorage) PurgeDeleted(ctx context.Context) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	purged := 0
+
+	for key, u := range st.db {
+		if u.IsDeleted {
+			st.remove(key)
+			purged++
+		}
+	}
+
+	if st.cfg.FileStoragePath == "" {
+		return purged, nil
+	}
+
+	return purged, st.compact()
+}
+
+// compact rewrites the storage file from memory, the caller must hold st.mu
+func (st *FileStorage) compact() error {
+	records := make([]fileRecord, 0, len(st.db))
+	for _, u := range st.db {
+		records = append(records, fileRecord(u))
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return urlKey(records[i].Domain, records[i].ShortURL) < urlKey(records[j].Domain, records[j].ShortURL)
+	})
+
+	tmp := st.cfg.FileStoragePath + ".tmp"
+	_ = os.Remove(tmp)
+
+	if err := appendLines(tmp, records...); err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	f.Close()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, st.cfg.FileStoragePath); err != nil {
+		return err
+	}
+
+	// writes queued before still go to the replaced file, they are in the compacted one already
+	return st.urls.reopen()
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// LineError describes a bad line of a storage file
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// FileReport is the result of verifying a storage file
+type FileReport struct {
+	Path       string      `json:"path"`
+	Lines      int         `json:"lines"`      // non-empty lines
+	Records    int         `json:"records"`    // distinct records after replay
+	Superseded int         `json:"superseded"` // lines replaced by later lines for the same key
+	Problems   []LineError `json:"problems,omitempty"`
+}
+
+// OK reports whether the file has no problems
+func (r FileReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// VerifyFiles checks the storage file at path and its users and workspaces files if they exist.
+// Every line must be a valid record; note that InitFileStorage stops loading a file at its first bad line.
+func VerifyFiles(path string) ([]FileReport, error) {
+	reports := []FileReport{}
+
+	urls, err := verifyFile(path, func(line []byte) (string, error) {
+		r := fileRecord{}
+		if err := json.Unmarshal(line, &r); err != nil {
+			return "", err
+		}
+
+		if r.ShortURL == "" {
+			return "", errors.New("empty short_url")
+		}
+
+		if _, err := url.ParseRequestURI(r.URL); err != nil {
+			return "", fmt.Errorf("invalid original_url: %w", err)
+		}
+
+		return urlKey(r.Domain, r.ShortURL), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reports = append(reports, urls)
+
+	users, err := verifyFile(path+".users", func(line []byte) (string, error) {
+		u := User{}
+		if err := json.Unmarshal(line, &u); err != nil {
+			return "", err
+		}
+
+		if u.UID == "" || u.Login == "" || u.PasswordHash == "" {
+			return "", errors.New("uid, login and password hash are required")
+		}
+
+		return u.Login, nil
+	})
+	if err == nil {
+		reports = append(reports, users)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	workspaces, err := verifyFile(path+".workspaces", func(line []byte) (string, error) {
+		r := workspaceRecord{}
+		if err := json.Unmarshal(line, &r); err != nil {
+			return "", err
+		}
+
+		switch {
+		case r.Workspace != nil && r.Workspace.ID != "":
+			return "workspace " + r.Workspace.ID, nil
+		case r.Member != nil && r.Member.WorkspaceID != "" && r.Member.UID != "":
+			return "member " + r.Member.WorkspaceID + " " + r.Member.UID, nil
+		default:
+			return "", errors.New("neither a workspace nor a member")
+		}
+	})
+	if err == nil {
+		reports = append(reports, workspaces)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// verifyFile checks every line of the file with check, which returns the key of a valid record
+func verifyFile(path string, check func(line []byte) (string, error)) (FileReport, error) {
+	report := FileReport{Path: path}
+	keys := map[string]struct{}{}
+	line := 0
+
+	err := readLines(path, func(data []byte) error {
+		line++
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		report.Lines++
+
+		key, err := check(data)
+		if err != nil {
+			report.Problems = append(report.Problems, LineError{Line: line, Error: err.Error()})
+			return nil
+		}
+
+		if _, ok := keys[key]; ok {
+			report.Superseded++
+		}
+
+		keys[key] = struct{}{}
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+
+		report.Problems = append(report.Problems, LineError{Line: line + 1, Error: err.Error()})
+	}
+
+	report.Records = len(keys)
+
+	return report, nil
+}
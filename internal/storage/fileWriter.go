@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+)
+
+// ErrClosed is returned by writes to a storage after KillConn
+var ErrClosed = errors.New("storage is closed")
+
+// fileWriter limits
+const (
+	fileQueueSize = 1024 // writes waiting for the writer before callers block
+	fileBatchSize = 256  // most writes appended and synced together
+)
+
+// writeRequest is a chunk of lines appended by fileWriter, ack receives the result once it's written
+type writeRequest struct {
+	data   []byte
+	reopen bool // close the file and open the path again, e.g. after it was replaced
+	ack    chan error
+}
+
+// fileWriter appends to a file from a single goroutine. Writes queued while a batch is being written
+// are appended together as the next batch and, depending on the durability mode, synced once per batch.
+type fileWriter struct {
+	path       string
+	durability string // one of config.Durability*
+	reqs       chan writeRequest
+	done       chan struct{}
+
+	mu     sync.RWMutex // guards closed, held for reading while sending to reqs
+	closed bool
+
+	file *os.File // owned by the writer goroutine, nil until the first write and after reopen
+	size int64    // length of file when the last write succeeded
+}
+
+// newFileWriter starts a writer appending to path
+func newFileWriter(path, durability string) *fileWriter {
+	w := &fileWriter{
+		path:       path,
+		durability: durability,
+		reqs:       make(chan writeRequest, fileQueueSize),
+		done:       make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// write queues data and returns the channel its result is sent to. Writes are appended in the order they were queued.
+func (w *fileWriter) write(data []byte) <-chan error {
+	return w.send(writeRequest{data: data, ack: make(chan error, 1)})
+}
+
+// reopen makes the writer append to a new file at its path once the writes queued before are done
+func (w *fileWriter) reopen() error {
+	return <-w.send(writeRequest{reopen: true, ack: make(chan error, 1)})
+}
+
+func (w *fileWriter) send(r writeRequest) <-chan error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		r.ack <- ErrClosed
+		return r.ack
+	}
+
+	w.reqs <- r
+
+	return r.ack
+}
+
+// close writes the queued data and stops the writer
+func (w *fileWriter) close() error {
+	w.mu.Lock()
+
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+
+	w.closed = true
+	close(w.reqs)
+	w.mu.Unlock()
+
+	<-w.done
+
+	if w.file == nil {
+		return nil
+	}
+
+	return w.file.Close()
+}
+
+func (w *fileWriter) run() {
+	defer close(w.done)
+
+	batch := make([]writeRequest, 0, fileBatchSize)
+
+	for r := range w.reqs {
+		batch = append(batch[:0], r)
+
+	collect:
+		for len(batch) < fileBatchSize && !r.reopen {
+			select {
+			case next, ok := <-w.reqs:
+				if !ok {
+					break collect
+				}
+
+				batch = append(batch, next)
+
+				if next.reopen {
+					break collect
+				}
+			default:
+				break collect
+			}
+		}
+
+		w.flush(batch)
+	}
+}
+
+// flush writes a batch and acknowledges its requests, a reopen request can only be the last one
+func (w *fileWriter) flush(batch []writeRequest) {
+	last := batch[len(batch)-1]
+	if last.reopen {
+		batch = batch[:len(batch)-1]
+	}
+
+	if w.durability == config.DurabilityAlways {
+		for _, r := range batch {
+			r.ack <- w.append(r.data)
+		}
+	} else if len(batch) > 0 {
+		data := make([]byte, 0, len(batch)*len(batch[0].data))
+		for _, r := range batch {
+			data = append(data, r.data...)
+		}
+
+		err := w.append(data)
+		for _, r := range batch {
+			r.ack <- err
+		}
+	}
+
+	if last.reopen {
+		var err error
+		if w.file != nil {
+			err = w.file.Close()
+			w.file = nil
+		}
+
+		last.ack <- err
+	}
+}
+
+// append writes data to the file and syncs it unless durability is none. A failed write is cut off
+// the file, so the next batch doesn't continue a partial line.
+func (w *fileWriter) append(data []byte) error {
+	if w.file == nil {
+		f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o777)
+		if err != nil {
+			return err
+		}
+
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		w.file, w.size = f, size
+	}
+
+	n, err := w.file.Write(data)
+	if err == nil && w.durability != config.DurabilityNone {
+		err = w.file.Sync()
+	}
+
+	if err != nil {
+		_ = w.file.Truncate(w.size)
+		return err
+	}
+
+	w.size += int64(n)
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var durabilities = []string{config.DurabilityAlways, config.DurabilityBatch, config.DurabilityNone}
+
+func Test_FileStorageConcurrentWrites(t *testing.T) {
+	for _, durability := range durabilities {
+		t.Run(durability, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "urls.log")
+			st := storage.InitFileStorage(nil, &config.Config{FileStoragePath: path, FileDurability: durability})
+
+			wg := sync.WaitGroup{}
+
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+
+				go func(i int) {
+					defer wg.Done()
+
+					hash := strconv.Itoa(i)
+					assert.NoError(t, st.SaveURL(ctx, storage.URL{UID: "owner", ShortURL: hash, URL: "https://example.com/" + hash}))
+					assert.NoError(t, st.BatchSaveURL(ctx, []storage.URL{{UID: "owner", ShortURL: "b" + hash, URL: "https://example.com/b" + hash}}))
+				}(i)
+			}
+
+			wg.Wait()
+
+			deleted, err := st.DeleteURLs(ctx, []storage.DeletionEntry{{UID: "owner", Hash: "0"}})
+			assert.NoError(t, err)
+			assert.Len(t, deleted, 1)
+			assert.NoError(t, st.KillConn())
+			assert.ErrorIs(t, st.SaveURL(ctx, storage.URL{ShortURL: "late"}), storage.ErrClosed)
+
+			reports, err := storage.VerifyFiles(path)
+			assert.NoError(t, err)
+			assert.True(t, reports[0].OK())
+			assert.Equal(t, 101, reports[0].Lines)
+
+			reopened := storage.InitFileStorage(nil, &config.Config{FileStoragePath: path})
+			urls, err := reopened.GetUrlsByUID(ctx, "owner")
+			assert.NoError(t, err)
+			assert.Len(t, urls, 99)
+		})
+	}
+}
+
+func Test_FileStoragePurgeReopens(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.log")
+	st := storage.InitFileStorage(nil, &config.Config{FileStoragePath: path, FileDurability: config.DurabilityBatch})
+
+	assert.NoError(t, st.SaveURL(ctx, storage.URL{UID: "owner", ShortURL: "gone", URL: "https://gone.example"}))
+	deleted, err := st.DeleteURLs(ctx, []storage.DeletionEntry{{UID: "owner", Hash: "gone"}})
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+
+	purged, err := st.PurgeDeleted(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	// the writer must append to the compacted file, not the replaced one
+	assert.NoError(t, st.SaveURL(ctx, storage.URL{UID: "owner", ShortURL: "kept", URL: "https://kept.example"}))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(data, []byte("\n")))
+	assert.Contains(t, string(data), "kept")
+}
+
+// appendDirect is how FileStorage used to write: open, append and close the file for every write, with no sync
+func appendDirect(path string, u storage.URL) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o777)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+
+	return err
+}
+
+// BenchmarkFileWrites saves URLs from parallel goroutines in every durability mode and the old way
+func BenchmarkFileWrites(b *testing.B) {
+	b.Run("direct", func(b *testing.B) {
+		path := filepath.Join(b.TempDir(), "urls.log")
+		n := atomic.Int64{}
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				hash := strconv.FormatInt(n.Add(1), 36)
+				if err := appendDirect(path, storage.URL{UID: "owner", ShortURL: hash, URL: "https://example.com/" + hash}); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+
+	for _, durability := range durabilities {
+		b.Run(durability, func(b *testing.B) {
+			st := storage.InitFileStorage(nil, &config.Config{FileStoragePath: filepath.Join(b.TempDir(), "urls.log"), FileDurability: durability})
+			defer st.KillConn()
+
+			n := atomic.Int64{}
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					hash := strconv.FormatInt(n.Add(1), 36)
+					if err := st.SaveURL(context.Background(), storage.URL{UID: "owner", ShortURL: hash, URL: "https://example.com/" + hash}); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+// URL statuses a query selects
+const (
+	StatusActive  = "active"  // URLs which aren't deleted, the default
+	StatusDeleted = "deleted" // soft-deleted URLs only
+	StatusAll     = "all"     // both
+)
+
+// URL orders a query returns, ties are broken by domain and hash in the same direction
+const (
+	SortCode    = "code"    // by domain and hash, the default
+	SortCreated = "created" // by creation time, URLs without one come first
+)
+
+// URLQuery selects a page of the URLs of a user, see Storage.QueryURLs
+type URLQuery struct {
+	UID         string    // owner of the URLs
+	Domain      *string   // domain the URLs are served on, any if nil, empty for the default domain
+	Search      string    // case-insensitive substring of the original URL or the hash, any if empty
+	Status      string    // StatusActive if empty, StatusDeleted or StatusAll
+	CreatedFrom time.Time // URLs created at or after, unbounded if zero
+	CreatedTo   time.Time // URLs created before, unbounded if zero
+	Sort        string    // SortCode if empty or SortCreated
+	Desc        bool      // reverses the order
+	After       *URL      // keyset cursor, only URLs ordered after it are returned
+	Limit       int       // max number of URLs, unlimited if zero
+}
+
+// Match reports whether u passes the filters of q, the cursor and the limit aside.
+// URLs without a creation time don't match a date range.
+func (q URLQuery) Match(u URL) bool {
+	if u.UID != q.UID {
+		return false
+	}
+
+	switch q.Status {
+	case StatusDeleted:
+		if !u.IsDeleted {
+			return false
+		}
+	case StatusAll:
+	default:
+		if u.IsDeleted {
+			return false
+		}
+	}
+
+	if q.Domain != nil && u.Domain != *q.Domain {
+		return false
+	}
+
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		if !strings.Contains(strings.ToLower(u.URL), search) && !strings.Contains(strings.ToLower(u.ShortURL), search) {
+			return false
+		}
+	}
+
+	if (!q.CreatedFrom.IsZero() || !q.CreatedTo.IsZero()) && u.CreatedAt.IsZero() {
+		return false
+	}
+
+	if !q.CreatedFrom.IsZero() && u.CreatedAt.Before(q.CreatedFrom) {
+		return false
+	}
+
+	if !q.CreatedTo.IsZero() && !u.CreatedAt.Before(q.CreatedTo) {
+		return false
+	}
+
+	return true
+}
+
+// Compare returns -1 if a is ordered before b by q, 1 if after and 0 if they are the same URL
+func (q URLQuery) Compare(a, b URL) int {
+	c := 0
+
+	if q.Sort == SortCreated {
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+
+	if c == 0 {
+		c = strings.Compare(a.Domain, b.Domain)
+	}
+
+	if c == 0 {
+		c = strings.Compare(a.ShortURL, b.ShortURL)
+	}
+
+	if q.Desc {
+		return -c
+	}
+
+	return c
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer records a client span for every SQL statement sent through the pool and logs failed
+// statements with the logger of the request they were sent for
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = otel.Tracer("github.com/T-V-N/gourlshortener/internal/storage").Start(ctx, "pgx.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+		),
+	)
+
+	return ctx
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+
+	if data.Err != nil && data.Err != pgx.ErrNoRows {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+
+		// conflicts are expected and handled by the callers
+		var pgErr *pgconn.PgError
+		if !errors.As(data.Err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+			logger.FromContext(ctx).Error("query failed", "error", data.Err)
+		}
+	}
+
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	span.End()
+}
@@ -0,0 +1,93 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FileStorageQueryURLs(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	st := storage.InitFileStorage(map[string]storage.URL{
+		"legacy": {UID: "owner", ShortURL: "legacy", URL: "https://legacy.example"},
+	}, &config.Config{})
+
+	require.NoError(t, st.BatchSaveURL(ctx, []storage.URL{
+		{UID: "owner", ShortURL: "aaaa", URL: "https://Docs.example/a", CreatedAt: day},
+		{UID: "owner", ShortURL: "bbbb", URL: "https://blog.example/b", CreatedAt: day.Add(2 * time.Hour)},
+		{UID: "owner", ShortURL: "cccc", URL: "https://docs.example/c", CreatedAt: day.Add(time.Hour), Domain: "brand.io"},
+		{UID: "owner", ShortURL: "dddd", URL: "https://docs.example/d", CreatedAt: day.Add(48 * time.Hour)},
+		{UID: "stranger", ShortURL: "eeee", URL: "https://docs.example/e", CreatedAt: day},
+	}))
+	deleted, err := st.DeleteURLs(ctx, []storage.DeletionEntry{{UID: "owner", Hash: "dddd"}})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+
+	brand, def := "brand.io", ""
+
+	tests := []struct {
+		name  string
+		query storage.URLQuery
+		want  []string
+	}{
+		{"active by code", storage.URLQuery{UID: "owner"}, []string{"aaaa", "bbbb", "legacy", "cccc"}},
+		{"by creation time", storage.URLQuery{UID: "owner", Sort: storage.SortCreated}, []string{"legacy", "aaaa", "cccc", "bbbb"}},
+		{"newest first", storage.URLQuery{UID: "owner", Sort: storage.SortCreated, Desc: true}, []string{"bbbb", "cccc", "aaaa", "legacy"}},
+		{"domain", storage.URLQuery{UID: "owner", Domain: &brand}, []string{"cccc"}},
+		{"default domain", storage.URLQuery{UID: "owner", Domain: &def, Limit: 2}, []string{"aaaa", "bbbb"}},
+		{"search ignores case", storage.URLQuery{UID: "owner", Search: "DOCS"}, []string{"aaaa", "cccc"}},
+		{"search codes", storage.URLQuery{UID: "owner", Search: "legac"}, []string{"legacy"}},
+		{"deleted", storage.URLQuery{UID: "owner", Status: storage.StatusDeleted}, []string{"dddd"}},
+		{"all", storage.URLQuery{UID: "owner", Status: storage.StatusAll, Search: "docs"}, []string{"aaaa", "dddd", "cccc"}},
+		{"date range", storage.URLQuery{UID: "owner", CreatedFrom: day.Add(time.Hour), CreatedTo: day.Add(2 * time.Hour)}, []string{"cccc"}},
+		{"open date range skips unknown times", storage.URLQuery{UID: "owner", CreatedTo: day.Add(time.Hour)}, []string{"aaaa"}},
+		{"after", storage.URLQuery{UID: "owner", After: &storage.URL{ShortURL: "bbbb"}}, []string{"legacy", "cccc"}},
+		{"after descending", storage.URLQuery{UID: "owner", Sort: storage.SortCreated, Desc: true, After: &storage.URL{ShortURL: "cccc", Domain: "brand.io", CreatedAt: day.Add(time.Hour)}}, []string{"aaaa", "legacy"}},
+		{"other user", storage.URLQuery{UID: "nobody"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls, err := st.QueryURLs(ctx, tt.query)
+			require.NoError(t, err)
+
+			codes := []string(nil)
+			for _, u := range urls {
+				codes = append(codes, u.ShortURL)
+			}
+
+			assert.Equal(t, tt.want, codes)
+		})
+	}
+
+	t.Run("reassigned and purged urls", func(t *testing.T) {
+		_, err := st.ReassignURLs(ctx, "stranger", "owner")
+		require.NoError(t, err)
+
+		_, err = st.PurgeDeleted(ctx)
+		require.NoError(t, err)
+
+		urls, err := st.QueryURLs(ctx, storage.URLQuery{UID: "owner", Status: storage.StatusAll, Search: "docs"})
+		require.NoError(t, err)
+		assert.Len(t, urls, 3)
+
+		urls, err = st.QueryURLs(ctx, storage.URLQuery{UID: "stranger", Status: storage.StatusAll})
+		require.NoError(t, err)
+		assert.Empty(t, urls)
+
+		codes := []string{}
+		require.NoError(t, st.ForEachUserURL(ctx, "owner", func(u storage.URL) error {
+			codes = append(codes, u.ShortURL)
+			return nil
+		}))
+		assert.Equal(t, []string{"aaaa", "bbbb", "eeee", "legacy", "cccc"}, codes)
+	})
+}
@@ -2,22 +2,78 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/T-V-N/gourlshortener/internal/config"
 )
 
+var (
+	// ErrNotFound is returned when a requested record doesn't exist in a storage
+	ErrNotFound = errors.New("not found")
+	// ErrUserExists is returned when a user with the same login or uid is already registered
+	ErrUserExists = errors.New("user already exists")
+)
+
+// Workspace member roles, each role grants everything the next one does
+const (
+	RoleOwner  = "owner"  // manages members and links
+	RoleEditor = "editor" // creates and deletes links
+	RoleViewer = "viewer" // lists links
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// IsValidRole reports whether role is one of the workspace roles
+func IsValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAtLeast reports whether role grants at least the permissions of min
+func RoleAtLeast(role, min string) bool {
+	return IsValidRole(role) && roleRanks[role] >= roleRanks[min]
+}
+
 // DeletionEntry is a struct used for url deletion
 type DeletionEntry struct {
-	UID  string // user id
-	Hash string // url hash
+	UID    string // user id
+	Domain string // url domain, empty for the default one
+	Hash   string // url hash
 }
 
-// URL struct describes URL obj and its json format
+// URL struct describes URL obj and its json format. An URL is identified by its domain and hash,
+// so the same hash may exist independently on several domains.
 type URL struct {
-	UID       string `json:"-"`            // user uid, ommited in JSON responses
-	ShortURL  string `json:"short_url"`    // url hash
-	URL       string `json:"original_url"` // full url
-	IsDeleted bool   // flag if a url was deleted
+	UID         string    `json:"-"`            // user uid, ommited in JSON responses
+	ShortURL    string    `json:"short_url"`    // url hash
+	URL         string    `json:"original_url"` // full url
+	IsDeleted   bool      // flag if a url was deleted
+	WorkspaceID string    `json:"workspace_id,omitempty"` // workspace owning the url, empty for personal urls
+	Domain      string    `json:"domain,omitempty"`       // domain the url is served on, empty for the domain of the base url
+	CreatedAt   time.Time `json:"created_at"`             // when the url was shortened, zero for urls saved before it was recorded
+}
+
+// User describes a registered account. UID is the same kind of identifier the auth cookie carries,
+// so all URLs bound to it belong to the account.
+type User struct {
+	UID            string `json:"uid"`             // user uid used by the auth cookie
+	Login          string `json:"login"`           // unique login
+	PasswordHash   string `json:"password_hash"`   // bcrypt hash of the password
+	SessionVersion int    `json:"session_version"` // bumped to revoke all issued session tokens
+}
+
+// Workspace is a team owning shared URLs
+type Workspace struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role,omitempty"` // role of the requesting user, filled in listings
+}
+
+// Member binds a user to a workspace with a role
+type Member struct {
+	WorkspaceID string `json:"-"`
+	UID         string `json:"uid"`
+	Role        string `json:"role"`
 }
 
 // BatchURL used for URL lists
@@ -25,17 +81,37 @@ type BatchURL struct {
 	OriginalURL   string `json:"original_url,omitempty"` // full url
 	CorrelationID string `json:"correlation_id"`         // url hash
 	ShortURL      string `json:"short_url"`              // link to a server which redirects to the original url
+	Domain        string `json:"domain,omitempty"`       // domain to shorten on, see app.ResolveDomain
 }
 
 // Storage is the main interface used by app for storing URLs
 type Storage interface {
-	SaveURL(ctx context.Context, url, uid, hash string) error    // Saves an URL to a storage
-	GetURL(ctx context.Context, hash string) (URL, error)        // Returns an URL from a storage
-	GetUrlsByUID(ctx context.Context, uid string) ([]URL, error) // Returns all URLs belonging to a user with uid
-	IsAlive(ctx context.Context) (bool, error)                   // Checks if storage is alive
-	BatchSaveURL(ctx context.Context, urls []URL) error          // Saves a list of urls to a storage
-	KillConn() error                                             // Gracefully stops a storage connection
-	DeleteURLs(context.Context, []DeletionEntry) error           // Deletes URLs from storage
+	SaveURL(ctx context.Context, u URL) error                                  // Saves an URL to a storage
+	GetURL(ctx context.Context, domain, hash string) (URL, error)              // Returns an URL of a domain from a storage
+	GetUrlsByUID(ctx context.Context, uid string) ([]URL, error)               // Returns all URLs belonging to a user with uid
+	QueryURLs(ctx context.Context, q URLQuery) ([]URL, error)                  // Returns a filtered and ordered page of the URLs of a user
+	IsAlive(ctx context.Context) (bool, error)                                 // Checks if storage is alive
+	IsWritable(ctx context.Context) (bool, error)                              // Checks if storage accepts writes
+	CheckSchema(ctx context.Context) error                                     // Checks that the storage schema is migrated
+	BatchSaveURL(ctx context.Context, urls []URL) error                        // Saves a list of urls to a storage
+	KillConn() error                                                           // Gracefully stops a storage connection
+	DeleteURLs(context.Context, []DeletionEntry) ([]URL, error)                // Deletes URLs from storage and returns the ones it deleted
+	SaveUser(ctx context.Context, u User) error                                // Saves a new registered user
+	GetUserByLogin(ctx context.Context, login string) (User, error)            // Returns a user by login
+	GetUserByUID(ctx context.Context, uid string) (User, error)                // Returns a user by uid
+	UpdateUser(ctx context.Context, u User) error                              // Updates the password and the session version of a user with the login
+	ReassignURLs(ctx context.Context, fromUID, toUID string) (int, error)      // Moves all URLs of fromUID to toUID
+	CreateWorkspace(ctx context.Context, ws Workspace, ownerUID string) error  // Creates a workspace owned by ownerUID
+	GetWorkspacesByUID(ctx context.Context, uid string) ([]Workspace, error)   // Returns workspaces the user is a member of
+	GetMembers(ctx context.Context, workspaceID string) ([]Member, error)      // Returns members of a workspace
+	GetRole(ctx context.Context, workspaceID, uid string) (string, error)      // Returns the user role or ErrNotFound
+	SetMember(ctx context.Context, m Member) error                             // Adds a member or changes the member role
+	RemoveMember(ctx context.Context, workspaceID, uid string) error           // Removes a member from a workspace
+	GetUrlsByWorkspace(ctx context.Context, workspaceID string) ([]URL, error) // Returns all URLs of a workspace
+	ForEachURL(ctx context.Context, fn func(URL) error) error                  // Calls fn for every URL including deleted ones, stops at the first error
+	ForEachUserURL(ctx context.Context, uid string, fn func(URL) error) error  // Calls fn for every URL of a user including deleted ones ordered by domain and hash, stops at the first error
+	ForEachUser(ctx context.Context, fn func(User) error) error                // Calls fn for every registered user, stops at the first error
+	PurgeDeleted(ctx context.Context) (int, error)                             // Removes soft-deleted URLs and returns their number
 }
 
 // InitStorage creates a storage based on file saving strategy (file or db) and returns it
@@ -0,0 +1,33 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FileStorageUpdateUser(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{FileStoragePath: filepath.Join(t.TempDir(), "urls")}
+
+	st := storage.InitFileStorage(map[string]storage.URL{}, cfg)
+	require.NoError(t, st.SaveUser(ctx, storage.User{UID: "u1", Login: "alice", PasswordHash: "old"}))
+	assert.ErrorIs(t, st.SaveUser(ctx, storage.User{UID: "u1", Login: "bob", PasswordHash: "old"}), storage.ErrUserExists)
+	assert.ErrorIs(t, st.SaveUser(ctx, storage.User{UID: "u2", Login: "alice", PasswordHash: "old"}), storage.ErrUserExists)
+
+	assert.ErrorIs(t, st.UpdateUser(ctx, storage.User{Login: "bob", PasswordHash: "new"}), storage.ErrNotFound)
+	require.NoError(t, st.UpdateUser(ctx, storage.User{UID: "ignored", Login: "alice", PasswordHash: "new", SessionVersion: 2}))
+
+	// the updated user overrides the saved one when the storage is reopened
+	reopened := storage.InitFileStorage(nil, cfg)
+
+	u, err := reopened.GetUserByUID(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, storage.User{UID: "u1", Login: "alice", PasswordHash: "new", SessionVersion: 2}, u)
+}
@@ -0,0 +1,224 @@
+// Package tlsutil builds the TLS config of the server, generates self-signed certificates for development
+// and contains the HSTS MW and the HTTP to HTTPS redirect handler
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is how long generated certificates are valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Options of the server TLS config
+type Options struct {
+	CertFile     string   // PEM certificate chain
+	KeyFile      string   // PEM private key
+	SelfSigned   bool     // generate a certificate for Hosts, it is saved to CertFile and KeyFile if they are set and missing
+	Hosts        []string // host names and IPs of a generated certificate
+	MinVersion   string   // 1.0, 1.1, 1.2 or 1.3, 1.2 if empty
+	CipherSuites []string // names of TLS 1.0-1.2 cipher suites, Go defaults if empty
+}
+
+// ParseVersion converts 1.0, 1.1, 1.2 or 1.3 into a tls.Version* constant
+func ParseVersion(s string) (uint16, error) {
+	v, ok := versions[strings.TrimSpace(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls version %q", s)
+	}
+
+	return v, nil
+}
+
+// ParseCipherSuites converts cipher suite names (e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) into ids.
+// Only suites Go considers secure are accepted.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// New builds a server TLS config with a certificate loaded from files or generated
+func New(opts Options) (*tls.Config, error) {
+	minVersion := uint16(tls.VersionTLS12)
+
+	if opts.MinVersion != "" {
+		v, err := ParseVersion(opts.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		minVersion = v
+	}
+
+	suites, err := ParseCipherSuites(opts.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := certificate(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// certificate loads the key pair from files, generating (and saving) a self-signed one if allowed
+func certificate(opts Options) (tls.Certificate, error) {
+	hasFiles := opts.CertFile != "" && opts.KeyFile != ""
+
+	if hasFiles && (!opts.SelfSigned || fileExists(opts.CertFile)) {
+		return tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	}
+
+	if !opts.SelfSigned {
+		return tls.Certificate{}, errors.New("tls certificate and key files are required unless a self-signed certificate is enabled")
+	}
+
+	certPEM, keyPEM, err := SelfSigned(opts.Hosts)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if hasFiles {
+		if err := os.WriteFile(opts.CertFile, certPEM, 0o644); err != nil {
+			return tls.Certificate{}, err
+		}
+
+		if err := os.WriteFile(opts.KeyFile, keyPEM, 0o600); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// SelfSigned generates a PEM encoded ECDSA certificate and key valid for hosts (names or IPs)
+func SelfSigned(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"shortener development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
+
+// HSTS returns a MW adding Strict-Transport-Security to responses sent over TLS, it does nothing if maxAge is 0
+func HSTS(maxAge int) func(next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(maxAge)
+
+	return func(next http.Handler) http.Handler {
+		if maxAge <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RedirectHandler redirects every request to the same path and query under baseURL.
+// GET and HEAD get 301, other methods 308 so that clients repeat the body.
+func RedirectHandler(baseURL string) http.Handler {
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+
+		http.Redirect(w, r, baseURL+r.URL.RequestURI(), status)
+	})
+}
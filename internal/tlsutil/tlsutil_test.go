@@ -0,0 +1,122 @@
+package tlsutil_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/tlsutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_New(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	tests := []struct {
+		name    string
+		opts    tlsutil.Options
+		wantMin uint16
+		wantErr bool
+	}{
+		{name: "self-signed in memory", opts: tlsutil.Options{SelfSigned: true, Hosts: []string{"localhost", "127.0.0.1"}}, wantMin: tls.VersionTLS12},
+		{name: "self-signed saved to files", opts: tlsutil.Options{SelfSigned: true, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"}, wantMin: tls.VersionTLS13},
+		{name: "saved files are loaded", opts: tlsutil.Options{CertFile: certFile, KeyFile: keyFile}, wantMin: tls.VersionTLS12},
+		{name: "no certificate", opts: tlsutil.Options{}, wantErr: true},
+		{name: "missing files", opts: tlsutil.Options{CertFile: filepath.Join(dir, "nope.pem"), KeyFile: keyFile}, wantErr: true},
+		{name: "unknown version", opts: tlsutil.Options{SelfSigned: true, MinVersion: "1.4"}, wantErr: true},
+		{name: "insecure cipher", opts: tlsutil.Options{SelfSigned: true, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, wantErr: true},
+		{
+			name:    "cipher suites",
+			opts:    tlsutil.Options{SelfSigned: true, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+			wantMin: tls.VersionTLS12,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tlsutil.New(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMin, cfg.MinVersion)
+			assert.Len(t, cfg.Certificates, 1)
+			assert.Len(t, cfg.CipherSuites, len(tt.opts.CipherSuites))
+		})
+	}
+}
+
+func Test_SelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := tlsutil.SelfSigned([]string{"sho.rt", "127.0.0.1"})
+	assert.NoError(t, err)
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	assert.NoError(t, err)
+	assert.NoError(t, cert.VerifyHostname("sho.rt"))
+	assert.NoError(t, cert.VerifyHostname("127.0.0.1"))
+	assert.Error(t, cert.VerifyHostname("example.com"))
+}
+
+func Test_HSTS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		maxAge int
+		tls    bool
+		want   string
+	}{
+		{name: "over tls", maxAge: 600, tls: true, want: "max-age=600"},
+		{name: "plain http", maxAge: 600},
+		{name: "disabled", tls: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.tls {
+				request.TLS = &tls.ConnectionState{}
+			}
+
+			w := httptest.NewRecorder()
+			tlsutil.HSTS(tt.maxAge)(next).ServeHTTP(w, request)
+
+			assert.Equal(t, tt.want, w.Header().Get("Strict-Transport-Security"))
+		})
+	}
+}
+
+func Test_RedirectHandler(t *testing.T) {
+	h := tlsutil.RedirectHandler("https://sho.rt/")
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantLoc    string
+	}{
+		{name: "get", method: http.MethodGet, target: "/e62e2446", wantStatus: http.StatusMovedPermanently, wantLoc: "https://sho.rt/e62e2446"},
+		{name: "post keeps method", method: http.MethodPost, target: "/api/shorten?x=1", wantStatus: http.StatusPermanentRedirect, wantLoc: "https://sho.rt/api/shorten?x=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantLoc, w.Header().Get("Location"))
+		})
+	}
+}
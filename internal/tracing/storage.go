@@ -0,0 +1,211 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedStorage starts a span for every storage call
+type tracedStorage struct {
+	storage.Storage
+	tracer trace.Tracer
+}
+
+// TraceStorage wraps st so that each of its operations is recorded as a child span of the caller
+func TraceStorage(st storage.Storage) storage.Storage {
+	return &tracedStorage{st, otel.Tracer(instrumentationName)}
+}
+
+func (s *tracedStorage) start(ctx context.Context, op string) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "storage."+op, trace.WithSpanKind(trace.SpanKindInternal))
+}
+
+// end records err on span unless it is a plain miss and ends the span
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+func (s *tracedStorage) SaveURL(ctx context.Context, u storage.URL) (err error) {
+	ctx, span := s.start(ctx, "SaveURL")
+	defer func() { end(span, err) }()
+
+	return s.Storage.SaveURL(ctx, u)
+}
+
+func (s *tracedStorage) GetURL(ctx context.Context, domain, hash string) (u storage.URL, err error) {
+	ctx, span := s.start(ctx, "GetURL")
+	defer func() { end(span, err) }()
+
+	return s.Storage.GetURL(ctx, domain, hash)
+}
+
+func (s *tracedStorage) GetUrlsByUID(ctx context.Context, uid string) (urls []storage.URL, err error) {
+	ctx, span := s.start(ctx, "GetUrlsByUID")
+	defer func() { end(span, err) }()
+
+	return s.Storage.GetUrlsByUID(ctx, uid)
+}
+
+func (s *tracedStorage) QueryURLs(ctx context.Context, q storage.URLQuery) (urls []storage.URL, err error) {
+	ctx, span := s.start(ctx, "QueryURLs")
+	defer func() { end(span, err) }()
+
+	return s.Storage.QueryURLs(ctx, q)
+}
+
+func (s *tracedStorage) IsAlive(ctx context.Context) (ok bool, err error) {
+	ctx, span := s.start(ctx, "IsAlive")
+	defer func() { end(span, err) }()
+
+	return s.Storage.IsAlive(ctx)
+}
+
+func (s *tracedStorage) IsWritable(ctx context.Context) (ok bool, err error) {
+	ctx, span := s.start(ctx, "IsWritable")
+	defer func() { end(span, err) }()
+
+	return s.Storage.IsWritable(ctx)
+}
+
+func (s *tracedStorage) CheckSchema(ctx context.Context) (err error) {
+	ctx, span := s.start(ctx, "CheckSchema")
+	defer func() { end(span, err) }()
+
+	return s.Storage.CheckSchema(ctx)
+}
+
+func (s *tracedStorage) BatchSaveURL(ctx context.Context, urls []storage.URL) (err error) {
+	ctx, span := s.start(ctx, "BatchSaveURL")
+	defer func() { end(span, err) }()
+
+	return s.Storage.BatchSaveURL(ctx, urls)
+}
+
+func (s *tracedStorage) DeleteURLs(ctx context.Context, entries []storage.DeletionEntry) (deleted []storage.URL, err error) {
+	ctx, span := s.start(ctx, "DeleteURLs")
+	defer func() { end(span, err) }()
+
+	return s.Storage.DeleteURLs(ctx, entries)
+}
+
+func (s *tracedStorage) SaveUser(ctx context.Context, u storage.User) (err error) {
+	ctx, span := s.start(ctx, "SaveUser")
+	defer func() { end(span, err) }()
+
+	return s.Storage.SaveUser(ctx, u)
+}
+
+func (s *tracedStorage) GetUserByLogin(ctx context.Context, login string) (u storage.User, err error) {
+	ctx, span := s.start(ctx, "GetUserByLogin")
+	defer func() { end(span, err) }()
+
+	return s.Storage.GetUserByLogin(ctx, login)
+}
+
+func (s *tracedStorage) GetUserByUID(ctx context.Context, uid string) (u storage.User, err error) {
+	ctx, span := s.start(ctx, "GetUserByUID")
+	defer func() { end(span, err) }()
+
+	return s.Storage.GetUserByUID(ctx, uid)
+}
+
+func (s *tracedStorage) UpdateUser(ctx context.Context, u storage.User) (err error) {
+	ctx, span := s.start(ctx, "UpdateUser")
+	defer func() { end(span, err) }()
+
+	return s.Storage.UpdateUser(ctx, u)
+}
+
+func (s *tracedStorage) ReassignURLs(ctx context.Context, fromUID, toUID string) (n int, err error) {
+	ctx, span := s.start(ctx, "ReassignURLs")
+	defer func() { end(span, err) }()
+
+	return s.Storage.ReassignURLs(ctx, fromUID, toUID)
+}
+
+func (s *tracedStorage) CreateWorkspace(ctx context.Context, ws storage.Workspace, ownerUID string) (err error) {
+	ctx, span := s.start(ctx, "CreateWorkspace")
+	defer func() { end(span, err) }()
+
+	return s.Storage.CreateWorkspace(ctx, ws, ownerUID)
+}
+
+func (s *tracedStorage) GetWorkspacesByUID(ctx context.Context, uid string) (ws []storage.Workspace, err error) {
+	ctx, span := s.start(ctx, "GetWorkspacesByUID")
+	defer func() { end(span, err) }()
+
+	return s.Storage.GetWorkspacesByUID(ctx, uid)
+}
+
+func (s *tracedStorage) GetMembers(ctx context.Context, workspaceID string) (m []storage.Member, err error) {
+	ctx, span := s.start(ctx, "GetMembers")
+	defer func() { end(span, err) }()
+
+	return s.Storage.GetMembers(ctx, workspaceID)
+}
+
+func (s *tracedStorage) GetRole(ctx context.Context, workspaceID, uid string) (role string, err error) {
+	ctx, span := s.start(ctx, "GetRole")
+	defer func() { end(span, err) }()
+
+	return s.Storage.GetRole(ctx, workspaceID, uid)
+}
+
+func (s *tracedStorage) SetMember(ctx context.Context, m storage.Member) (err error) {
+	ctx, span := s.start(ctx, "SetMember")
+	defer func() { end(span, err) }()
+
+	return s.Storage.SetMember(ctx, m)
+}
+
+func (s *tracedStorage) RemoveMember(ctx context.Context, workspaceID, uid string) (err error) {
+	ctx, span := s.start(ctx, "RemoveMember")
+	defer func() { end(span, err) }()
+
+	return s.Storage.RemoveMember(ctx, workspaceID, uid)
+}
+
+func (s *tracedStorage) GetUrlsByWorkspace(ctx context.Context, workspaceID string) (urls []storage.URL, err error) {
+	ctx, span := s.start(ctx, "GetUrlsByWorkspace")
+	defer func() { end(span, err) }()
+
+	return s.Storage.GetUrlsByWorkspace(ctx, workspaceID)
+}
+
+func (s *tracedStorage) ForEachURL(ctx context.Context, fn func(storage.URL) error) (err error) {
+	ctx, span := s.start(ctx, "ForEachURL")
+	defer func() { end(span, err) }()
+
+	return s.Storage.ForEachURL(ctx, fn)
+}
+
+func (s *tracedStorage) ForEachUserURL(ctx context.Context, uid string, fn func(storage.URL) error) (err error) {
+	ctx, span := s.start(ctx, "ForEachUserURL")
+	defer func() { end(span, err) }()
+
+	return s.Storage.ForEachUserURL(ctx, uid, fn)
+}
+
+func (s *tracedStorage) ForEachUser(ctx context.Context, fn func(storage.User) error) (err error) {
+	ctx, span := s.start(ctx, "ForEachUser")
+	defer func() { end(span, err) }()
+
+	return s.Storage.ForEachUser(ctx, fn)
+}
+
+func (s *tracedStorage) PurgeDeleted(ctx context.Context) (n int, err error) {
+	ctx, span := s.start(ctx, "PurgeDeleted")
+	defer func() { end(span, err) }()
+
+	return s.Storage.PurgeDeleted(ctx)
+}
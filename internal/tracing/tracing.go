@@ -0,0 +1,110 @@
+// Package tracing sets up OpenTelemetry tracing and contains the HTTP MW and storage decorator creating spans
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is reported as the service.name resource attribute
+const ServiceName = "shortener"
+
+const instrumentationName = "github.com/T-V-N/gourlshortener/internal/tracing"
+
+// Init installs the global tracer provider with the exporter chosen by cfg.TraceExporter (none, stdout or otlp)
+// and the W3C trace context propagator. The returned function flushes and stops the provider.
+func Init(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch strings.ToLower(cfg.TraceExporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+		}
+
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.TraceExporter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Middleware extracts the incoming trace context, starts a server span per request named after the chi route
+// pattern and adds the trace id to the request-scoped logger
+func Middleware(next http.Handler) http.Handler {
+	tracer := otel.Tracer(instrumentationName)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		))
+		defer span.End()
+
+		if span.SpanContext().IsValid() {
+			ctx = logger.Annotate(ctx, "trace_id", span.SpanContext().TraceID().String())
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/T-V-N/gourlshortener/internal/config"
+	"github.com/T-V-N/gourlshortener/internal/storage"
+	"github.com/T-V-N/gourlshortener/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func Test_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	st := tracing.TraceStorage(storage.InitFileStorage(nil, &config.Config{}))
+
+	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Get("/{urlHash}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := st.GetURL(r.Context(), "", chi.URLParam(r, "urlHash")); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+
+	t.Run("server span continues the incoming trace", func(t *testing.T) {
+		assert.NoError(t, st.SaveURL(context.Background(), storage.URL{ShortURL: "e62e2446", URL: "https://youtube.com"}))
+
+		request := httptest.NewRequest(http.MethodGet, "/e62e2446", nil)
+		request.Header.Set("traceparent", traceParent)
+
+		router.ServeHTTP(httptest.NewRecorder(), request)
+
+		spans := recorder.Ended()
+		assert.Len(t, spans, 3)
+
+		storageSpan, serverSpan := spans[1], spans[2]
+
+		assert.Equal(t, "storage.GetURL", storageSpan.Name())
+		assert.Equal(t, "GET /{urlHash}", serverSpan.Name())
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.SpanContext().TraceID().String())
+		assert.Equal(t, serverSpan.SpanContext().SpanID(), storageSpan.Parent().SpanID())
+		assert.Equal(t, codes.Unset, serverSpan.Status().Code)
+	})
+
+	t.Run("server errors mark the span", func(t *testing.T) {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/16358727", nil))
+
+		spans := recorder.Ended()
+		serverSpan := spans[len(spans)-1]
+
+		assert.Equal(t, codes.Error, serverSpan.Status().Code)
+		assert.False(t, serverSpan.Parent().IsValid())
+	})
+
+	t.Run("unknown exporter is rejected", func(t *testing.T) {
+		_, err := tracing.Init(context.Background(), &config.Config{TraceExporter: "zipkin"})
+		assert.Error(t, err)
+	})
+}